@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"utilserver/pkg/clients"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/spotify"
+	"utilserver/pkg/storage"
+
+	"github.com/joho/godotenv"
+)
+
+func init() {
+	// load env if development mode
+	if os.Getenv("ENV") == "development" {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatal("Error loading .env file")
+		}
+	}
+}
+
+// import streaming history files or zipped privacy export of a user
+//
+//	go run ./boot/import -user 5f8d0d55b54764421b7156c3 my_spotify_data.zip
+func main() {
+	userID := flag.String("user", "", "id of the user whose history is imported")
+	flag.Parse()
+	if *userID == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: import -user <user id> <StreamingHistory*.json | endsong_*.json | export.zip>...")
+		os.Exit(2)
+	}
+
+	cache, err := storage.NewCache(os.Getenv("REDIS_CONNECTION_STRING"))
+	if err != nil {
+		panic(err)
+	}
+	storage, err := storage.NewStorage(os.Getenv("MONGODB_CONNECTION_STRING"), os.Getenv("MONGODB_DATABASE"))
+	if err != nil {
+		panic(err)
+	}
+	// spotify requests are only logged when asked for
+	level := logging.LevelWarn
+	if os.Getenv("LOG_LEVEL") != "" {
+		level = logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	}
+	logger := logging.New(os.Stderr, level, logging.FormatText)
+	httpConfig, err := clients.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	httpClient, err := clients.New(httpConfig, logger)
+	if err != nil {
+		panic(err)
+	}
+	// imports must not take quota interactive requests of the server need
+	Services := spotify.NewServices(storage, httpClient, cache, nil, logger).WithPriority(spotify.PriorityBackground)
+
+	plays := []spotify.Play{}
+	for _, name := range flag.Args() {
+		filePlays, err := parseFile(name)
+		if err != nil {
+			log.Fatal(name, ": ", err)
+		}
+		log.Println(name, "-", len(filePlays), "plays")
+		plays = append(plays, filePlays...)
+	}
+	result, err := Services.History.ImportHistory(*userID, plays)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("parsed %d, imported %d, duplicates %d, resolved %d, unresolved %d, pending %d\n",
+		result.Parsed, result.Imported, result.Duplicates, result.Resolved, result.Unresolved, result.Pending)
+}
+
+func parseFile(name string) ([]spotify.Play, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if filepath.Ext(name) == ".zip" {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return spotify.ParseStreamingHistoryArchive(file, info.Size())
+	}
+	return spotify.ParseStreamingHistory(file)
+}
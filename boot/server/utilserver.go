@@ -1,14 +1,26 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
+	"utilserver/pkg/accounts"
 	"utilserver/pkg/clients"
 	"utilserver/pkg/endpoint"
+	"utilserver/pkg/health"
+	"utilserver/pkg/jobs"
+	"utilserver/pkg/lastfm"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/nowplaying"
+	"utilserver/pkg/ratelimit"
 	"utilserver/pkg/spotify"
 	"utilserver/pkg/storage"
+	"utilserver/pkg/tracing"
+	"utilserver/pkg/webhooks"
 
 	"github.com/gorilla/handlers"
 	"github.com/joho/godotenv"
@@ -24,7 +36,19 @@ func init() {
 	}
 }
 
+// version of the build, set with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	logger := logging.New(os.Stdout, logging.ParseLevel(os.Getenv("LOG_LEVEL")), os.Getenv("LOG_FORMAT"))
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.ConfigFromEnv())
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	cache, err := storage.NewCache(os.Getenv("REDIS_CONNECTION_STRING"))
 	if err != nil {
 		panic(err)
@@ -33,15 +57,99 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	httpClient := clients.New(5)
-	Services := spotify.NewServices(storage, httpClient, cache)
+	if err := cache.MigrateToUserIDs(storage.UserIDOfEmail); err != nil {
+		panic(err)
+	}
+	httpConfig, err := clients.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	httpClient, err := clients.New(httpConfig, logger)
+	if err != nil {
+		panic(err)
+	}
+	hooks, err := webhooks.NewService(storage, httpConfig, logger)
+	if err != nil {
+		panic(err)
+	}
+	Services := spotify.NewServices(storage, httpClient, cache, hooks, logger)
 
-	router := endpoint.NewHandler(cache, Services)
+	// jobs leave a share of spotify quota of the app to requests of users
+	background := Services.WithPriority(spotify.PriorityBackground)
+
+	providers := []accounts.Provider{accounts.NewSpotifyProvider(Services.Auth)}
+	var scrobbler *lastfm.Service
+	if os.Getenv("LASTFM_API_KEY") != "" {
+		lastfmClient := lastfm.New(httpClient, os.Getenv("LASTFM_API_KEY"), os.Getenv("LASTFM_API_SECRET"))
+		scrobbler = lastfm.NewService(lastfmClient, storage, background.History, logger)
+		providers = append(providers, accounts.NewLastFMProvider(lastfmClient, scrobbler, os.Getenv("LASTFM_CALLBACK_URL"), logger))
+	}
+	if os.Getenv("OIDC_ISSUER") != "" {
+		providers = append(providers, accounts.NewOIDCProvider(accounts.OIDCConfig{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       os.Getenv("OIDC_SCOPES"),
+		}, httpClient))
+	}
+	accountsService := accounts.NewService(storage, cache, Services.Auth, hooks, providers...)
+
+	pollInterval, err := strconv.Atoi(os.Getenv("NOW_PLAYING_POLL_INTERVAL"))
+	if err != nil {
+		pollInterval = 5
+	}
+	nowPlaying := nowplaying.NewHub(Services.Player, cache, time.Duration(pollInterval)*time.Second, logger)
+
+	snapshotInterval, err := strconv.Atoi(os.Getenv("PLAYLIST_SNAPSHOT_INTERVAL"))
+	if err != nil {
+		snapshotInterval = 360
+	}
+	ingestInterval, err := strconv.Atoi(os.Getenv("HISTORY_INGEST_INTERVAL"))
+	if err != nil {
+		ingestInterval = 30
+	}
+	scheduler := jobs.NewScheduler(cache, logger)
+	ingestListeners := []spotify.PlaysListener{}
+	if scrobbler != nil {
+		ingestListeners = append(ingestListeners, scrobbler.ScrobblePlays)
+		scheduler.Every("lastfm-backfill", time.Hour, scrobbler.BackfillAll)
+	}
+	scheduler.Every("history-ingest", time.Duration(ingestInterval)*time.Minute, func() error {
+		return background.History.IngestAllRecentlyPlayed(ingestListeners...)
+	})
+	scheduler.Every("history-resolve", time.Minute, background.History.ResolvePendingPlays)
+	scheduler.Every("playlist-snapshots", time.Duration(snapshotInterval)*time.Minute, background.Playlists.SnapshotAllPlaylists)
+	scheduler.Every("exports", 10*time.Second, background.Export.RunPendingExports)
+	scheduler.Every("export-cleanup", time.Hour, background.Export.DeleteExpiredExports)
+	scheduler.Every("webhook-deliveries", 30*time.Second, hooks.DeliverDue)
+	healthService := health.NewService(version, storage, cache, httpClient, os.Getenv("SPOTIFY_TOKEN_GENERATOR_ENTPOINT"))
+	scheduler.Every("spotify-health-check", time.Minute, healthService.CheckSpotify)
+	scheduler.Start()
+
+	rateLimits, err := ratelimit.LoadConfig()
+	if err != nil {
+		panic(err)
+	}
+	limiter := ratelimit.NewLimiter(cache, rateLimits)
+
+	router := endpoint.NewHandler(cache, Services, accountsService, scrobbler, hooks, nowPlaying, healthService, limiter, logger)
+
+	// metrics are served apart from the api so they aren't reachable through its public port
+	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" {
+		go func() {
+			metricsRouter := http.NewServeMux()
+			metricsRouter.Handle("/metrics", metrics.Handler())
+			logger.Info("serving metrics", "port", metricsPort)
+			log.Fatal(http.ListenAndServe(":"+metricsPort, metricsRouter))
+		}()
+	}
 
-	fmt.Printf("Starting server at port %s\n", os.Getenv("PORT"))
+	logger.Info("starting server", "port", os.Getenv("PORT"), "version", version)
 	// allow CORS and start listening
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", endpoint.RequestIDHeader, "traceparent", "tracestate"})
+	exposedOk := handlers.ExposedHeaders([]string{endpoint.RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"})
 	originsOk := handlers.AllowedOrigins([]string{"*"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
-	log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), handlers.CORS(originsOk, headersOk, methodsOk)(router)))
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
+	log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), handlers.CORS(originsOk, headersOk, exposedOk, methodsOk)(router)))
 }
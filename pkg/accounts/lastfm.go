@@ -0,0 +1,71 @@
+package accounts
+
+import (
+	"errors"
+	"net/url"
+	"utilserver/pkg/lastfm"
+	"utilserver/pkg/logging"
+)
+
+// LastFMProvider - last.fm web auth, session key of the account is kept with last.fm profile
+type LastFMProvider struct {
+	client   *lastfm.Client
+	service  *lastfm.Service
+	callback string
+	logger   *logging.Logger
+}
+
+func NewLastFMProvider(client *lastfm.Client, service *lastfm.Service, callback string, logger *logging.Logger) *LastFMProvider {
+	return &LastFMProvider{client: client, service: service, callback: callback, logger: logger}
+}
+
+func (provider *LastFMProvider) Name() string {
+	return ProviderLastFM
+}
+
+// LoginURL - last.fm has no state parameter, state travels in the callback url
+func (provider *LastFMProvider) LoginURL(state string) (string, error) {
+	callback, err := url.Parse(provider.callback)
+	if err != nil {
+		return "", err
+	}
+	query := callback.Query()
+	query.Set("state", state)
+	callback.RawQuery = query.Encode()
+	return provider.client.AuthURL(callback.String()), nil
+}
+
+func (provider *LastFMProvider) Exchange(params url.Values) (*Identity, error) {
+	token := params.Get("token")
+	if token == "" {
+		return nil, errors.New("last.fm login failed: token expected")
+	}
+	session, err := provider.client.GetSession(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Provider:    ProviderLastFM,
+		Subject:     session.Name,
+		DisplayName: session.Name,
+		Credentials: map[string]string{"session_key": session.Key},
+	}, nil
+}
+
+// Linked - store session of the account and import its scrobbles into listening history
+func (provider *LastFMProvider) Linked(userID string, identity *Identity) error {
+	if err := provider.service.Connect(userID, identity.Subject, identity.Credentials["session_key"]); err != nil {
+		return err
+	}
+	go func() {
+		if _, err := provider.service.Backfill(userID); err != nil {
+			provider.logger.Warn("last.fm backfill failed", "user_id", userID, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Unlinked - session of unlinked account is dropped, imported scrobbles stay in history
+func (provider *LastFMProvider) Unlinked(userID string) error {
+	return provider.service.Disconnect(userID)
+}
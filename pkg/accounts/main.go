@@ -0,0 +1,127 @@
+package accounts
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// providers of linked accounts
+const (
+	ProviderSpotify = "spotify"
+	ProviderLastFM  = "lastfm"
+	ProviderOIDC    = "oidc"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidState    = errors.New("invalid or expired login state")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrAccountLinked   = errors.New("account is already linked to another user")
+	ErrProviderLinked  = errors.New("an account of this provider is already linked, unlink it first")
+	ErrNotLinked       = errors.New("no account of this provider is linked")
+	ErrLastAccount     = errors.New("the only linked account of user can't be unlinked")
+)
+
+// User - identity of a person using the server, every way of logging in is a linked account
+type User struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email       string             `bson:"email,omitempty" json:"email,omitempty"`
+	DisplayName string             `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	Accounts    []LinkedAccount    `bson:"accounts" json:"accounts"`
+}
+
+// Account - linked account of provider, nil if user hasn't linked one
+func (user User) Account(provider string) *LinkedAccount {
+	for i := range user.Accounts {
+		if user.Accounts[i].Provider == provider {
+			return &user.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// LinkedAccount - account of an identity provider linked to user, key joins provider and subject
+// and is set by storage for the unique index of linked accounts
+type LinkedAccount struct {
+	Provider    string    `bson:"provider" json:"provider"`
+	Subject     string    `bson:"subject" json:"subject"`
+	Key         string    `bson:"key" json:"-"`
+	Email       string    `bson:"email,omitempty" json:"email,omitempty"`
+	DisplayName string    `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	LinkedAt    time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// Identity - account reported by provider after user went through its login
+type Identity struct {
+	Provider    string
+	Subject     string
+	Email       string
+	DisplayName string
+	// Credentials - secrets of the account, providers keep them when it's linked
+	Credentials map[string]string
+	// Account - data of the account fetched by provider, kept only once the account is linked
+	Account interface{}
+}
+
+// Provider - external service users log in or link accounts with
+type Provider interface {
+	Name() string
+	// LoginURL - page of provider user is redirected to, state comes back to the callback
+	LoginURL(state string) (string, error)
+	// Exchange - identity of user from query parameters of the callback
+	Exchange(params url.Values) (*Identity, error)
+}
+
+// Linker - provider keeping own data of accounts, told when an account gets or loses its user
+type Linker interface {
+	Linked(userID string, identity *Identity) error
+	Unlinked(userID string) error
+}
+
+type Storage interface {
+	CreateUser(user User) (*User, error)
+	GetUser(id string) (*User, error)
+	FindUserByAccount(provider string, subject string) (*User, error)
+	LinkAccount(userID string, account LinkedAccount) error
+	UpdateLinkedAccount(userID string, account LinkedAccount) error
+	UnlinkAccount(userID string, provider string) error
+	DeleteUser(id string) error
+}
+
+type Cache interface {
+	Get(key string) (interface{}, error)
+	Set(key string, value interface{}, expiration time.Duration) error
+	Clear(key string) error
+}
+
+// Sessions - revocation of sessions, links started by a revoked session aren't finished
+type Sessions interface {
+	SessionRevoked(userID string, issuedAt time.Time) (bool, error)
+}
+
+// Session - logged in session of user
+type Session struct {
+	UserID   string
+	IssuedAt time.Time
+}
+
+type HTTPClient interface {
+	Request(methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error)
+}
+
+// LoginEvent - data of event published when user logs in
+type LoginEvent struct {
+	Provider string `json:"provider"`
+	NewUser  bool   `json:"new_user"`
+}
+
+type LoginResponse struct {
+	Token string `json:"token"`
+	User  *User  `json:"user"`
+}
@@ -0,0 +1,161 @@
+package accounts
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OIDCConfig - client registration at an openid connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       string
+}
+
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// OIDCProvider - generic openid connect login with authorization code flow. Identity comes from
+// userinfo endpoint called with access token received directly from the provider
+type OIDCProvider struct {
+	config     OIDCConfig
+	httpClient HTTPClient
+	lock       sync.Mutex
+	discovery  *oidcDiscovery
+}
+
+func NewOIDCProvider(config OIDCConfig, httpClient HTTPClient) *OIDCProvider {
+	if config.Scopes == "" {
+		config.Scopes = "openid email profile"
+	}
+	return &OIDCProvider{config: config, httpClient: httpClient}
+}
+
+func (provider *OIDCProvider) Name() string {
+	return ProviderOIDC
+}
+
+// discover - endpoints of provider, fetched once they are needed and kept afterwards
+func (provider *OIDCProvider) discover() (*oidcDiscovery, error) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+	var discovery oidcDiscovery
+	URL := strings.TrimSuffix(provider.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := provider.getJSON(URL, "", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserinfoEndpoint == "" {
+		return nil, errors.New("oidc: incomplete discovery document of " + provider.config.Issuer)
+	}
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+func (provider *OIDCProvider) getJSON(URL string, auth string, result interface{}) error {
+	resp, err := provider.httpClient.Request("GET", URL, nil, "application/json", auth)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return errors.New("oidc: " + URL + " failed with status " + strconv.Itoa(resp.StatusCode))
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (provider *OIDCProvider) LoginURL(state string) (string, error) {
+	discovery, err := provider.discover()
+	if err != nil {
+		return "", err
+	}
+	base, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	parm := base.Query()
+	parm.Set("state", state)
+	parm.Set("client_id", provider.config.ClientID)
+	parm.Set("scope", provider.config.Scopes)
+	parm.Set("response_type", "code")
+	parm.Set("redirect_uri", provider.config.RedirectURL)
+	base.RawQuery = parm.Encode()
+	return base.String(), nil
+}
+
+func (provider *OIDCProvider) Exchange(params url.Values) (*Identity, error) {
+	if reason := params.Get("error"); reason != "" {
+		return nil, errors.New("oidc login failed: " + reason)
+	}
+	discovery, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := provider.httpClient.Request("POST", discovery.TokenEndpoint,
+		map[string]interface{}{
+			"grant_type":    "authorization_code",
+			"code":          params.Get("code"),
+			"redirect_uri":  provider.config.RedirectURL,
+			"client_id":     provider.config.ClientID,
+			"client_secret": provider.config.ClientSecret,
+		},
+		"application/x-www-form-urlencoded",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.AccessToken == "" {
+		return nil, errors.New("oidc: token exchange failed " + tokens.Error)
+	}
+	var userinfo struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := provider.getJSON(discovery.UserinfoEndpoint, "Bearer "+tokens.AccessToken, &userinfo); err != nil {
+		return nil, err
+	}
+	if userinfo.Subject == "" {
+		return nil, errors.New("oidc: userinfo without subject")
+	}
+	identity := &Identity{
+		Provider:    ProviderOIDC,
+		Subject:     userinfo.Subject,
+		DisplayName: userinfo.Name,
+	}
+	// unverified email isn't trusted as contact of the user
+	if userinfo.EmailVerified {
+		identity.Email = userinfo.Email
+	}
+	return identity, nil
+}
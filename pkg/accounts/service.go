@@ -0,0 +1,249 @@
+package accounts
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+	"utilserver/pkg/spotify"
+	"utilserver/pkg/webhooks"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// time user has to finish login at provider
+const loginStateTTL = 10 * time.Minute
+
+// loginState - pending login or link kept in cache under state sent to provider
+type loginState struct {
+	Provider   string `json:"provider"`
+	Redirect   string `json:"redirect,omitempty"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+	// LinkIssuedAt - issue time of the session which started linking, unix seconds
+	LinkIssuedAt int64 `json:"link_issued_at,omitempty"`
+}
+
+func loginStateKey(state string) string {
+	return "auth:state:" + state
+}
+
+// Service - users and their linked accounts
+type Service struct {
+	storage   Storage
+	cache     Cache
+	sessions  Sessions
+	publisher spotify.Publisher
+	providers map[string]Provider
+}
+
+// NewService - users service, publisher may be nil
+func NewService(storage Storage, cache Cache, sessions Sessions, publisher spotify.Publisher, providers ...Provider) *Service {
+	service := &Service{storage: storage, cache: cache, sessions: sessions, publisher: publisher, providers: map[string]Provider{}}
+	for _, provider := range providers {
+		service.providers[provider.Name()] = provider
+	}
+	return service
+}
+
+// Providers - names of configured providers
+func (service *Service) Providers() []string {
+	names := make([]string, 0, len(service.providers))
+	for name := range service.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin - login page of provider and state of the login, the account is linked to user of
+// link instead of logging in when link is set. The link is bound to its session, it fails when
+// the session is revoked before the user comes back from the provider
+func (service *Service) StartLogin(providerName string, redirect string, link *Session) (string, string, error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	state := shortuuid.New()
+	pending := loginState{Provider: providerName, Redirect: redirect}
+	if link != nil {
+		pending.LinkUserID = link.UserID
+		pending.LinkIssuedAt = link.IssuedAt.Unix()
+	}
+	stateByteArr, err := json.Marshal(pending)
+	if err != nil {
+		return "", "", err
+	}
+	if err := service.cache.Set(loginStateKey(state), string(stateByteArr), loginStateTTL); err != nil {
+		return "", "", err
+	}
+	loginURL, err := provider.LoginURL(state)
+	if err != nil {
+		return "", "", err
+	}
+	return loginURL, state, nil
+}
+
+// FinishLogin - handle callback of provider, log in or create the user owning the account
+// or link it to the user who started linking. Returns token of the user and redirect of the login
+func (service *Service) FinishLogin(providerName string, state string, params url.Values) (*LoginResponse, string, error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+	stored, err := service.cache.Get(loginStateKey(state))
+	if err != nil || state == "" {
+		return nil, "", ErrInvalidState
+	}
+	service.cache.Clear(loginStateKey(state))
+	storedState, _ := stored.(string)
+	var pending loginState
+	if err := json.Unmarshal([]byte(storedState), &pending); err != nil || pending.Provider != providerName {
+		return nil, "", ErrInvalidState
+	}
+	if pending.LinkUserID != "" {
+		revoked, err := service.sessions.SessionRevoked(pending.LinkUserID, time.Unix(pending.LinkIssuedAt, 0))
+		if err != nil {
+			return nil, "", err
+		}
+		if revoked {
+			return nil, "", ErrInvalidState
+		}
+	}
+
+	identity, err := provider.Exchange(params)
+	if err != nil {
+		return nil, "", err
+	}
+	account := LinkedAccount{
+		Provider:    providerName,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		DisplayName: identity.DisplayName,
+		LinkedAt:    time.Now(),
+	}
+	owner, err := service.storage.FindUserByAccount(providerName, identity.Subject)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user *User
+	created := false
+	switch {
+	case pending.LinkUserID != "":
+		user, err = service.link(pending.LinkUserID, owner, account)
+	case owner != nil:
+		account.LinkedAt = owner.Account(providerName).LinkedAt
+		err = service.storage.UpdateLinkedAccount(owner.ID.Hex(), account)
+		user = owner
+	default:
+		created = true
+		user, err = service.storage.CreateUser(User{
+			Email:       identity.Email,
+			DisplayName: identity.DisplayName,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+			Accounts:    []LinkedAccount{account},
+		})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if linker, ok := provider.(Linker); ok {
+		if err := linker.Linked(user.ID.Hex(), identity); err != nil {
+			return nil, "", err
+		}
+	}
+	user, err = service.storage.GetUser(user.ID.Hex())
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := service.issueToken(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if pending.LinkUserID == "" && service.publisher != nil {
+		service.publisher.Publish(user.ID.Hex(), webhooks.EventUserLoggedIn, LoginEvent{Provider: providerName, NewUser: created})
+	}
+	return &LoginResponse{Token: token, User: user}, pending.Redirect, nil
+}
+
+func (service *Service) link(userID string, owner *User, account LinkedAccount) (*User, error) {
+	user, err := service.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil && owner.ID != user.ID {
+		return nil, ErrAccountLinked
+	}
+	if owner != nil {
+		account.LinkedAt = owner.Account(account.Provider).LinkedAt
+		return user, service.storage.UpdateLinkedAccount(userID, account)
+	}
+	if user.Account(account.Provider) != nil {
+		return nil, ErrProviderLinked
+	}
+	return user, service.storage.LinkAccount(userID, account)
+}
+
+// issueToken - session token of user
+func (service *Service) issueToken(user *User) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, spotify.CustomClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.Hex(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 1)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	return token.SignedString([]byte(os.Getenv("SECRET")))
+}
+
+// GetUser - user with linked accounts
+func (service *Service) GetUser(userID string) (*User, error) {
+	user, err := service.storage.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// UnlinkAccount - remove linked account of provider, the last account can't be removed
+func (service *Service) UnlinkAccount(userID string, providerName string) error {
+	user, err := service.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.Account(providerName) == nil {
+		return ErrNotLinked
+	}
+	if len(user.Accounts) == 1 {
+		return ErrLastAccount
+	}
+	if err := service.storage.UnlinkAccount(userID, providerName); err != nil {
+		return err
+	}
+	if linker, ok := service.providers[providerName].(Linker); ok {
+		return linker.Unlinked(userID)
+	}
+	return nil
+}
+
+// DeleteUser - remove user with all linked accounts, users removed already are left as they are
+func (service *Service) DeleteUser(userID string) error {
+	user, err := service.storage.GetUser(userID)
+	if err != nil || user == nil {
+		return err
+	}
+	for _, account := range user.Accounts {
+		if linker, ok := service.providers[account.Provider].(Linker); ok {
+			if err := linker.Unlinked(userID); err != nil {
+				return err
+			}
+		}
+	}
+	return service.storage.DeleteUser(userID)
+}
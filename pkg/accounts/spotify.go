@@ -0,0 +1,67 @@
+package accounts
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"utilserver/pkg/spotify"
+)
+
+// SpotifyProvider - spotify oauth, credentials of the account are kept with spotify profile
+type SpotifyProvider struct {
+	auth spotify.AuthService
+}
+
+func NewSpotifyProvider(auth spotify.AuthService) *SpotifyProvider {
+	return &SpotifyProvider{auth: auth}
+}
+
+func (provider *SpotifyProvider) Name() string {
+	return ProviderSpotify
+}
+
+func (provider *SpotifyProvider) LoginURL(state string) (string, error) {
+	base, err := url.Parse(os.Getenv("SPOTIFY_LOGIN_ENDPOINT"))
+	if err != nil {
+		return "", err
+	}
+	parm := url.Values{}
+	parm.Add("state", state)
+	parm.Add("client_id", os.Getenv("CLIENT_ID"))
+	parm.Add("scope", os.Getenv("SCOPES"))
+	parm.Add("response_type", "code")
+	parm.Add("redirect_uri", os.Getenv("REDIRECT_URL"))
+	base.RawQuery = parm.Encode()
+	return base.String(), nil
+}
+
+func (provider *SpotifyProvider) Exchange(params url.Values) (*Identity, error) {
+	if reason := params.Get("error"); reason != "" {
+		return nil, errors.New("spotify login failed: " + reason)
+	}
+	profile, err := provider.auth.FetchProfile(params.Get("code"))
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Provider:    ProviderSpotify,
+		Subject:     profile.ProfileID,
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		Account:     profile,
+	}, nil
+}
+
+// Linked - spotify profile of the account with its credentials is stored as profile of the user
+func (provider *SpotifyProvider) Linked(userID string, identity *Identity) error {
+	profile, ok := identity.Account.(*spotify.Profile)
+	if !ok {
+		return errors.New("spotify profile of identity expected")
+	}
+	return provider.auth.SaveProfile(*profile, userID)
+}
+
+// Unlinked - profile and credentials of unlinked account are dropped
+func (provider *SpotifyProvider) Unlinked(userID string) error {
+	return provider.auth.DisconnectProfile(userID)
+}
@@ -0,0 +1,203 @@
+package breaker
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"utilserver/pkg/metrics"
+)
+
+// ErrUnavailable - upstream is considered down, calls to it fail fast. Match with errors.Is
+var ErrUnavailable = errors.New("upstream unavailable")
+
+// UnavailableError - call rejected without reaching upstream because its breaker is open
+type UnavailableError struct {
+	Upstream string
+	// RetryAfter - time until upstream is probed again
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Upstream + ": upstream unavailable"
+}
+
+// Is - every unavailable error matches ErrUnavailable
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// State - state of a breaker, values are exported as metric
+type State int
+
+const (
+	// Closed - calls pass, failures are counted
+	Closed State = iota
+	// HalfOpen - cooldown passed, a single probe call is let through
+	HalfOpen
+	// Open - calls fail fast until cooldown passed
+	Open
+)
+
+func (state State) String() string {
+	switch state {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "closed"
+}
+
+// Breaker - circuit breaker opening after threshold consecutive failures, once cooldown passed a
+// single probe is let through which closes it again when it succeeds
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mutex        sync.Mutex
+	state        State
+	failures     int
+	openedAt     time.Time
+	probing      bool
+	probeStarted time.Time
+}
+
+// New - closed breaker of upstream name
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	metrics.BreakerState.WithLabelValues(name).Set(float64(Closed))
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// NewFromEnv - breaker of upstream name with BREAKER_FAILURE_THRESHOLD and BREAKER_COOLDOWN_SECONDS
+func NewFromEnv(name string) *Breaker {
+	return New(name, failureThreshold(), cooldown())
+}
+
+func failureThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("BREAKER_FAILURE_THRESHOLD"))
+	if err != nil || threshold <= 0 {
+		return 5
+	}
+	return threshold
+}
+
+func cooldown() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("BREAKER_COOLDOWN_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Name - upstream guarded by breaker
+func (breaker *Breaker) Name() string {
+	return breaker.name
+}
+
+// State - current state of breaker
+func (breaker *Breaker) State() State {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breaker.state
+}
+
+// Allow - nil when call may go to upstream, *UnavailableError otherwise. When the call is let
+// through its outcome must be reported with Success or Failure, a probe whose outcome is never
+// reported is given up after cooldown
+func (breaker *Breaker) Allow() error {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	now := time.Now()
+	if breaker.state == Open {
+		if elapsed := now.Sub(breaker.openedAt); elapsed < breaker.cooldown {
+			return &UnavailableError{Upstream: breaker.name, RetryAfter: breaker.cooldown - elapsed}
+		}
+		breaker.setState(HalfOpen)
+		breaker.probing = false
+	}
+	if breaker.state == HalfOpen {
+		if breaker.probing && now.Sub(breaker.probeStarted) < breaker.cooldown {
+			return &UnavailableError{Upstream: breaker.name, RetryAfter: breaker.cooldown - now.Sub(breaker.probeStarted)}
+		}
+		breaker.probing = true
+		breaker.probeStarted = now
+	}
+	return nil
+}
+
+// Success - report call which reached upstream and got an answer
+func (breaker *Breaker) Success() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	// calls started before the breaker opened don't close it
+	if breaker.state == Open {
+		return
+	}
+	breaker.failures = 0
+	breaker.probing = false
+	breaker.setState(Closed)
+}
+
+// Failure - report call which didn't get an answer from upstream
+func (breaker *Breaker) Failure() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	switch breaker.state {
+	case Closed:
+		breaker.failures++
+		if breaker.failures >= breaker.threshold {
+			breaker.open()
+		}
+	case HalfOpen:
+		breaker.open()
+	}
+}
+
+func (breaker *Breaker) open() {
+	breaker.failures = 0
+	breaker.probing = false
+	breaker.openedAt = time.Now()
+	breaker.setState(Open)
+}
+
+func (breaker *Breaker) setState(state State) {
+	breaker.state = state
+	metrics.BreakerState.WithLabelValues(breaker.name).Set(float64(state))
+}
+
+// Group - breakers created on first use by name, sharing threshold and cooldown
+type Group struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup - group of breakers with threshold and cooldown
+func NewGroup(threshold int, cooldown time.Duration) *Group {
+	return &Group{threshold: threshold, cooldown: cooldown, breakers: map[string]*Breaker{}}
+}
+
+// NewGroupFromEnv - group of breakers with BREAKER_FAILURE_THRESHOLD and BREAKER_COOLDOWN_SECONDS
+func NewGroupFromEnv() *Group {
+	return NewGroup(failureThreshold(), cooldown())
+}
+
+// Get - breaker of name, created closed when it's asked for the first time
+func (group *Group) Get(name string) *Breaker {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	breaker, ok := group.breakers[name]
+	if !ok {
+		breaker = New(name, group.threshold, group.cooldown)
+		group.breakers[name] = breaker
+	}
+	return breaker
+}
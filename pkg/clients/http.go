@@ -1,22 +1,41 @@
 package clients
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/tracing"
+
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// HTTPClient - long-lived client whose requests share one pool of connections
 type HTTPClient struct {
-	Timeout int
+	// Timeout - default limit of a request, WithTimeout overrides it per request
+	Timeout time.Duration
+	client  *http.Client
+	logger  *logging.Logger
 }
 
-func New(timeout int) *HTTPClient {
-	return &HTTPClient{Timeout: timeout}
+// New - client with transport of config, fails when tls or proxy settings are invalid
+func New(config Config, logger *logging.Logger) (*HTTPClient, error) {
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	// timeouts are deadlines of request contexts so that they can be overridden per request
+	return &HTTPClient{Timeout: config.Timeout, client: &http.Client{Transport: transport}, logger: logger}, nil
 }
+
 func (client *HTTPClient) constructRequest(
+	ctx context.Context,
 	methodType string,
 	URL string,
 	body map[string]interface{},
@@ -36,7 +55,7 @@ func (client *HTTPClient) constructRequest(
 			bodyByteArr = string(b)
 		}
 	}
-	request, err := http.NewRequest(methodType, URL, strings.NewReader(bodyByteArr))
+	request, err := http.NewRequestWithContext(ctx, methodType, URL, strings.NewReader(bodyByteArr))
 	if err != nil {
 		return nil, err
 	}
@@ -46,23 +65,56 @@ func (client *HTTPClient) constructRequest(
 	return request, nil
 }
 
-func (client *HTTPClient) getClient(sec int) *http.Client {
-	timeout := time.Duration(time.Duration(sec) * time.Second)
-	return &http.Client{
-		Timeout: timeout,
-	}
-}
-
 // Request - http request with parameters and return http response from endpoint
 func (client *HTTPClient) Request(methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error) {
-	request, err := client.constructRequest(methodType, URL, body, contentType, auth)
+	return client.RequestWithContext(context.Background(), methodType, URL, body, contentType, auth)
+}
+
+// RequestWithContext - Request traced as child of span in ctx, trace is passed on in W3C headers.
+// Request including reading its body is limited to timeout of ctx set by WithTimeout or to
+// timeout of client, response body must be closed
+func (client *HTTPClient) RequestWithContext(ctx context.Context, methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutFrom(ctx, client.Timeout))
+	ctx, span := tracing.Start(ctx, "HTTP "+methodType,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(methodType),
+			semconv.HTTPURLKey.String(logging.RedactURL(URL)),
+		),
+	)
+	request, err := client.constructRequest(ctx, methodType, URL, body, contentType, auth)
 	if err != nil {
+		cancel()
+		tracing.End(span, err)
 		return nil, err
 	}
-	resp, err := client.getClient(client.Timeout).Do(request)
+	tracing.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	started := time.Now()
+	resp, err := client.client.Do(request)
 	if err != nil {
+		cancel()
+		tracing.End(span, err)
+		client.logger.Warn("outbound request failed",
+			"method", methodType,
+			"url", logging.RedactURL(URL),
+			"latency_ms", time.Since(started).Milliseconds(),
+			"error", err,
+		)
 		return nil, err
 	}
+	if client.logger.Enabled(logging.LevelDebug) {
+		client.logger.Debug("outbound request",
+			"method", methodType,
+			"url", logging.RedactURL(URL),
+			"headers", logging.RedactHeaders(request.Header),
+			"status", resp.StatusCode,
+			"latency_ms", time.Since(started).Milliseconds(),
+		)
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+	span.End()
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
 	// if resp.StatusCode != http.StatusOK {
 	// 	return resp, errors.New("remote server error")
 	// }
@@ -0,0 +1,246 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Config - outbound http settings shared by every request of a client
+type Config struct {
+	// Timeout - default limit of a request including reading its body, see WithTimeout
+	Timeout time.Duration
+	// MaxIdleConnsPerHost - idle connections kept open per host for bursts of calls
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost - limit of connections per host, 0 means no limit
+	MaxConnsPerHost int
+	// IdleConnTimeout - how long an idle connection is kept open
+	IdleConnTimeout time.Duration
+	// TLSMinVersion - lowest tls version accepted, tls.VersionTLS12 or tls.VersionTLS13
+	TLSMinVersion uint16
+	// TLSCAFile - pem bundle trusted on top of the system roots, for proxies terminating tls
+	TLSCAFile string
+	// ProxyURL - proxy of requests to hosts not excluded by NO_PROXY, HTTPS_PROXY of environment
+	// is used when empty
+	ProxyURL string
+}
+
+// DefaultConfig - 5 second timeout and enough idle connections for bursts of spotify calls
+func DefaultConfig() Config {
+	return Config{
+		Timeout:             5 * time.Second,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+		TLSMinVersion:       tls.VersionTLS12,
+	}
+}
+
+// ConfigFromEnv - DefaultConfig overridden by HTTP_TIMEOUT_SECONDS, HTTP_MAX_IDLE_CONNS_PER_HOST,
+// HTTP_MAX_CONNS_PER_HOST, HTTP_IDLE_CONN_TIMEOUT_SECONDS, HTTP_TLS_MIN_VERSION, HTTP_TLS_CA_FILE
+// and HTTP_PROXY_URL, fails when one of them is set to an invalid value
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+	seconds, err := envInt("HTTP_TIMEOUT_SECONDS", int(config.Timeout/time.Second), 1)
+	if err != nil {
+		return config, err
+	}
+	config.Timeout = time.Duration(seconds) * time.Second
+	if config.MaxIdleConnsPerHost, err = envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", config.MaxIdleConnsPerHost, 1); err != nil {
+		return config, err
+	}
+	if config.MaxConnsPerHost, err = envInt("HTTP_MAX_CONNS_PER_HOST", config.MaxConnsPerHost, 0); err != nil {
+		return config, err
+	}
+	if seconds, err = envInt("HTTP_IDLE_CONN_TIMEOUT_SECONDS", int(config.IdleConnTimeout/time.Second), 1); err != nil {
+		return config, err
+	}
+	config.IdleConnTimeout = time.Duration(seconds) * time.Second
+	switch version := os.Getenv("HTTP_TLS_MIN_VERSION"); version {
+	case "", "1.2":
+	case "1.3":
+		config.TLSMinVersion = tls.VersionTLS13
+	default:
+		return config, fmt.Errorf("HTTP_TLS_MIN_VERSION must be 1.2 or 1.3, got %q", version)
+	}
+	config.TLSCAFile = os.Getenv("HTTP_TLS_CA_FILE")
+	config.ProxyURL = os.Getenv("HTTP_PROXY_URL")
+	return config, nil
+}
+
+// envInt - integer of env variable name of at least min, fallback when it's unset
+func envInt(name string, fallback int, min int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min {
+		return 0, fmt.Errorf("%s must be an integer of at least %d, got %q", name, min, value)
+	}
+	return parsed, nil
+}
+
+// newTransport - pooled transport of config, HTTP/2 is used with hosts supporting it
+func newTransport(config Config) (*http.Transport, error) {
+	tlsConfig := &tls.Config{MinVersion: config.TLSMinVersion}
+	if config.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, errors.New("proxy url must be absolute, got " + config.ProxyURL)
+		}
+		proxy = proxyExcept(proxyURL, noProxy())
+	}
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          config.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+// NewGuarded - plain client of config for urls given by users, connections are only made to
+// addresses allow accepts and redirects aren't followed. Proxies are bypassed since they would
+// connect in place of the guarded dialer. The address is checked after resolving, right before
+// connecting, so a host can't resolve to an allowed address when it's checked and another later
+func NewGuarded(config Config, allow func(ip net.IP) error) (*http.Client, error) {
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return errors.New("dialed address isn't an ip address: " + address)
+			}
+			return allow(ip)
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// noProxy - hosts of NO_PROXY, or no_proxy when it's unset
+func noProxy() []string {
+	value := os.Getenv("NO_PROXY")
+	if value == "" {
+		value = os.Getenv("no_proxy")
+	}
+	hosts := []string{}
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// proxyExcept - proxy of every request except to localhost and hosts matching an entry of
+// excluded, entries are "*", ip addresses, cidr ranges or domains matching their subdomains,
+// like NO_PROXY is read by http.ProxyFromEnvironment
+func proxyExcept(proxyURL *url.URL, excluded []string) func(*http.Request) (*url.URL, error) {
+	return func(request *http.Request) (*url.URL, error) {
+		host := strings.ToLower(request.URL.Hostname())
+		ip := net.ParseIP(host)
+		if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil, nil
+		}
+		for _, entry := range excluded {
+			if entry == "*" {
+				return nil, nil
+			}
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				if ip != nil && network.Contains(ip) {
+					return nil, nil
+				}
+				continue
+			}
+			if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+				entry = entryHost
+			}
+			entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return nil, nil
+			}
+		}
+		return proxyURL, nil
+	}
+}
+
+type timeoutKey struct{}
+
+// WithTimeout - ctx whose requests are limited to timeout instead of the timeout of the client
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+func timeoutFrom(ctx context.Context, fallback time.Duration) time.Duration {
+	if timeout, ok := ctx.Value(timeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return fallback
+}
+
+// cancelOnClose - response body releasing the timeout of its request once it's closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
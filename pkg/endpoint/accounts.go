@@ -0,0 +1,121 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"utilserver/pkg/accounts"
+
+	"github.com/gorilla/mux"
+)
+
+// provider of the route, fixed for legacy spotify routes
+func providerOf(r *http.Request, provider string) string {
+	if provider != "" {
+		return provider
+	}
+	return mux.Vars(r)["provider"]
+}
+
+func (handler *Handler) redirectToProvider(w http.ResponseWriter, r *http.Request, provider string, link *accounts.Session) {
+	loginURL, state, err := handler.accounts.StartLogin(provider, r.URL.Query().Get("redirect"), link)
+	if err != nil {
+		handler.writeError(w, r, err)
+		return
+	}
+	// set state to cookie
+	setCookie(&w, os.Getenv("SPOTIFY_LOGIN_STATE_KEY"), state)
+	// enable cors
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.Redirect(w, r, loginURL, http.StatusTemporaryRedirect)
+}
+
+// login - redirect to login page of provider
+func (handler *Handler) login(provider string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.redirectToProvider(w, r, providerOf(r, provider), nil)
+	})
+}
+
+// link account of provider to logged in user
+func (handler *Handler) linkAccount() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := sessionOf(r)
+		if session == nil || session.UserID == "" {
+			handler.writeError(w, r, newError(http.StatusUnauthorized, CodeAuthExpired, "session predates linked accounts, log in again"))
+			return
+		}
+		handler.redirectToProvider(w, r, mux.Vars(r)["provider"], session)
+	})
+}
+
+// callback of provider, redirect with token of the user
+func (handler *Handler) loginCallback(provider string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider := providerOf(r, provider)
+		state := r.URL.Query().Get("state")
+		// state has to come back to the browser which started the login, otherwise a login or
+		// link started by someone else could be finished in the browser of a victim
+		storedStateCookie, _ := r.Cookie(os.Getenv("SPOTIFY_LOGIN_STATE_KEY"))
+		if state == "" || storedStateCookie == nil || state != storedStateCookie.Value {
+			handler.writeError(w, r, newError(http.StatusForbidden, CodeInvalidState, "invalid state"))
+			return
+		}
+		clearCookie(&w)
+
+		loginResponse, redirect, err := handler.accounts.FinishLogin(provider, state, r.URL.Query())
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		if redirect == "" {
+			redirect = "/api/v1/me"
+			if provider == accounts.ProviderSpotify {
+				redirect = "/api/v1/spotify/profile"
+			}
+		}
+		http.Redirect(w, r, redirect+"?token="+loginResponse.Token, http.StatusTemporaryRedirect)
+	})
+}
+
+// get names of configured identity providers
+func (handler *Handler) getProviders() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		providersByteArr, err := json.Marshal(map[string][]string{"providers": handler.accounts.Providers()})
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(providersByteArr)
+	})
+}
+
+// get logged in user with linked accounts
+func (handler *Handler) getUser() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := handler.accounts.GetUser(r.Header.Get("user_id"))
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		userByteArr, err := json.Marshal(user)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(userByteArr)
+	})
+}
+
+// unlink account of provider from logged in user
+func (handler *Handler) unlinkAccount() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler.accounts.UnlinkAccount(r.Header.Get("user_id"), mux.Vars(r)["provider"]); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
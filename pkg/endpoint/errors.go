@@ -0,0 +1,199 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"utilserver/pkg/accounts"
+	"utilserver/pkg/breaker"
+	"utilserver/pkg/lastfm"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/spotify"
+	"utilserver/pkg/webhooks"
+)
+
+// codes of error responses, clients branch on them instead of on messages which may change
+const (
+	// CodeValidationFailed - 400, request is malformed or a parameter is invalid, details list
+	// the fields with field, rule and reason
+	CodeValidationFailed = "validation_failed"
+	// CodeUnauthenticated - 401, token is missing or invalid
+	CodeUnauthenticated = "unauthenticated"
+	// CodeAuthExpired - 401, token expired or predates the session model, log in again
+	CodeAuthExpired = "auth_expired"
+	// CodeSessionRevoked - 401, session was revoked by logout everywhere or account deletion
+	CodeSessionRevoked = "session_revoked"
+	// CodeReauthRequired - 401, spotify revoked access of the app, log in with spotify again
+	CodeReauthRequired = "reauth_required"
+	// CodeInvalidState - 403, login callback doesn't belong to a login started here
+	CodeInvalidState = "invalid_state"
+	// CodeOriginNotAllowed - 403, page of an origin missing in STREAM_ALLOWED_ORIGINS opened a stream
+	CodeOriginNotAllowed = "origin_not_allowed"
+	// CodeNotFound - 404, resource or route doesn't exist
+	CodeNotFound = "not_found"
+	// CodeMethodNotAllowed - 405, route doesn't support the method
+	CodeMethodNotAllowed = "method_not_allowed"
+	// CodeConflict - 409, request conflicts with linked accounts
+	CodeConflict = "conflict"
+	// CodeRateLimited - 429, client exceeded its rate limit, see Retry-After
+	CodeRateLimited = "rate_limited"
+	// CodeSpotifyRateLimited - 429, spotify quota of the app is used up, see Retry-After
+	CodeSpotifyRateLimited = "spotify_rate_limited"
+	// CodeSpotifyError - status of spotify, spotify rejected the call, details carry its status and
+	// reason. 502 when spotify answered 401 or 403, those concern the token of the app and not the caller
+	CodeSpotifyError = "spotify_error"
+	// CodeUpstreamError - 502, last.fm or another upstream answered with an error
+	CodeUpstreamError = "upstream_error"
+	// CodeUpstreamUnavailable - 503, spotify, mongo or redis is down, see Retry-After
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	// CodeInternal - 500, unexpected failure, details are only logged
+	CodeInternal = "internal_error"
+)
+
+// ErrorResponse - body of every error response
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// apiError - error answered with status and code of the catalog
+type apiError struct {
+	status     int
+	code       string
+	message    string
+	details    interface{}
+	retryAfter int
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newError(status int, code string, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *badRequestError) Unwrap() error {
+	return e.err
+}
+
+func newBadRequest(err error) error {
+	return &badRequestError{err}
+}
+
+// toAPIError - status, code and message of err, errors of services are mapped here and
+// nowhere else. Unknown errors become internal_error without exposing their message
+func toAPIError(err error) *apiError {
+	var known *apiError
+	var apiErr *spotify.APIError
+	var rateLimitedErr *spotify.RateLimitedError
+	var lastfmErr *lastfm.APIError
+	var argumentErr *spotify.ArgumentError
+	var webhookArgumentErr *webhooks.ArgumentError
+	var badRequestErr *badRequestError
+	var unavailableErr *breaker.UnavailableError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &known):
+		return known
+	case errors.As(err, &validationErr):
+		invalid := newError(http.StatusBadRequest, CodeValidationFailed, "request is invalid")
+		invalid.details = validationErr.Fields
+		return invalid
+	case errors.As(err, &badRequestErr), errors.As(err, &argumentErr), errors.As(err, &webhookArgumentErr):
+		return newError(http.StatusBadRequest, CodeValidationFailed, err.Error())
+	case errors.As(err, &rateLimitedErr):
+		limited := newError(http.StatusTooManyRequests, CodeSpotifyRateLimited, err.Error())
+		limited.retryAfter = seconds(rateLimitedErr.RetryAfter)
+		return limited
+	case errors.As(err, &unavailableErr):
+		unavailable := newError(http.StatusServiceUnavailable, CodeUpstreamUnavailable, unavailableErr.Upstream+" is unavailable, try again later")
+		unavailable.details = map[string]string{"upstream": unavailableErr.Upstream}
+		unavailable.retryAfter = seconds(unavailableErr.RetryAfter)
+		return unavailable
+	case errors.Is(err, spotify.ErrReauthRequired):
+		return newError(http.StatusUnauthorized, CodeReauthRequired, err.Error())
+	case errors.Is(err, spotify.ErrNotFound):
+		return newError(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusTooManyRequests:
+		limited := newError(http.StatusTooManyRequests, CodeSpotifyRateLimited, spotify.ErrRateLimited.Error())
+		limited.retryAfter = seconds(apiErr.RetryAfter)
+		return limited
+	case errors.As(err, &apiErr):
+		spotifyErr := newError(apiErr.Status, CodeSpotifyError, apiErr.Error())
+		switch apiErr.Status {
+		case http.StatusNotFound:
+			spotifyErr.code = CodeNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			// the caller is authenticated, spotify refused the token or scopes of the app
+			spotifyErr.status = http.StatusBadGateway
+		}
+		spotifyErr.details = map[string]interface{}{"status": apiErr.Status, "reason": apiErr.Reason}
+		return spotifyErr
+	case errors.As(err, &lastfmErr):
+		return newError(http.StatusBadGateway, CodeUpstreamError, err.Error())
+	case errors.Is(err, accounts.ErrUnknownProvider), errors.Is(err, accounts.ErrUserNotFound), errors.Is(err, accounts.ErrNotLinked),
+		errors.Is(err, lastfm.ErrNotConnected), errors.Is(err, webhooks.ErrEndpointNotFound), errors.Is(err, webhooks.ErrDeliveryNotFound):
+		return newError(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, accounts.ErrInvalidState):
+		return newError(http.StatusForbidden, CodeInvalidState, err.Error())
+	case errors.Is(err, accounts.ErrAccountLinked), errors.Is(err, accounts.ErrProviderLinked), errors.Is(err, accounts.ErrLastAccount):
+		return newError(http.StatusConflict, CodeConflict, err.Error())
+	}
+	return newError(http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// writeError - answer with error envelope of err, server errors are logged with their cause
+func (handler *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	logger := logging.FromContext(r.Context(), handler.logger)
+	switch {
+	case apiErr.code == CodeInternal:
+		logger.Error("request failed", "error_code", apiErr.code, "error", err)
+	case apiErr.status >= http.StatusInternalServerError:
+		logger.Warn("request failed", "error_code", apiErr.code, "error", err)
+	}
+	writeAPIError(w, apiErr)
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr *apiError) {
+	if apiErr.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.retryAfter))
+	}
+	body, _ := json.Marshal(ErrorResponse{
+		Code:    apiErr.code,
+		Message: apiErr.message,
+		Details: apiErr.details,
+		// set by requestMiddleware before any handler runs
+		RequestID: w.Header().Get(RequestIDHeader),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.status)
+	w.Write(body)
+}
+
+// notFound - envelope for paths without route
+func notFound() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, newError(http.StatusNotFound, CodeNotFound, "no route for "+r.URL.Path))
+	})
+}
+
+// methodNotAllowed - envelope for routes asked with a method they don't support
+func methodNotAllowed() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path))
+	})
+}
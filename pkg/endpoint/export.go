@@ -0,0 +1,73 @@
+package endpoint
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"utilserver/pkg/spotify"
+
+	"github.com/gorilla/mux"
+)
+
+// ExportResponse - export job with link to its archive once it's done
+type ExportResponse struct {
+	*spotify.Export
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func writeExport(w http.ResponseWriter, job *spotify.Export, status int) {
+	response := ExportResponse{Export: job}
+	if job.Status == spotify.ExportDone {
+		response.DownloadURL = "/api/v1/export/" + job.ID.Hex() + "/download"
+	}
+	writeJSON(w, response, status)
+}
+
+// start export of user's data, format is json, csv or parquet
+func (handler *Handler) createExport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := ExportQuery{Format: "json"}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		job, err := handler.servicesFor(r).Export.CreateExport(userID, query.Format)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/export/"+job.ID.Hex())
+		writeExport(w, job, http.StatusAccepted)
+	})
+}
+
+// get status of export
+func (handler *Handler) getExport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		job, err := handler.servicesFor(r).Export.GetExport(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writeExport(w, job, http.StatusOK)
+	})
+}
+
+// download archive of finished export
+func (handler *Handler) downloadExport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		job, archive, err := handler.servicesFor(r).Export.OpenExportArchive(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		defer archive.Close()
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="export-`+job.ID.Hex()+`.zip"`)
+		w.Header().Set("Content-Length", strconv.FormatInt(job.Size, 10))
+		io.Copy(w, archive)
+	})
+}
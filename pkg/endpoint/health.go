@@ -0,0 +1,32 @@
+package endpoint
+
+import (
+	"net/http"
+	"utilserver/pkg/health"
+)
+
+// liveness, the process is up when it answers
+func (handler *Handler) getLiveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": health.StatusUp}, http.StatusOK)
+	})
+}
+
+// readiness, unavailable while mongo or redis doesn't answer
+func (handler *Handler) getReadiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readiness := handler.health.Ready(r.Context())
+		status := http.StatusOK
+		if readiness.Status != health.StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, readiness, status)
+	})
+}
+
+// dependency latency, version, uptime and last spotify check
+func (handler *Handler) getStatus() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, handler.health.Status(r.Context()), http.StatusOK)
+	})
+}
@@ -0,0 +1,96 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"utilserver/pkg/spotify"
+)
+
+// privacy exports with years of history run into tens of megabytes
+const maxHistoryUpload = 256 << 20
+
+// import streaming history files of spotify privacy export, files are uploaded as multipart
+// form, either json files themselves or the zipped export. A single json file can be posted as body
+func (handler *Handler) importHistory() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		r.Body = http.MaxBytesReader(w, r.Body, maxHistoryUpload)
+		plays, err := parseHistoryUpload(r)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		result, err := handler.backgroundServicesFor(r).History.ImportHistory(userID, plays)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resultByteArr, err := json.Marshal(result)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultByteArr)
+	})
+}
+
+func parseHistoryUpload(r *http.Request) ([]spotify.Play, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return spotify.ParseStreamingHistory(r.Body)
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, newBadRequest(err)
+	}
+	plays := []spotify.Play{}
+	for _, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return nil, err
+			}
+			var filePlays []spotify.Play
+			switch {
+			case path.Ext(header.Filename) == ".zip":
+				filePlays, err = spotify.ParseStreamingHistoryArchive(file, header.Size)
+			case spotify.IsStreamingHistoryFile(header.Filename):
+				filePlays, err = spotify.ParseStreamingHistory(file)
+			default:
+				err = newBadRequest(errors.New(header.Filename + " is not a streaming history file"))
+			}
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			plays = append(plays, filePlays...)
+		}
+	}
+	return plays, nil
+}
+
+// get stored listening history newest first, from and to are RFC3339 times
+func (handler *Handler) getHistory() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := HistoryQuery{Limit: 50}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		page, err := handler.servicesFor(r).History.GetHistory(userID, query.From, query.To, query.Limit, query.Offset)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		pageByteArr, err := json.Marshal(page)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(pageByteArr)
+	})
+}
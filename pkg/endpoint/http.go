@@ -1,20 +1,25 @@
 package endpoint
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
-	"strings"
 	"time"
+	"utilserver/pkg/accounts"
+	"utilserver/pkg/health"
+	"utilserver/pkg/lastfm"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/nowplaying"
+	"utilserver/pkg/ratelimit"
 	"utilserver/pkg/spotify"
+	"utilserver/pkg/webhooks"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/mux"
-	"github.com/lithammer/shortuuid/v4"
 )
 
 func clearCookie(w *http.ResponseWriter) {
@@ -39,32 +44,130 @@ func setCookie(w *http.ResponseWriter, name string, value string) {
 	http.SetCookie(*w, c)
 }
 
+// write value as json response with status
+func writeJSON(w http.ResponseWriter, value interface{}, status int) {
+	valueByteArr, err := json.Marshal(value)
+	if err != nil {
+		writeAPIError(w, newError(http.StatusInternalServerError, CodeInternal, "internal server error"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(valueByteArr)
+}
+
 type Cache interface {
 	Get(key string) (interface{}, error)
 	Set(key string, value interface{}, expiration time.Duration) error
 }
 type Handler struct {
-	cache    spotify.Cache
-	services spotify.Services
+	cache      spotify.Cache
+	services   spotify.Services
+	accounts   *accounts.Service
+	lastfm     *lastfm.Service
+	webhooks   *webhooks.Service
+	nowPlaying *nowplaying.Hub
+	logger     *logging.Logger
+	health     *health.Service
+	limiter    *ratelimit.Limiter
 }
 
-// Handler - spotify authentication routes handler
-func NewHandler(cache spotify.Cache, services spotify.Services) http.Handler {
+// Handler - spotify authentication routes handler, last.fm routes are left out without lastfm service
+func NewHandler(cache spotify.Cache, services spotify.Services, accountsService *accounts.Service, lastfmService *lastfm.Service, webhooksService *webhooks.Service, nowPlaying *nowplaying.Hub, healthService *health.Service, limiter *ratelimit.Limiter, logger *logging.Logger) http.Handler {
 	handler := new(Handler)
 	handler.cache = cache
 	handler.services = services
+	handler.accounts = accountsService
+	handler.lastfm = lastfmService
+	handler.webhooks = webhooksService
+	handler.nowPlaying = nowPlaying
+	handler.health = healthService
+	handler.limiter = limiter
+	handler.logger = logger
 	r := mux.NewRouter()
+	r.NotFoundHandler = notFound()
+	r.MethodNotAllowedHandler = methodNotAllowed()
+	r.Use(routeMiddleware)
+	r.Handle("/healthz", handler.getLiveness()).Methods(http.MethodGet)
+	r.Handle("/readyz", handler.getReadiness()).Methods(http.MethodGet)
 	api := r.PathPrefix("/api/v1").Subrouter()
 
-	api.Handle("/spotify/login", handler.login()).Methods(http.MethodGet)
-	api.Handle("/spotify/callback", handler.loginCallback()).Methods(http.MethodGet)
-	api.Handle("/spotify/profile", attachMiddleware(handler.getProfile(), handler.authMiddleware)).Methods(http.MethodGet)
-	api.Handle("/spotify/recently_played", attachMiddleware(handler.getRecentlyPlayed(), handler.authMiddleware)).Methods(http.MethodGet)
-	// api.Handle("/spotify/audio_features", attachMiddleware(handler.getAudioFeatures(), handler.authMiddleware)).Methods(http.MethodGet)
-	api.Handle("/spotify/top", attachMiddleware(handler.getTops(), handler.authMiddleware)).Methods(http.MethodGet)
-	api.Handle("/spotify/playlists", attachMiddleware(handler.getPlaylists(), handler.authMiddleware)).Methods(http.MethodGet)
-	api.Handle("/spotify/audio_features", attachMiddleware(handler.getPersonalAudioFeatures(), handler.authMiddleware)).Methods(http.MethodGet)
-	return r
+	api.Handle("/spotify/login", attachMiddleware(handler.login(accounts.ProviderSpotify), handler.rateLimitMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/callback", attachMiddleware(handler.loginCallback(accounts.ProviderSpotify), handler.rateLimitMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/profile", attachMiddleware(handler.getProfile(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/me", attachMiddleware(handler.deleteAccount(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodDelete)
+	api.Handle("/status", attachMiddleware(handler.getStatus(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+
+	// users and linked accounts
+	api.Handle("/auth/providers", handler.getProviders()).Methods(http.MethodGet)
+	api.Handle("/auth/{provider}/login", attachMiddleware(handler.login(""), handler.rateLimitMiddleware)).Methods(http.MethodGet)
+	api.Handle("/auth/{provider}/link", attachMiddleware(handler.linkAccount(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/auth/{provider}/callback", attachMiddleware(handler.loginCallback(""), handler.rateLimitMiddleware)).Methods(http.MethodGet)
+	api.Handle("/me", attachMiddleware(handler.getUser(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/me/accounts/{provider}", attachMiddleware(handler.unlinkAccount(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodDelete)
+	api.Handle("/spotify/recently_played", attachMiddleware(handler.getRecentlyPlayed(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	// api.Handle("/spotify/audio_features", attachMiddleware(handler.getAudioFeatures(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/top", attachMiddleware(handler.getTops(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/playlists", attachMiddleware(handler.getPlaylists(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/playlists/snapshot", attachMiddleware(handler.snapshotPlaylists(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/playlists/{id}/history", attachMiddleware(handler.getPlaylistHistory(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/playlists/{id}/restore", attachMiddleware(handler.restorePlaylist(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/audio_features", attachMiddleware(handler.getPersonalAudioFeatures(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/genres", attachMiddleware(handler.getGenres(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/search", attachMiddleware(handler.search(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/tracks", attachMiddleware(handler.getTracks(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/tracks/{id}", attachMiddleware(handler.getTrack(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/artists/graph", attachMiddleware(handler.getArtistGraph(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/artists/{id}", attachMiddleware(handler.getArtist(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/albums/{id}", attachMiddleware(handler.getAlbum(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+
+	// listening history
+	api.Handle("/spotify/history", attachMiddleware(handler.getHistory(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/history/import", attachMiddleware(handler.importHistory(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+
+	// last.fm scrobbling
+	if lastfmService != nil {
+		api.Handle("/lastfm", attachMiddleware(handler.getLastFMProfile(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+		api.Handle("/lastfm/scrobbling", attachMiddleware(handler.setScrobbling(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+		api.Handle("/lastfm/backfill", attachMiddleware(handler.backfillScrobbles(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	}
+
+	// saved library
+	api.Handle("/spotify/library/sync", attachMiddleware(handler.syncLibrary(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/library/growth", attachMiddleware(handler.getLibraryGrowth(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/library/{kind}", attachMiddleware(handler.getLibrary(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/library/{kind}", attachMiddleware(handler.saveToLibrary(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/library/{kind}", attachMiddleware(handler.removeFromLibrary(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodDelete)
+
+	// playback control
+	api.Handle("/spotify/player", attachMiddleware(handler.getPlaybackState(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/player", attachMiddleware(handler.transferPlayback(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/currently_playing", attachMiddleware(handler.getCurrentlyPlaying(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/player/devices", attachMiddleware(handler.getDevices(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/player/play", attachMiddleware(handler.play(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/pause", attachMiddleware(handler.pause(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/next", attachMiddleware(handler.skipToNext(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/player/previous", attachMiddleware(handler.skipToPrevious(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/player/seek", attachMiddleware(handler.seek(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/volume", attachMiddleware(handler.setVolume(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/shuffle", attachMiddleware(handler.setShuffle(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/repeat", attachMiddleware(handler.setRepeat(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPut)
+	api.Handle("/spotify/player/queue", attachMiddleware(handler.getQueue(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/spotify/player/queue", attachMiddleware(handler.addToQueue(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/spotify/now-playing/stream", attachMiddleware(handler.streamNowPlaying(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+
+	// webhooks
+	api.Handle("/webhooks", attachMiddleware(handler.registerWebhook(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/webhooks", attachMiddleware(handler.getWebhooks(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/webhooks/{id}", attachMiddleware(handler.deleteWebhook(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodDelete)
+	api.Handle("/webhooks/{id}/deliveries", attachMiddleware(handler.getWebhookDeliveries(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/webhooks/deliveries/{id}/redeliver", attachMiddleware(handler.redeliverWebhook(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+
+	// data export
+	api.Handle("/export", attachMiddleware(handler.createExport(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodPost)
+	api.Handle("/export/{id}", attachMiddleware(handler.getExport(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	api.Handle("/export/{id}/download", attachMiddleware(handler.downloadExport(), handler.rateLimitMiddleware, handler.authMiddleware)).Methods(http.MethodGet)
+	return handler.requestMiddleware(r)
 }
 
 func attachMiddleware(h http.Handler, middlewares ...mux.MiddlewareFunc) http.Handler {
@@ -81,50 +184,44 @@ func (handler Handler) authMiddleware(next http.Handler) http.Handler {
 			token = r.URL.Query().Get("token")
 		}
 		claim, err := verifyToken(token)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			handler.writeError(w, r, newError(http.StatusUnauthorized, CodeAuthExpired, "token is expired, log in again"))
+			return
+		}
+		if err != nil || claim.Subject == "" {
+			handler.writeError(w, r, newError(http.StatusUnauthorized, CodeUnauthenticated, "invalid token"))
+			return
+		}
+		var issuedAt time.Time
+		if claim.IssuedAt != nil {
+			issuedAt = claim.IssuedAt.Time
+		}
+		revoked, err := handler.services.Auth.SessionRevoked(claim.Subject, issuedAt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusForbidden)
+			// fail closed, a revoked session must not get in while redis is down
+			handler.writeError(w, r, err)
 			return
 		}
-		if claim.Email == "" {
-			http.Error(w, err.Error(), http.StatusForbidden)
+		if revoked {
+			handler.writeError(w, r, newError(http.StatusUnauthorized, CodeSessionRevoked, "session has been revoked, log in again"))
 			return
 		}
-		r.Header.Set("email", claim.Email)
-		next.ServeHTTP(w, r)
+		r.Header.Set("user_id", claim.Subject)
+		metrics.SessionSeen(claim.Subject)
+		session := &accounts.Session{UserID: claim.Subject, IssuedAt: issuedAt}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
 	})
 }
 
-// get spotify login url from environment variables, parse url and redirect to that url
-func (handler Handler) redirectToSpotifyLogin(w http.ResponseWriter, r *http.Request) {
-	parm := url.Values{}
-	base, err := url.Parse(os.Getenv("SPOTIFY_LOGIN_ENDPOINT"))
-	// get redirect from query params and set it to cache if it isn't empty
-	redirect := r.URL.Query().Get("redirect")
+type sessionKey struct{}
 
-	id := shortuuid.New()
-
-	parm.Add("state", id)
-	// set state to cookie
-	setCookie(&w, os.Getenv("SPOTIFY_LOGIN_STATE_KEY"), id)
-	if redirect != "" {
-		handler.cache.Set(id, redirect, 0)
-	}
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-	// w.Header().Set("Access-Control-Allow-Origin", "*")
-	parm.Add("client_id", os.Getenv("CLIENT_ID"))
-	parm.Add("scope", os.Getenv("SCOPES"))
-	parm.Add("response_type", "code")
-	parm.Add("redirect_uri", os.Getenv("REDIRECT_URL"))
-	base.RawQuery = parm.Encode()
-	// enable cors
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// change base to string and print
-	http.Redirect(w, r, base.String(), http.StatusTemporaryRedirect)
+// sessionOf - session authMiddleware authenticated request with, nil on routes without it
+func sessionOf(r *http.Request) *accounts.Session {
+	session, _ := r.Context().Value(sessionKey{}).(*accounts.Session)
+	return session
 }
 
-// verify jwt token and extract email from token
+// verify jwt token and extract user id from token
 func verifyToken(tokenString string) (*spotify.CustomClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &spotify.CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(os.Getenv("SECRET")), nil
@@ -139,108 +236,65 @@ func verifyToken(tokenString string) (*spotify.CustomClaims, error) {
 	}
 }
 
-// login
-func (handler Handler) login() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler.redirectToSpotifyLogin(w, r)
-	})
-}
-
 func (handler *Handler) getProfile() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("email")
-		profile, profileErr := handler.services.Auth.Login(email)
-		if profile == nil {
-			http.Error(w, "no profile", http.StatusInternalServerError)
+		userID := r.Header.Get("user_id")
+		profile, profileErr := handler.servicesFor(r).Auth.Login(userID)
+		if profileErr != nil {
+			handler.writeError(w, r, profileErr)
 			return
 		}
-		if profileErr != nil {
-			http.Error(w, profileErr.Error(), http.StatusInternalServerError)
+		if profile == nil {
+			handler.writeError(w, r, newError(http.StatusNotFound, CodeNotFound, "no spotify profile linked"))
 			return
 		}
 		profileByteArr, marshallingErr := json.Marshal(profile)
 		if marshallingErr != nil {
-			http.Error(w, marshallingErr.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, marshallingErr)
 			return
 		}
 		w.Write(profileByteArr)
 	})
 }
 
-func (handler *Handler) loginCallback() http.Handler {
+// erase account of user with all of its data, every session of user is revoked
+func (handler *Handler) deleteAccount() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		state := r.URL.Query().Get("state")
-		storedStateCookie, _ := r.Cookie(os.Getenv("SPOTIFY_LOGIN_STATE_KEY"))
-
-		if state == "" || (storedStateCookie != nil && (state != storedStateCookie.Value)) {
-			http.Error(w, "Invalid state", http.StatusForbidden)
-		} else {
-			clearCookie(&w)
-
-			loginReponse, err := handler.services.Auth.AuthCallback(code)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			// get redirect from cache with key from state
-			redirect, _ := handler.cache.Get(state)
-			if redirect == "" || redirect == nil {
-				http.Redirect(w, r, "/api/v1/spotify/profile?token="+loginReponse.Token, http.StatusTemporaryRedirect)
-			} else {
-				redirect, ok := redirect.(string)
-				if !ok {
-					http.Error(w, "Invalid redirect", http.StatusInternalServerError)
-					return
-				}
-				http.Redirect(w, r, redirect+"?token="+loginReponse.Token, http.StatusTemporaryRedirect)
-				err := handler.cache.Clear(state)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-			}
+		userID := r.Header.Get("user_id")
+		err := handler.servicesFor(r).Auth.DeleteAccount(userID,
+			spotify.ErasedData{Name: "webhooks", Erase: handler.webhooks.DeleteUserData},
+			spotify.ErasedData{Name: "user", Erase: handler.accounts.DeleteUser},
+		)
+		if err != nil {
+			handler.writeError(w, r, err)
 			return
 		}
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
 
 func (handler *Handler) getRecentlyPlayed() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		email := r.Header.Get("email")
+		userID := r.Header.Get("user_id")
 		var query RecentlyPlayedQurey = RecentlyPlayedQurey{
-			Email:  email,
-			Before: r.URL.Query().Get("before"),
-			After:  r.URL.Query().Get("after"),
-		}
-
-		if limit := r.URL.Query().Get("limit"); limit != "" {
-			if i, err := strconv.Atoi(limit); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			} else if err == nil {
-				query.Limit = i
-			}
-		} else {
-			query.Limit = 20
+			UserID: userID,
+			Limit:  20,
 		}
-
-		validate := validator.New()
-		if errors := validate.Struct(query); errors != nil {
-			http.Error(w, errors.Error(), http.StatusBadRequest)
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
 			return
 		}
 		timeBefore, _ := time.Parse("2006-01-02", query.Before)
 		timeAfter, _ := time.Parse("2006-01-02", query.After)
 
-		recentlyPlayed, err := handler.services.PersonalInfo.GetRecentlyPlayed(
-			query.Email, query.Limit,
+		recentlyPlayed, err := handler.servicesFor(r).PersonalInfo.GetRecentlyPlayed(
+			query.UserID, query.Limit,
 			strconv.FormatInt(timeBefore.UnixNano()/1000000, 10),
 			strconv.FormatInt(timeAfter.UnixNano()/1000000, 10),
 		)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -250,12 +304,16 @@ func (handler *Handler) getRecentlyPlayed() http.Handler {
 
 func (handler *Handler) getAudioFeatures() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("email")
-		trackIDs := r.URL.Query().Get("ids")
-		trackIDsArray := strings.Split(trackIDs, ",")
-		resp, err := handler.services.General.GetTracksAudioFeatures(email, trackIDsArray)
+		userID := r.Header.Get("user_id")
+		var query IDsQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resp, err := handler.servicesFor(r).General.GetTracksAudioFeatures(userID, query.IDs)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, err)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(*resp)
@@ -264,21 +322,15 @@ func (handler *Handler) getAudioFeatures() http.Handler {
 
 func (handler *Handler) getTops() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("email")
-		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-		if err != nil {
-			limit = 10
-		}
-		offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
-		if err != nil {
-			offset = 0
+		userID := r.Header.Get("user_id")
+		query := TopsQuery{Type: "tracks", TimeRange: "medium_term", Limit: 10}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
 		}
-		timeRange := r.URL.Query().Get("time_range")
-		topType := r.URL.Query().Get("type")
-
-		resp, err := handler.services.PersonalInfo.GetTopArtistsOrTracks(email, topType, timeRange, limit, offset)
+		resp, err := handler.servicesFor(r).PersonalInfo.GetTopArtistsOrTracks(userID, query.Type, query.TimeRange, query.Limit, query.Offset)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, err)
 			return
 		}
 		w.Write(*resp)
@@ -288,12 +340,15 @@ func (handler *Handler) getTops() http.Handler {
 // get personal audio features
 func (handler *Handler) getPersonalAudioFeatures() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("email")
-		// get timespan from query
-		timeSpan := r.URL.Query().Get("timespan")
-		resp, err := handler.services.PersonalInfo.GetPersonalAudioFeatures(email, timeSpan)
+		userID := r.Header.Get("user_id")
+		query := PersonalAudioFeaturesQuery{TimeSpan: "medium_term"}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resp, err := handler.servicesFor(r).PersonalInfo.GetPersonalAudioFeatures(userID, query.TimeSpan)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, err)
 			return
 		}
 		w.Write(*resp)
@@ -303,21 +358,186 @@ func (handler *Handler) getPersonalAudioFeatures() http.Handler {
 // get playlists handler
 func (handler *Handler) getPlaylists() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("email")
-		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		userID := r.Header.Get("user_id")
+		query := PageQuery{Limit: 10}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resp, err := handler.servicesFor(r).PersonalInfo.GetUserPlaylists(userID, query.Limit, query.Offset)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Write(*resp)
+	})
+}
+
+// get genre breakdown of top artists
+func (handler *Handler) getGenres() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := TimeRangeQuery{TimeRange: "medium_term"}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		breakdown, err := handler.servicesFor(r).PersonalInfo.GetGenreBreakdown(userID, query.TimeRange)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		breakdownByteArr, err := json.Marshal(breakdown)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(breakdownByteArr)
+	})
+}
+
+// search spotify catalog
+func (handler *Handler) search() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query SearchQuery = SearchQuery{
+			UserID: userID,
+			Limit:  20,
+		}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+
+		result, err := handler.servicesFor(r).Search.Search(userID, spotify.SearchQuery{
+			Query:           query.Query,
+			Types:           query.Types,
+			Market:          query.Market,
+			Limit:           query.Limit,
+			Offset:          query.Offset,
+			IncludeExternal: query.IncludeExternal,
+		})
 		if err != nil {
-			limit = 10
+			handler.writeError(w, r, err)
+			return
 		}
-		offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+		resultByteArr, err := json.Marshal(result)
 		if err != nil {
-			offset = 0
+			handler.writeError(w, r, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultByteArr)
+	})
+}
 
-		resp, err := handler.services.PersonalInfo.GetUserPlaylists(email, limit, offset)
+// get tracks by comma separated ids from catalog
+func (handler *Handler) getTracks() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query IDsQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		tracks, err := handler.servicesFor(r).Catalog.GetTracks(userID, query.IDs)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handler.writeError(w, r, err)
 			return
 		}
-		w.Write(*resp)
+		tracksByteArr, err := json.Marshal(map[string][]spotify.Track{"tracks": tracks})
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(tracksByteArr)
+	})
+}
+
+// get single track from catalog
+func (handler *Handler) getTrack() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		track, err := handler.servicesFor(r).Catalog.GetTrack(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		trackByteArr, err := json.Marshal(track)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(trackByteArr)
+	})
+}
+
+// get artist with top tracks, albums and related artists
+func (handler *Handler) getArtist() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query MarketQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		artist, err := handler.servicesFor(r).Catalog.GetArtistDetail(userID, mux.Vars(r)["id"], query.Market)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		artistByteArr, err := json.Marshal(artist)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(artistByteArr)
+	})
+}
+
+// get album with tracks and audio features
+func (handler *Handler) getAlbum() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		album, err := handler.servicesFor(r).Catalog.GetAlbumDetail(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		albumByteArr, err := json.Marshal(album)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(albumByteArr)
+	})
+}
+
+// get related artist graph grown from top artists
+func (handler *Handler) getArtistGraph() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := ArtistGraphQuery{TimeRange: "medium_term", Seeds: 10, Hops: 2, Fanout: 5}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		graph, err := handler.servicesFor(r).Catalog.GetRelatedArtistGraph(userID, query.TimeRange, query.Seeds, query.Hops, query.Fanout)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		graphByteArr, err := json.Marshal(graph)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(graphByteArr)
 	})
 }
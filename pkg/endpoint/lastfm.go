@@ -0,0 +1,61 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// get linked last.fm account of user
+func (handler *Handler) getLastFMProfile() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		profile, err := handler.lastfm.GetProfile(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		profileByteArr, err := json.Marshal(profile)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(profileByteArr)
+	})
+}
+
+// turn scrobbling of plays to linked last.fm account on or off
+func (handler *Handler) setScrobbling() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query ScrobblingQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		if err := handler.lastfm.SetScrobbling(userID, query.Enabled == "true"); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// import scrobbles made since the last backfill into listening history
+func (handler *Handler) backfillScrobbles() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		result, err := handler.lastfm.Backfill(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resultByteArr, err := json.Marshal(result)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultByteArr)
+	})
+}
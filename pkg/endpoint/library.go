@@ -0,0 +1,109 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// sync saved tracks, saved albums and followed artists
+func (handler *Handler) syncLibrary() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		snapshots, err := handler.backgroundServicesFor(r).Library.SyncLibrary(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		snapshotsByteArr, err := json.Marshal(snapshots)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(snapshotsByteArr)
+	})
+}
+
+// get latest synced library items of a kind
+func (handler *Handler) getLibrary() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := LibraryPageQuery{Limit: 50}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		page, err := handler.servicesFor(r).Library.GetLibrary(userID, mux.Vars(r)["kind"], query.Limit, query.Offset)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		pageByteArr, err := json.Marshal(page)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(pageByteArr)
+	})
+}
+
+// get library growth over time
+func (handler *Handler) getLibraryGrowth() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := LibraryKindQuery{Kind: "tracks"}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		growth, err := handler.servicesFor(r).Library.GetLibraryGrowth(userID, query.Kind)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		growthByteArr, err := json.Marshal(growth)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(growthByteArr)
+	})
+}
+
+// save items of comma separated ids to library
+func (handler *Handler) saveToLibrary() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query IDsQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		if err := handler.servicesFor(r).Library.SaveToLibrary(userID, mux.Vars(r)["kind"], query.IDs); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// remove items of comma separated ids from library
+func (handler *Handler) removeFromLibrary() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query IDsQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		if err := handler.servicesFor(r).Library.RemoveFromLibrary(userID, mux.Vars(r)["kind"], query.IDs); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
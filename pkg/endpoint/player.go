@@ -0,0 +1,180 @@
+package endpoint
+
+import (
+	"net/http"
+	"utilserver/pkg/spotify"
+)
+
+// write player response, spotify answers with empty body when nothing is playing
+func writePlayerResponse(w http.ResponseWriter, resp *[]byte) {
+	if resp == nil || len(*resp) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(*resp)
+}
+
+// wrap player command which has no response body
+func (handler *Handler) playerCommand(command func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query PlayerQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		if err := command(userID, query.DeviceID, w, r); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (handler *Handler) getPlaybackState() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query PlayerQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resp, err := handler.servicesFor(r).Player.GetPlaybackState(userID, query.Market)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writePlayerResponse(w, resp)
+	})
+}
+
+func (handler *Handler) getCurrentlyPlaying() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query PlayerQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		resp, err := handler.servicesFor(r).Player.GetCurrentlyPlaying(userID, query.Market)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writePlayerResponse(w, resp)
+	})
+}
+
+func (handler *Handler) getDevices() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		resp, err := handler.servicesFor(r).Player.GetDevices(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writePlayerResponse(w, resp)
+	})
+}
+
+func (handler *Handler) getQueue() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		resp, err := handler.servicesFor(r).Player.GetQueue(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writePlayerResponse(w, resp)
+	})
+}
+
+func (handler *Handler) transferPlayback() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var body TransferPlaybackBody
+		if err := decodeJSON(w, r, &body, false); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.TransferPlayback(userID, body.DeviceIDs, body.Play)
+	})
+}
+
+func (handler *Handler) play() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var options spotify.PlayOptions
+		// resume playback is sent without body
+		if err := decodeJSON(w, r, &options, true); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.Play(userID, deviceID, options)
+	})
+}
+
+func (handler *Handler) pause() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		return handler.servicesFor(r).Player.Pause(userID, deviceID)
+	})
+}
+
+func (handler *Handler) skipToNext() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		return handler.servicesFor(r).Player.SkipToNext(userID, deviceID)
+	})
+}
+
+func (handler *Handler) skipToPrevious() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		return handler.servicesFor(r).Player.SkipToPrevious(userID, deviceID)
+	})
+}
+
+func (handler *Handler) seek() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var query SeekQuery
+		if err := decodeQuery(r, &query); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.Seek(userID, *query.PositionMS, deviceID)
+	})
+}
+
+func (handler *Handler) setVolume() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var query VolumeQuery
+		if err := decodeQuery(r, &query); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.SetVolume(userID, *query.VolumePercent, deviceID)
+	})
+}
+
+func (handler *Handler) setShuffle() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var query ShuffleQuery
+		if err := decodeQuery(r, &query); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.SetShuffle(userID, query.State == "true", deviceID)
+	})
+}
+
+func (handler *Handler) setRepeat() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var query RepeatQuery
+		if err := decodeQuery(r, &query); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.SetRepeat(userID, query.State, deviceID)
+	})
+}
+
+func (handler *Handler) addToQueue() http.Handler {
+	return handler.playerCommand(func(userID string, deviceID string, w http.ResponseWriter, r *http.Request) error {
+		var query QueueQuery
+		if err := decodeQuery(r, &query); err != nil {
+			return err
+		}
+		return handler.servicesFor(r).Player.AddToQueue(userID, query.URI, deviceID)
+	})
+}
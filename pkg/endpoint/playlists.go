@@ -0,0 +1,70 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// capture changed playlists of user now instead of waiting for scheduled snapshot
+func (handler *Handler) snapshotPlaylists() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		captured, err := handler.servicesFor(r).Playlists.SnapshotPlaylists(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		capturedByteArr, err := json.Marshal(map[string]int{"captured": captured})
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(capturedByteArr)
+	})
+}
+
+// get versions of playlist with per version diffs
+func (handler *Handler) getPlaylistHistory() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		history, err := handler.servicesFor(r).Playlists.GetPlaylistHistory(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		historyByteArr, err := json.Marshal(history)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(historyByteArr)
+	})
+}
+
+// rewrite playlist to an earlier version
+func (handler *Handler) restorePlaylist() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var query RestorePlaylistQuery
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		restored, err := handler.servicesFor(r).Playlists.RestorePlaylist(userID, mux.Vars(r)["id"], query.Version)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		restoredByteArr, err := json.Marshal(restored)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(restoredByteArr)
+	})
+}
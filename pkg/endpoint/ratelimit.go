@@ -0,0 +1,68 @@
+package endpoint
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"utilserver/pkg/logging"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitMiddleware - limit requests of a client to matched route, clients are users
+// authMiddleware authenticated and addresses on routes without it. Limits aren't enforced while redis fails
+func (handler *Handler) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		client := "ip:" + handler.clientIP(r)
+		if session := sessionOf(r); session != nil {
+			client = "user:" + session.UserID
+		}
+		limit, result, err := handler.limiter.Take(route, client)
+		if err != nil {
+			logging.FromContext(r.Context(), handler.logger).Warn("rate limit not checked", "route", route, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Floor(result.Remaining))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.ResetAfter)))
+		w.Header().Set("RateLimit-Policy", strconv.Itoa(limit.Limit)+";w="+strconv.Itoa(limit.WindowSeconds))
+		if !result.Allowed {
+			limited := newError(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+			limited.retryAfter = seconds(result.RetryAfter)
+			handler.writeError(w, r, limited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// whole seconds of duration rounded up
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// address of client, first address of X-Forwarded-For when the proxy in front is trusted
+func (handler *Handler) clientIP(r *http.Request) string {
+	if handler.limiter.TrustForwardedFor() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,38 @@
+package endpoint
+
+import (
+	"net/http/httptest"
+	"testing"
+	"utilserver/pkg/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    bool
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"remote address", false, "203.0.113.7:51234", "", "203.0.113.7"},
+		{"untrusted forwarded for", false, "203.0.113.7:51234", "198.51.100.1", "203.0.113.7"},
+		{"trusted forwarded for", true, "10.0.0.2:51234", "198.51.100.1", "198.51.100.1"},
+		{"trusted chain of proxies", true, "10.0.0.2:51234", " 198.51.100.1 , 10.0.0.3", "198.51.100.1"},
+		{"trusted without forwarded for", true, "10.0.0.2:51234", "", "10.0.0.2"},
+		{"ipv6 remote address", false, "[2001:db8::1]:51234", "", "2001:db8::1"},
+		{"remote address without port", false, "203.0.113.7", "", "203.0.113.7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &Handler{limiter: ratelimit.NewLimiter(nil, ratelimit.Config{TrustForwardedFor: test.trusted})}
+			r := httptest.NewRequest("GET", "/api/v1/me", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if ip := handler.clientIP(r); ip != test.want {
+				t.Errorf("expected %s, got %s", test.want, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package endpoint
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/spotify"
+	"utilserver/pkg/tracing"
+
+	"github.com/gorilla/mux"
+	"github.com/lithammer/shortuuid/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader - header carrying id of request, kept when client sends a valid one
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// paths polled by orchestrators, their requests are logged at debug level
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// statusRecorder - response writer remembering status and size of response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(p []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(p)
+	recorder.bytes += n
+	return n, err
+}
+
+// Flush - passed through for server-sent events
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack - passed through for websocket upgrades
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking unsupported")
+	}
+	if recorder.status == 0 {
+		recorder.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// requestMiddleware - tag request with an id, trace it continuing trace of W3C headers,
+// carry a logger for it in its context and log it once it's served
+func (handler *Handler) requestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = shortuuid.New()
+		}
+		// user id is only ever set by authMiddleware
+		r.Header.Del("user_id")
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		// named after route once it's matched
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPTargetKey.String(logging.RedactURL(r.URL.RequestURI())),
+				attribute.String("request_id", requestID),
+			),
+		)
+		defer span.End()
+		logger := handler.logger.With("request_id", requestID)
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			logger = logger.With("trace_id", traceID)
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(logging.NewContext(ctx, logger)))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status), tracing.UserID(r.Header.Get("user_id")))
+		// client errors aren't failures of the server
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+		logRequest := logger.Info
+		if probePaths[r.URL.Path] {
+			logRequest = logger.Debug
+		}
+		logRequest("request",
+			"method", r.Method,
+			"path", logging.RedactURL(r.URL.RequestURI()),
+			"status", recorder.status,
+			"latency_ms", time.Since(started).Milliseconds(),
+			"bytes", recorder.bytes,
+			"user_id", r.Header.Get("user_id"),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// routeMiddleware - count requests and their latency per route template and name span of
+// request after it, runs on matched routes only
+func routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		status := strconv.Itoa(recorder.status)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(started).Seconds())
+	})
+}
+
+// servicesFor - spotify services logging with logger of request and tracing as part of it
+func (handler *Handler) servicesFor(r *http.Request) spotify.Services {
+	logger := logging.FromContext(r.Context(), handler.logger)
+	return handler.services.WithContext(r.Context()).WithLogger(logger.With("user_id", r.Header.Get("user_id")))
+}
+
+// backgroundServicesFor - services of request drawing on spotify quota at background priority,
+// for bulk work like syncs and imports which shouldn't crowd out interactive requests
+func (handler *Handler) backgroundServicesFor(r *http.Request) spotify.Services {
+	return handler.servicesFor(r).WithPriority(spotify.PriorityBackground)
+}
@@ -0,0 +1,125 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"utilserver/pkg/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+const streamKeepAlive = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: allowedOrigin,
+}
+
+// allowedOrigin - whether a browser on the origin of r may open a stream. Tokens are accepted in
+// the query string for streams, so pages of other sites must not be able to open them. Clients
+// which aren't browsers send no Origin, the own host and STREAM_ALLOWED_ORIGINS are allowed
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("STREAM_ALLOWED_ORIGINS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// stream currently playing changes of authenticated user,
+// websocket is used when client asks for upgrade and server-sent events otherwise
+func (handler *Handler) streamNowPlaying() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedOrigin(r) {
+			handler.writeError(w, r, newError(http.StatusForbidden, CodeOriginNotAllowed, "origin "+r.Header.Get("Origin")+" may not open streams"))
+			return
+		}
+		metrics.NowPlayingStreams.Inc()
+		defer metrics.NowPlayingStreams.Dec()
+		if websocket.IsWebSocketUpgrade(r) {
+			handler.streamNowPlayingWebSocket(w, r)
+			return
+		}
+		handler.streamNowPlayingEvents(w, r)
+	})
+}
+
+func (handler *Handler) streamNowPlayingEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handler.writeError(w, r, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	updates, unsubscribe := handler.nowPlaying.Subscribe(r.Header.Get("user_id"))
+	defer unsubscribe()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case payload := <-updates:
+			fmt.Fprintf(w, "event: now-playing\ndata: %s\n\n", payload)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func (handler *Handler) streamNowPlayingWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has already replied with error
+		return
+	}
+	defer conn.Close()
+
+	// read until client goes away, incoming messages are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	updates, unsubscribe := handler.nowPlaying.Subscribe(r.Header.Get("user_id"))
+	defer unsubscribe()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case payload := <-updates:
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
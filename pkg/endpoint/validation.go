@@ -0,0 +1,247 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate - rules of request structs, fields are named after their query or json tag in errors
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"query", "json"} {
+			name := strings.Split(field.Tag.Get(tag), ",")[0]
+			if name != "" && name != "-" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	return v
+}
+
+// FieldError - parameter or body field which broke a rule
+type FieldError struct {
+	Field  string `json:"field"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// ValidationError - request whose parameters or body broke rules, answered as validation_failed
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+" "+field.Reason)
+	}
+	return "invalid request: " + strings.Join(reasons, ", ")
+}
+
+func newFieldError(field string, rule string, reason string) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Rule: rule, Reason: reason}}}
+}
+
+// decodeQuery - set fields of dst tagged query from query parameters of r and validate dst.
+// Fields of missing or empty parameters keep the value dst was initialized with, that's
+// where handlers set defaults. Supported fields are string, int, bool, time.Time as RFC 3339,
+// []string as comma separated list and pointers to them for parameters required without default
+func decodeQuery(r *http.Request, dst interface{}) error {
+	value := reflect.ValueOf(dst).Elem()
+	query := r.URL.Query()
+	fields := []FieldError{}
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("query")
+		raw := query.Get(name)
+		if name == "" || raw == "" {
+			continue
+		}
+		if err := setField(value.Field(i), name, raw); err != nil {
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				return err
+			}
+			fields = append(fields, *fieldErr)
+		}
+	}
+	err := validateStruct(dst)
+	if len(fields) == 0 {
+		return err
+	}
+	// rules of parameters which didn't parse are checked against their defaults, they're left out
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		unparsed := map[string]bool{}
+		for _, field := range fields {
+			unparsed[field.Field] = true
+		}
+		for _, field := range validationErr.Fields {
+			if !unparsed[field.Field] {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// setField - parse raw into field, *FieldError when raw doesn't parse as type of field
+func setField(field reflect.Value, name string, raw string) error {
+	if _, ok := field.Interface().(time.Time); ok {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return &FieldError{Field: name, Rule: "datetime", Reason: "must be a time formatted as RFC 3339"}
+		}
+		field.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Ptr:
+		// pointers tell omitted parameters from zero values
+		value := reflect.New(field.Type().Elem())
+		if err := setField(value.Elem(), name, raw); err != nil {
+			return err
+		}
+		field.Set(value)
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return &FieldError{Field: name, Rule: "int", Reason: "must be an integer"}
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return &FieldError{Field: name, Rule: "bool", Reason: "must be true or false"}
+		}
+		field.SetBool(parsed)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("query parameter " + name + " has unsupported type " + field.Type().String())
+	}
+	return nil
+}
+
+// JSON bodies are a few ids and options, larger ones aren't read
+const maxJSONBody = 1 << 20
+
+// decodeJSON - decode JSON body of r into dst and validate dst, an empty body leaves dst as
+// it was initialized when allowEmpty is set. Bodies over maxJSONBody are rejected
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, allowEmpty bool) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBody)
+	err := json.NewDecoder(r.Body).Decode(dst)
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+	switch {
+	case err == io.EOF && allowEmpty:
+	case err == io.EOF:
+		return newFieldError("body", "required", "is required")
+	case errors.As(err, &maxErr):
+		return newFieldError("body", "max_bytes", "must be at most "+strconv.Itoa(maxJSONBody>>20)+" MiB")
+	case errors.As(err, &typeErr):
+		return newFieldError(typeErr.Field, "type", "must be "+typeName(typeErr.Type))
+	case err != nil:
+		return newFieldError("body", "json", "must be valid JSON")
+	}
+	return validateStruct(dst)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	}
+	return "an object"
+}
+
+// validateStruct - check validate rules of dst
+func validateStruct(dst interface{}) error {
+	err := validate.Struct(dst)
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+	fields := make([]FieldError, 0, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		rule := fieldErr.Tag()
+		if fieldErr.Param() != "" {
+			rule += "=" + fieldErr.Param()
+		}
+		fields = append(fields, FieldError{Field: fieldPath(fieldErr), Rule: rule, Reason: describeRule(fieldErr)})
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// path of field below the request struct, like uris[0]
+func fieldPath(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fieldErr.Field()
+}
+
+// readable reason of broken rule
+func describeRule(fieldErr validator.FieldError) string {
+	param := fieldErr.Param()
+	isNumber := false
+	switch fieldErr.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		isNumber = true
+	}
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if isNumber {
+			return "must be at least " + param
+		}
+		return "must have at least " + param + " items or characters"
+	case "max":
+		if isNumber {
+			return "must be at most " + param
+		}
+		return "must have at most " + param + " items or characters"
+	case "len":
+		return "must have length " + param
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "datetime":
+		return "must be a date formatted as " + param
+	case "url":
+		return "must be a url"
+	case "startswith":
+		return "must start with " + param
+	}
+	return "breaks rule " + fieldErr.Tag()
+}
@@ -0,0 +1,91 @@
+package endpoint
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testQuery struct {
+	Limit  int       `query:"limit" validate:"min=1,max=50"`
+	Public *bool     `query:"public"`
+	Since  time.Time `query:"since"`
+	Types  []string  `query:"type" validate:"omitempty,dive,oneof=track artist"`
+	Market string    `query:"market" validate:"omitempty,len=2"`
+}
+
+func TestDecodeQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		fields []FieldError
+	}{
+		{"defaults", "", nil},
+		{"valid", "limit=10&public=true&since=2024-01-02T03:04:05Z&type=track,artist&market=DE", nil},
+		{"not an integer", "limit=ten", []FieldError{{Field: "limit", Rule: "int"}}},
+		{"not a bool", "public=maybe", []FieldError{{Field: "public", Rule: "bool"}}},
+		{"not a time", "since=2024-01-02", []FieldError{{Field: "since", Rule: "datetime"}}},
+		{"out of range", "limit=51", []FieldError{{Field: "limit", Rule: "max=50"}}},
+		{
+			name:   "parse and rule errors",
+			query:  "limit=ten&public=maybe&market=DEU",
+			fields: []FieldError{{Field: "limit", Rule: "int"}, {Field: "public", Rule: "bool"}, {Field: "market", Rule: "len=2"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst := testQuery{Limit: 20}
+			err := decodeQuery(httptest.NewRequest("GET", "/?"+test.query, nil), &dst)
+			if test.fields == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+			got := []FieldError{}
+			for _, field := range validationErr.Fields {
+				got = append(got, FieldError{Field: field.Field, Rule: field.Rule})
+			}
+			if !reflect.DeepEqual(got, test.fields) {
+				t.Errorf("expected fields %+v, got %+v", test.fields, got)
+			}
+		})
+	}
+}
+
+func TestDecodeQueryValues(t *testing.T) {
+	dst := testQuery{Limit: 20}
+	r := httptest.NewRequest("GET", "/?public=false&since=2024-01-02T03:04:05Z&type=track,+artist,", nil)
+	if err := decodeQuery(r, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Limit != 20 {
+		t.Errorf("expected default limit 20, got %d", dst.Limit)
+	}
+	if dst.Public == nil || *dst.Public {
+		t.Errorf("expected public to be set to false, got %v", dst.Public)
+	}
+	if !dst.Since.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected since %v", dst.Since)
+	}
+	if !reflect.DeepEqual(dst.Types, []string{"track", "artist"}) {
+		t.Errorf("unexpected types %v", dst.Types)
+	}
+}
+
+func TestDecodeQueryUnsupportedField(t *testing.T) {
+	var dst struct {
+		Ratio float64 `query:"ratio"`
+	}
+	err := decodeQuery(httptest.NewRequest("GET", "/?ratio=0.5", nil), &dst)
+	var validationErr *ValidationError
+	if err == nil || errors.As(err, &validationErr) {
+		t.Fatalf("expected error of unsupported type, got %v", err)
+	}
+}
@@ -1,8 +1,142 @@
 package endpoint
 
+import (
+	"time"
+)
+
+// request parameters of handlers, decodeQuery and decodeJSON fill and validate them. Defaults
+// are set on the struct before decoding, omitted parameters keep them
+
 type RecentlyPlayedQurey struct {
-	Email  string `validate:"required,email"`
-	Limit  int    `validate:"number"`
-	Before string `validate:"omitempty,datetime=2006-01-02"`
-	After  string `validate:"omitempty,datetime=2006-01-02"`
+	UserID string `validate:"required"`
+	Limit  int    `query:"limit" validate:"min=1,max=50"`
+	Before string `query:"before" validate:"omitempty,datetime=2006-01-02"`
+	After  string `query:"after" validate:"omitempty,datetime=2006-01-02"`
+}
+
+type SearchQuery struct {
+	UserID          string   `validate:"required"`
+	Query           string   `query:"q" validate:"required"`
+	Types           []string `query:"type" validate:"required,dive,oneof=track artist album playlist"`
+	Market          string   `query:"market" validate:"omitempty,len=2"`
+	Limit           int      `query:"limit" validate:"min=1,max=50"`
+	Offset          int      `query:"offset" validate:"min=0,max=1000"`
+	IncludeExternal string   `query:"include_external" validate:"omitempty,oneof=audio"`
+}
+
+// TopsQuery - top artists or tracks, ranges of spotify
+type TopsQuery struct {
+	Type      string `query:"type" validate:"oneof=tracks artists"`
+	TimeRange string `query:"time_range" validate:"oneof=short_term medium_term long_term"`
+	Limit     int    `query:"limit" validate:"min=1,max=50"`
+	Offset    int    `query:"offset" validate:"min=0"`
+}
+
+// PageQuery - page of a spotify list
+type PageQuery struct {
+	Limit  int `query:"limit" validate:"min=1,max=50"`
+	Offset int `query:"offset" validate:"min=0,max=100000"`
+}
+
+// TimeRangeQuery - time range of top items a statistic is computed from
+type TimeRangeQuery struct {
+	TimeRange string `query:"time_range" validate:"oneof=short_term medium_term long_term"`
+}
+
+// PersonalAudioFeaturesQuery - time range under its older name
+type PersonalAudioFeaturesQuery struct {
+	TimeSpan string `query:"timespan" validate:"oneof=short_term medium_term long_term"`
+}
+
+// IDsQuery - comma separated spotify ids
+type IDsQuery struct {
+	IDs []string `query:"ids" validate:"required,min=1,max=100,dive,max=64"`
+}
+
+// MarketQuery - country code of market items are relinked for
+type MarketQuery struct {
+	Market string `query:"market" validate:"omitempty,len=2"`
+}
+
+// ArtistGraphQuery - size of related artist graph
+type ArtistGraphQuery struct {
+	TimeRange string `query:"time_range" validate:"oneof=short_term medium_term long_term"`
+	Seeds     int    `query:"seeds" validate:"min=1,max=50"`
+	Hops      int    `query:"hops" validate:"min=1,max=3"`
+	Fanout    int    `query:"fanout" validate:"min=1,max=20"`
+}
+
+// HistoryQuery - page of stored listening history between from and to
+type HistoryQuery struct {
+	From   time.Time `query:"from"`
+	To     time.Time `query:"to"`
+	Limit  int       `query:"limit" validate:"min=1,max=500"`
+	Offset int       `query:"offset" validate:"min=0"`
+}
+
+// LibraryPageQuery - page of synced library items
+type LibraryPageQuery struct {
+	Limit  int `query:"limit" validate:"min=1,max=500"`
+	Offset int `query:"offset" validate:"min=0"`
+}
+
+// LibraryKindQuery - kind of library items
+type LibraryKindQuery struct {
+	Kind string `query:"kind" validate:"oneof=tracks albums artists"`
+}
+
+// DeliveriesQuery - size of webhook delivery log
+type DeliveriesQuery struct {
+	Limit int `query:"limit" validate:"min=1,max=100"`
+}
+
+// RestorePlaylistQuery - version a playlist is rewritten to
+type RestorePlaylistQuery struct {
+	Version int `query:"version" validate:"min=1"`
+}
+
+// ExportQuery - format of export archive
+type ExportQuery struct {
+	Format string `query:"format" validate:"oneof=json csv parquet"`
+}
+
+// ScrobblingQuery - whether plays are scrobbled
+type ScrobblingQuery struct {
+	Enabled string `query:"enabled" validate:"required,oneof=true false"`
+}
+
+// PlayerQuery - device and market of player calls, both optional
+type PlayerQuery struct {
+	DeviceID string `query:"device_id" validate:"omitempty,max=128"`
+	Market   string `query:"market" validate:"omitempty,len=2"`
+}
+
+type SeekQuery struct {
+	PositionMS *int `query:"position_ms" validate:"required,min=0"`
+}
+
+type VolumeQuery struct {
+	VolumePercent *int `query:"volume_percent" validate:"required,min=0,max=100"`
+}
+
+type ShuffleQuery struct {
+	State string `query:"state" validate:"required,oneof=true false"`
+}
+
+type RepeatQuery struct {
+	State string `query:"state" validate:"required,oneof=track context off"`
+}
+
+type QueueQuery struct {
+	URI string `query:"uri" validate:"required,startswith=spotify:"`
+}
+
+type TransferPlaybackBody struct {
+	DeviceIDs []string `json:"device_ids" validate:"len=1,dive,required"`
+	Play      bool     `json:"play"`
+}
+
+type RegisterWebhookBody struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1,dive,required"`
 }
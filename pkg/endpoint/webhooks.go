@@ -0,0 +1,81 @@
+package endpoint
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// register endpoint receiving events of user, response carries the signing secret once
+func (handler *Handler) registerWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		var body RegisterWebhookBody
+		if err := decodeJSON(w, r, &body, false); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		endpoint, err := handler.webhooks.RegisterEndpoint(userID, body.URL, body.Events)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/webhooks/"+endpoint.ID.Hex())
+		writeJSON(w, endpoint, http.StatusCreated)
+	})
+}
+
+// list endpoints of user
+func (handler *Handler) getWebhooks() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		endpoints, err := handler.webhooks.ListEndpoints(userID)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writeJSON(w, endpoints, http.StatusOK)
+	})
+}
+
+func (handler *Handler) deleteWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		if err := handler.webhooks.DeleteEndpoint(userID, mux.Vars(r)["id"]); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// delivery log of endpoint newest first, attempts show responses of the endpoint
+func (handler *Handler) getWebhookDeliveries() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		query := DeliveriesQuery{Limit: 50}
+		if err := decodeQuery(r, &query); err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		deliveries, err := handler.webhooks.ListDeliveries(userID, mux.Vars(r)["id"], query.Limit)
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writeJSON(w, deliveries, http.StatusOK)
+	})
+}
+
+// send event of a delivery again
+func (handler *Handler) redeliverWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("user_id")
+		delivery, err := handler.webhooks.Redeliver(userID, mux.Vars(r)["id"])
+		if err != nil {
+			handler.writeError(w, r, err)
+			return
+		}
+		writeJSON(w, delivery, http.StatusAccepted)
+	})
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// supported export formats
+const (
+	JSON    = "json"
+	CSV     = "csv"
+	Parquet = "parquet"
+)
+
+// Table - one dataset of the archive. Rows is a slice of structs tagged with
+// csv and parquet tags, Schema a pointer to the same struct used for parquet schema
+type Table struct {
+	Name   string
+	Rows   interface{}
+	Schema interface{}
+}
+
+// ValidFormat - whether format is supported
+func ValidFormat(format string) bool {
+	return format == JSON || format == CSV || format == Parquet
+}
+
+// WriteArchive - write zip archive with document as json and every table in format
+func WriteArchive(w io.Writer, format string, documents map[string]interface{}, tables []Table) error {
+	if !ValidFormat(format) {
+		return errors.New("unsupported export format " + format)
+	}
+	archive := zip.NewWriter(w)
+	for name, document := range documents {
+		file, err := archive.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+	for _, table := range tables {
+		file, err := archive.Create(table.Name + "." + format)
+		if err != nil {
+			return err
+		}
+		switch format {
+		case JSON:
+			err = json.NewEncoder(file).Encode(table.Rows)
+		case CSV:
+			err = writeCSV(file, table.Rows)
+		case Parquet:
+			err = writeParquet(file, table)
+		}
+		if err != nil {
+			return fmt.Errorf("export %s: %w", table.Name, err)
+		}
+	}
+	return archive.Close()
+}
+
+// write rows as csv, header is made of csv tags of row struct fields
+func writeCSV(w io.Writer, rows interface{}) error {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return errors.New("rows must be a slice")
+	}
+	rowType := value.Type().Elem()
+	header := []string{}
+	for i := 0; i < rowType.NumField(); i++ {
+		header = append(header, rowType.Field(i).Tag.Get("csv"))
+	}
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		record := make([]string, row.NumField())
+		for j := 0; j < row.NumField(); j++ {
+			record[j] = formatField(row.Field(j))
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func formatField(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.String:
+		return field.String()
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool())
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+func writeParquet(w io.Writer, table Table) error {
+	parquetWriter, err := writer.NewParquetWriterFromWriter(w, table.Schema, 1)
+	if err != nil {
+		return err
+	}
+	rows := reflect.ValueOf(table.Rows)
+	for i := 0; i < rows.Len(); i++ {
+		if err := parquetWriter.Write(rows.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return parquetWriter.WriteStop()
+}
@@ -0,0 +1,171 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+	"utilserver/pkg/clients"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+const (
+	// time a dependency has to answer a ping
+	pingTimeout = 2 * time.Second
+	// last spotify check is shared by replicas through cache, it's dropped when checks stop
+	spotifyCheckKey = "health:spotify-token-endpoint"
+	spotifyCheckTTL = 10 * time.Minute
+	// a token endpoint answering slower than this counts as unreachable
+	spotifyCheckTimeout = 3 * time.Second
+)
+
+// Pinger - dependency that can be checked
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type Cache interface {
+	Pinger
+	Get(key string) (interface{}, error)
+	Set(key string, value interface{}, expiration time.Duration) error
+}
+
+type HTTPClient interface {
+	RequestWithContext(ctx context.Context, methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error)
+}
+
+// Dependency - outcome of pinging a dependency
+type Dependency struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Readiness - whether every dependency answered
+type Readiness struct {
+	Status       string       `json:"status"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// SpotifyCheck - last check of spotify token endpoint, any answer below 500 means it's reachable
+type SpotifyCheck struct {
+	Reachable  bool      `json:"reachable"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Status - readiness with build and runtime details, spotify is nil until it's been checked
+type Status struct {
+	Readiness
+	Version       string        `json:"version"`
+	StartedAt     time.Time     `json:"started_at"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	Spotify       *SpotifyCheck `json:"spotify_token_endpoint"`
+}
+
+type Service struct {
+	version    string
+	startedAt  time.Time
+	storage    Pinger
+	cache      Cache
+	httpClient HTTPClient
+	tokenURL   string
+}
+
+// NewService - health of storage and cache, tokenURL is the spotify endpoint checked for reachability
+func NewService(version string, storage Pinger, cache Cache, httpClient HTTPClient, tokenURL string) *Service {
+	return &Service{
+		version:    version,
+		startedAt:  time.Now(),
+		storage:    storage,
+		cache:      cache,
+		httpClient: httpClient,
+		tokenURL:   tokenURL,
+	}
+}
+
+// Ready - ping every dependency at once, ready only when all of them answered in time
+func (service *Service) Ready(ctx context.Context) Readiness {
+	pingers := []struct {
+		name   string
+		pinger Pinger
+	}{{"mongo", service.storage}, {"redis", service.cache}}
+	readiness := Readiness{Status: StatusUp, Dependencies: make([]Dependency, len(pingers))}
+	var wait sync.WaitGroup
+	for i, dependency := range pingers {
+		wait.Add(1)
+		go func(i int, name string, pinger Pinger) {
+			defer wait.Done()
+			readiness.Dependencies[i] = ping(ctx, name, pinger)
+		}(i, dependency.name, dependency.pinger)
+	}
+	wait.Wait()
+	for _, dependency := range readiness.Dependencies {
+		if dependency.Status != StatusUp {
+			readiness.Status = StatusDown
+		}
+	}
+	return readiness
+}
+
+func ping(ctx context.Context, name string, pinger Pinger) Dependency {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	started := time.Now()
+	err := pinger.Ping(ctx)
+	dependency := Dependency{Name: name, Status: StatusUp, LatencyMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		dependency.Status = StatusDown
+		dependency.Error = err.Error()
+	}
+	return dependency
+}
+
+// Status - readiness, version, uptime and last spotify check
+func (service *Service) Status(ctx context.Context) Status {
+	status := Status{
+		Readiness:     service.Ready(ctx),
+		Version:       service.version,
+		StartedAt:     service.startedAt,
+		UptimeSeconds: int64(time.Since(service.startedAt).Seconds()),
+	}
+	if stored, err := service.cache.Get(spotifyCheckKey); err == nil {
+		storedCheck, _ := stored.(string)
+		var check SpotifyCheck
+		if json.Unmarshal([]byte(storedCheck), &check) == nil {
+			status.Spotify = &check
+		}
+	}
+	return status
+}
+
+// CheckSpotify - check whether spotify token endpoint answers and keep the outcome for
+// Status, run by scheduler
+func (service *Service) CheckSpotify() error {
+	started := time.Now()
+	check := SpotifyCheck{CheckedAt: started}
+	// request without credentials, spotify rejects it but answers when it's up
+	ctx := clients.WithTimeout(context.Background(), spotifyCheckTimeout)
+	resp, err := service.httpClient.RequestWithContext(ctx, http.MethodPost, service.tokenURL, nil, "application/x-www-form-urlencoded", "")
+	check.LatencyMS = time.Since(started).Milliseconds()
+	if err != nil {
+		check.Error = err.Error()
+	} else {
+		resp.Body.Close()
+		check.StatusCode = resp.StatusCode
+		check.Reachable = resp.StatusCode < http.StatusInternalServerError
+	}
+	checkByteArr, err := json.Marshal(check)
+	if err != nil {
+		return err
+	}
+	return service.cache.Set(spotifyCheckKey, string(checkByteArr), spotifyCheckTTL)
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"strconv"
+	"sync"
+	"time"
+	"utilserver/pkg/logging"
+)
+
+type Locker interface {
+	SetNX(key string, value interface{}, expiration time.Duration) (bool, error)
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// Scheduler - run jobs on fixed intervals. Time is split into slots of job interval and
+// replicas race for a redis lock of every slot, so a job runs once per slot across replicas
+type Scheduler struct {
+	locker Locker
+	logger *logging.Logger
+	jobs   []job
+	stop   chan struct{}
+	wait   sync.WaitGroup
+}
+
+func NewScheduler(locker Locker, logger *logging.Logger) *Scheduler {
+	return &Scheduler{locker: locker, logger: logger, stop: make(chan struct{})}
+}
+
+// Every - register job run on interval, must be called before Start
+func (scheduler *Scheduler) Every(name string, interval time.Duration, run func() error) {
+	scheduler.jobs = append(scheduler.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start - start running registered jobs in background
+func (scheduler *Scheduler) Start() {
+	for _, j := range scheduler.jobs {
+		scheduler.wait.Add(1)
+		go scheduler.loop(j)
+	}
+}
+
+// Stop - stop scheduling and wait for running jobs to finish
+func (scheduler *Scheduler) Stop() {
+	close(scheduler.stop)
+	scheduler.wait.Wait()
+}
+
+func (scheduler *Scheduler) loop(j job) {
+	defer scheduler.wait.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		scheduler.runSlot(j)
+		select {
+		case <-scheduler.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (scheduler *Scheduler) runSlot(j job) {
+	slot := time.Now().UnixNano() / int64(j.interval)
+	acquired, err := scheduler.locker.SetNX("job:"+j.name+":"+strconv.FormatInt(slot, 10), "1", j.interval)
+	if err != nil {
+		scheduler.logger.Warn("job lock failed", "job", j.name, "error", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	started := time.Now()
+	if err := j.run(); err != nil {
+		scheduler.logger.Error("job failed", "job", j.name, "error", err)
+		return
+	}
+	scheduler.logger.Info("job finished", "job", j.name, "duration", time.Since(started))
+}
@@ -0,0 +1,125 @@
+package lastfm
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const apiURL = "https://ws.audioscrobbler.com/2.0/"
+
+type HTTPClient interface {
+	Request(methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error)
+}
+
+// APIError - error returned by last.fm api
+type APIError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return "lastfm: " + e.Message + " (" + strconv.Itoa(e.Code) + ")"
+}
+
+// Session - authorized last.fm session, key doesn't expire until user revokes it
+type Session struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// Client - signed calls of last.fm web api
+type Client struct {
+	httpClient HTTPClient
+	apiKey     string
+	secret     string
+}
+
+func New(httpClient HTTPClient, apiKey string, secret string) *Client {
+	return &Client{httpClient: httpClient, apiKey: apiKey, secret: secret}
+}
+
+// AuthURL - page where user grants access, last.fm redirects to callback with token parameter
+func (client *Client) AuthURL(callback string) string {
+	params := url.Values{}
+	params.Set("api_key", client.apiKey)
+	params.Set("cb", callback)
+	return "https://www.last.fm/api/auth/?" + params.Encode()
+}
+
+// sign - api_sig of params, md5 of sorted key value pairs followed by secret
+func (client *Client) sign(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	payload := ""
+	for _, key := range keys {
+		payload += key + params[key]
+	}
+	sum := md5.Sum([]byte(payload + client.secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Call - signed call of api method, write methods are sent as POST
+func (client *Client) Call(method string, params map[string]string, write bool, result interface{}) error {
+	signed := map[string]string{"method": method, "api_key": client.apiKey}
+	for key, value := range params {
+		signed[key] = value
+	}
+	signed["api_sig"] = client.sign(signed)
+	signed["format"] = "json"
+
+	var resp *http.Response
+	var err error
+	if write {
+		body := map[string]interface{}{}
+		for key, value := range signed {
+			body[key] = value
+		}
+		resp, err = client.httpClient.Request("POST", apiURL, body, "application/x-www-form-urlencoded", "")
+	} else {
+		query := url.Values{}
+		for key, value := range signed {
+			query.Set(key, value)
+		}
+		resp, err = client.httpClient.Request("GET", apiURL+"?"+query.Encode(), nil, "application/json", "")
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var apiErr APIError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+		return &apiErr
+	}
+	if resp.StatusCode >= 400 {
+		return errors.New("lastfm: request failed with status " + strconv.Itoa(resp.StatusCode))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// GetSession - exchange token from auth callback for a session
+func (client *Client) GetSession(token string) (*Session, error) {
+	var container struct {
+		Session Session `json:"session"`
+	}
+	if err := client.Call("auth.getSession", map[string]string{"token": token}, false, &container); err != nil {
+		return nil, err
+	}
+	return &container.Session, nil
+}
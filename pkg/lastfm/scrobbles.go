@@ -0,0 +1,138 @@
+package lastfm
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// last.fm accepts at most this many scrobbles in one request
+const scrobbleBatch = 50
+
+// Scrobble - play of a track sent to or read from last.fm, timestamp is when the play started
+type Scrobble struct {
+	Artist    string
+	Track     string
+	Album     string
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// RecentTracks - page of user's scrobbles newest first
+type RecentTracks struct {
+	Scrobbles  []Scrobble
+	Page       int
+	TotalPages int
+}
+
+type recentTrack struct {
+	Name   string `json:"name"`
+	Artist struct {
+		Text string `json:"#text"`
+	} `json:"artist"`
+	Album struct {
+		Text string `json:"#text"`
+	} `json:"album"`
+	Date struct {
+		UTS string `json:"uts"`
+	} `json:"date"`
+	Attr struct {
+		NowPlaying string `json:"nowplaying"`
+	} `json:"@attr"`
+}
+
+// Scrobble - add plays to scrobbles of session's user, returns how many last.fm accepted
+func (client *Client) Scrobble(sessionKey string, scrobbles []Scrobble) (int, error) {
+	accepted := 0
+	for start := 0; start < len(scrobbles); start += scrobbleBatch {
+		end := start + scrobbleBatch
+		if end > len(scrobbles) {
+			end = len(scrobbles)
+		}
+		params := map[string]string{"sk": sessionKey}
+		for i, scrobble := range scrobbles[start:end] {
+			index := "[" + strconv.Itoa(i) + "]"
+			params["artist"+index] = scrobble.Artist
+			params["track"+index] = scrobble.Track
+			params["timestamp"+index] = strconv.FormatInt(scrobble.Timestamp.Unix(), 10)
+			if scrobble.Album != "" {
+				params["album"+index] = scrobble.Album
+			}
+			if scrobble.Duration > 0 {
+				params["duration"+index] = strconv.Itoa(int(scrobble.Duration.Seconds()))
+			}
+		}
+		var container struct {
+			Scrobbles struct {
+				Attr struct {
+					Accepted int `json:"accepted"`
+				} `json:"@attr"`
+			} `json:"scrobbles"`
+		}
+		if err := client.Call("track.scrobble", params, true, &container); err != nil {
+			return accepted, err
+		}
+		accepted += container.Scrobbles.Attr.Accepted
+	}
+	return accepted, nil
+}
+
+// GetRecentTracks - page of user's scrobbles between from and to, zero times leave range open.
+// Track playing right now isn't a scrobble yet and is left out
+func (client *Client) GetRecentTracks(user string, from time.Time, to time.Time, page int) (*RecentTracks, error) {
+	params := map[string]string{
+		"user":  user,
+		"limit": "200",
+		"page":  strconv.Itoa(page),
+	}
+	if !from.IsZero() {
+		params["from"] = strconv.FormatInt(from.Unix(), 10)
+	}
+	if !to.IsZero() {
+		params["to"] = strconv.FormatInt(to.Unix(), 10)
+	}
+	var container struct {
+		RecentTracks struct {
+			Track json.RawMessage `json:"track"`
+			Attr  struct {
+				Page       string `json:"page"`
+				TotalPages string `json:"totalPages"`
+			} `json:"@attr"`
+		} `json:"recenttracks"`
+	}
+	if err := client.Call("user.getRecentTracks", params, false, &container); err != nil {
+		return nil, err
+	}
+	// page with a single track holds the object itself instead of a list
+	tracks := []recentTrack{}
+	if len(container.RecentTracks.Track) > 0 && container.RecentTracks.Track[0] == '{' {
+		var track recentTrack
+		if err := json.Unmarshal(container.RecentTracks.Track, &track); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	} else if len(container.RecentTracks.Track) > 0 {
+		if err := json.Unmarshal(container.RecentTracks.Track, &tracks); err != nil {
+			return nil, err
+		}
+	}
+	recent := &RecentTracks{Scrobbles: []Scrobble{}}
+	recent.Page, _ = strconv.Atoi(container.RecentTracks.Attr.Page)
+	recent.TotalPages, _ = strconv.Atoi(container.RecentTracks.Attr.TotalPages)
+	for _, track := range tracks {
+		if track.Attr.NowPlaying == "true" {
+			continue
+		}
+		uts, err := strconv.ParseInt(track.Date.UTS, 10, 64)
+		if err != nil {
+			continue
+		}
+		recent.Scrobbles = append(recent.Scrobbles, Scrobble{
+			Artist:    track.Artist.Text,
+			Track:     track.Name,
+			Album:     track.Album.Text,
+			Timestamp: time.Unix(uts, 0).UTC(),
+		})
+	}
+	return recent, nil
+}
@@ -0,0 +1,212 @@
+package lastfm
+
+import (
+	"errors"
+	"time"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// last.fm ignores scrobbles of plays older than two weeks and of tracks shorter than 30 seconds
+const (
+	maxScrobbleAge    = 14 * 24 * time.Hour
+	minScrobbleLength = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("last.fm account is not linked")
+
+// Profile - last.fm account linked to user
+type Profile struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID       string             `bson:"user_id" json:"-"`
+	Name         string             `bson:"name" json:"name"`
+	Scrobbling   bool               `bson:"scrobbling" json:"scrobbling"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at,omitempty" json:"updated_at"`
+	BackfilledAt time.Time          `bson:"backfilled_at,omitempty" json:"backfilled_at"`
+	Credentials  Credentials        `bson:"credentials" json:"-"`
+}
+
+// Credentials - session of last.fm account
+type Credentials struct {
+	SessionKey string    `bson:"session_key" json:"session_key"`
+	CreatedAt  time.Time `bson:"created_at,omitempty"`
+	UpdatedAt  time.Time `bson:"updated_at,omitempty"`
+}
+
+type Storage interface {
+	UpsertLastFMProfile(profile Profile) error
+	GetLastFMProfile(userID string) (*Profile, error)
+	ListLastFMUserIDs() ([]string, error)
+	SetLastFMScrobbling(userID string, enabled bool) error
+	SetLastFMBackfilledAt(userID string, backfilledAt time.Time) error
+	DeleteLastFMProfile(userID string) error
+}
+
+// History - listening history scrobbles are imported into
+type History interface {
+	ImportHistory(userID string, plays []spotify.Play) (*spotify.HistoryImport, error)
+}
+
+// Service - scrobbling of ingested plays and backfill of scrobbles into listening history
+type Service struct {
+	client  *Client
+	storage Storage
+	history History
+	logger  *logging.Logger
+}
+
+func NewService(client *Client, storage Storage, history History, logger *logging.Logger) *Service {
+	return &Service{client: client, storage: storage, history: history, logger: logger}
+}
+
+// Connect - store session of linked last.fm account, scrobbling of new accounts starts enabled
+func (service *Service) Connect(userID string, name string, sessionKey string) error {
+	now := time.Now()
+	return service.storage.UpsertLastFMProfile(Profile{
+		UserID:     userID,
+		Name:       name,
+		Scrobbling: true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Credentials: Credentials{
+			SessionKey: sessionKey,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+	})
+}
+
+// Disconnect - forget last.fm session of user
+func (service *Service) Disconnect(userID string) error {
+	return service.storage.DeleteLastFMProfile(userID)
+}
+
+// GetProfile - linked last.fm account of user
+func (service *Service) GetProfile(userID string) (*Profile, error) {
+	profile, err := service.storage.GetLastFMProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, ErrNotConnected
+	}
+	return profile, nil
+}
+
+// SetScrobbling - turn scrobbling of user's plays on or off, users scrobbling from the spotify
+// app itself turn it off
+func (service *Service) SetScrobbling(userID string, enabled bool) error {
+	if _, err := service.GetProfile(userID); err != nil {
+		return err
+	}
+	return service.storage.SetLastFMScrobbling(userID, enabled)
+}
+
+// ScrobblePlays - scrobble plays ingested from the api, plays last.fm would ignore are skipped.
+// Meant as listener of history ingest
+func (service *Service) ScrobblePlays(userID string, plays []spotify.Play) {
+	profile, err := service.storage.GetLastFMProfile(userID)
+	if err != nil {
+		service.logger.Warn("scrobbling failed", "user_id", userID, "error", err)
+		return
+	}
+	if profile == nil || !profile.Scrobbling {
+		return
+	}
+	cutoff := time.Now().Add(-maxScrobbleAge)
+	scrobbles := []Scrobble{}
+	for _, play := range plays {
+		length := time.Duration(play.MSPlayed) * time.Millisecond
+		if play.Source != spotify.PlaySourceAPI || length < minScrobbleLength || play.StartedAt().Before(cutoff) {
+			continue
+		}
+		scrobbles = append(scrobbles, Scrobble{
+			Artist:    play.ArtistName,
+			Track:     play.TrackName,
+			Album:     play.AlbumName,
+			Timestamp: play.StartedAt(),
+			Duration:  length,
+		})
+	}
+	if len(scrobbles) == 0 {
+		return
+	}
+	if _, err := service.client.Scrobble(profile.Credentials.SessionKey, scrobbles); err != nil {
+		service.logger.Warn("scrobbling failed", "user_id", userID, "error", err)
+	}
+}
+
+// Backfill - import scrobbles made since the last backfill into listening history. Pages are
+// imported oldest first so an interrupted backfill continues where it stopped
+func (service *Service) Backfill(userID string) (*spotify.HistoryImport, error) {
+	profile, err := service.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	var from time.Time
+	if !profile.BackfilledAt.IsZero() {
+		from = profile.BackfilledAt.Add(time.Second)
+	}
+	// fixed end keeps pages stable while new scrobbles come in
+	to := time.Now()
+	first, err := service.client.GetRecentTracks(profile.Name, from, to, 1)
+	if err != nil {
+		return nil, err
+	}
+	result := &spotify.HistoryImport{}
+	for page := first.TotalPages; page >= 1; page-- {
+		recent := first
+		if page != 1 {
+			if recent, err = service.client.GetRecentTracks(profile.Name, from, to, page); err != nil {
+				return result, err
+			}
+		}
+		if len(recent.Scrobbles) == 0 {
+			continue
+		}
+		plays := make([]spotify.Play, 0, len(recent.Scrobbles))
+		newest := recent.Scrobbles[0].Timestamp
+		for _, scrobble := range recent.Scrobbles {
+			plays = append(plays, spotify.Play{
+				PlayedAt:   scrobble.Timestamp,
+				TrackName:  scrobble.Track,
+				ArtistName: scrobble.Artist,
+				AlbumName:  scrobble.Album,
+				Source:     spotify.PlaySourceLastFM,
+			})
+			if scrobble.Timestamp.After(newest) {
+				newest = scrobble.Timestamp
+			}
+		}
+		imported, err := service.history.ImportHistory(userID, plays)
+		if err != nil {
+			return result, err
+		}
+		result.Parsed += imported.Parsed
+		result.Imported += imported.Imported
+		result.Duplicates += imported.Duplicates
+		result.Resolved += imported.Resolved
+		result.Unresolved += imported.Unresolved
+		if err := service.storage.SetLastFMBackfilledAt(userID, newest); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// BackfillAll - import new scrobbles of every linked last.fm account
+func (service *Service) BackfillAll() error {
+	userIDs, err := service.storage.ListLastFMUserIDs()
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := service.Backfill(userID); err != nil {
+			service.logger.Warn("last.fm backfill failed", "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// formats of log lines
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// ParseLevel - level by name, unknown names are info
+func ParseLevel(name string) Level {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level
+		}
+	}
+	return LevelInfo
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger - leveled logger writing one json object or text line per entry. Loggers derived
+// with With share output of their parent. Methods of nil logger do nothing
+type Logger struct {
+	out    io.Writer
+	mutex  *sync.Mutex
+	level  Level
+	format string
+	fields []field
+}
+
+// New - logger writing entries of level and above to out, format is json or text
+func New(out io.Writer, level Level, format string) *Logger {
+	if format != FormatText {
+		format = FormatJSON
+	}
+	return &Logger{out: out, mutex: &sync.Mutex{}, level: level, format: format}
+}
+
+// With - logger adding key value pairs to every entry
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	if logger == nil {
+		return nil
+	}
+	derived := *logger
+	derived.fields = append(append([]field{}, logger.fields...), pairs(keyvals)...)
+	return &derived
+}
+
+// Enabled - whether entries of level are written
+func (logger *Logger) Enabled(level Level) bool {
+	return logger != nil && level >= logger.level
+}
+
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.log(LevelDebug, msg, keyvals)
+}
+
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log(LevelInfo, msg, keyvals)
+}
+
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log(LevelWarn, msg, keyvals)
+}
+
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.log(LevelError, msg, keyvals)
+}
+
+func pairs(keyvals []interface{}) []field {
+	fields := make([]field, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fields = append(fields, field{key: key, value: value})
+	}
+	return fields
+}
+
+// value as it's written, errors and durations aren't marshaled well by encoding/json
+func plain(key string, value interface{}) interface{} {
+	if sensitiveKey(key) {
+		return redacted
+	}
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+	fields := append([]field{
+		{key: "time", value: time.Now().UTC().Format(time.RFC3339Nano)},
+		{key: "level", value: level.String()},
+		{key: "msg", value: msg},
+	}, logger.fields...)
+	fields = append(fields, pairs(keyvals)...)
+
+	var line bytes.Buffer
+	if logger.format == FormatText {
+		for i, f := range fields {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			if i >= 3 {
+				line.WriteString(f.key + "=")
+			}
+			text := fmt.Sprint(plain(f.key, f.value))
+			if i >= 2 && strings.ContainsAny(text, " \"=\n") {
+				text = strconv.Quote(text)
+			}
+			line.WriteString(text)
+		}
+	} else {
+		line.WriteByte('{')
+		for i, f := range fields {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			key, _ := json.Marshal(f.key)
+			value, err := json.Marshal(plain(f.key, f.value))
+			if err != nil {
+				value, _ = json.Marshal(fmt.Sprint(f.value))
+			}
+			line.Write(key)
+			line.WriteByte(':')
+			line.Write(value)
+		}
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+	logger.mutex.Lock()
+	logger.out.Write(line.Bytes())
+	logger.mutex.Unlock()
+}
+
+// Writer - writer logging every line written to it as an entry of level, used to route
+// output of standard log package through the logger
+func (logger *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		logger.log(level, strings.TrimRight(string(p), "\n"), nil)
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+type contextKey struct{}
+
+// NewContext - context carrying logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext - logger of context, fallback when context carries none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+	return fallback
+}
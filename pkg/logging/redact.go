@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// names of headers, query parameters and fields holding secrets
+var sensitiveNames = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"token":         true,
+	"code":          true,
+	"client_secret": true,
+	"secret":        true,
+	"password":      true,
+	"sk":            true,
+	"api_sig":       true,
+	"session_key":   true,
+}
+
+func sensitiveKey(key string) bool {
+	return sensitiveNames[strings.ToLower(key)]
+}
+
+// RedactURL - url with values of secret query parameters replaced
+func RedactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if parsed.User != nil {
+		parsed.User = url.User(redacted)
+	}
+	if parsed.RawQuery == "" {
+		return parsed.String()
+	}
+	query := parsed.Query()
+	for key := range query {
+		if sensitiveKey(key) {
+			query.Set(key, redacted)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// RedactHeaders - headers flattened to one value each with secret ones replaced
+func RedactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for key, values := range header {
+		if sensitiveKey(key) {
+			headers[key] = redacted
+			continue
+		}
+		headers[key] = strings.Join(values, ", ")
+	}
+	return headers
+}
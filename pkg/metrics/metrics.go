@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "utilserver"
+
+// users who made an authenticated request within this window count as active sessions
+const sessionWindow = 15 * time.Minute
+
+var (
+	// inbound requests per mux route template
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Inbound requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of inbound requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// outbound spotify calls per normalized endpoint
+	SpotifyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_requests_total",
+		Help:      "Spotify calls by endpoint, method and status, status is error when no response was received.",
+	}, []string{"endpoint", "method", "status"})
+	SpotifyRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "spotify_request_duration_seconds",
+		Help:      "Latency of spotify calls by endpoint and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "method"})
+	SpotifyRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_retries_total",
+		Help:      "Spotify calls retried after being rate limited, by endpoint.",
+	}, []string{"endpoint"})
+
+	// waits for spotify quota of the app by priority
+	SpotifyQuotaWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "spotify_quota_wait_seconds",
+		Help:      "Time calls waited for spotify quota of the app by priority.",
+		Buckets:   []float64{.005, .05, .25, 1, 2.5, 5, 15, 60, 300},
+	}, []string{"priority"})
+	SpotifyQuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_quota_rejections_total",
+		Help:      "Calls given up because spotify quota of the app was used up, by priority.",
+	}, []string{"priority"})
+	SpotifyQuotaPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_quota_pauses_total",
+		Help:      "Global pauses of spotify calls after spotify answered 429.",
+	})
+
+	// token refreshes by result: success, reauth_required or error
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_token_refreshes_total",
+		Help:      "Spotify access token refreshes by result.",
+	}, []string{"result"})
+
+	// cache lookups by result: hit, miss or error
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by result.",
+	}, []string{"result"})
+
+	MongoOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Latency of mongo commands by command and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "outcome"})
+
+	// circuit breakers by upstream: 0 closed, 1 half-open, 2 open
+	BreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "State of circuit breakers by upstream, 0 closed, 1 half-open and 2 open.",
+	}, []string{"breaker"})
+
+	NowPlayingStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "now_playing_streams",
+		Help:      "Open now playing streams of this replica.",
+	})
+)
+
+var sessions = struct {
+	sync.Mutex
+	lastSeen map[string]time.Time
+}{lastSeen: map[string]time.Time{}}
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Users with an authenticated request to this replica in the last 15 minutes.",
+	}, activeSessions)
+}
+
+// Handler - prometheus exposition of every metric
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// SessionSeen - mark user as active
+func SessionSeen(userID string) {
+	if userID == "" {
+		return
+	}
+	sessions.Lock()
+	sessions.lastSeen[userID] = time.Now()
+	sessions.Unlock()
+}
+
+// number of active users, inactive ones are forgotten
+func activeSessions() float64 {
+	sessions.Lock()
+	defer sessions.Unlock()
+	for userID, seen := range sessions.lastSeen {
+		if time.Since(seen) > sessionWindow {
+			delete(sessions.lastSeen, userID)
+		}
+	}
+	return float64(len(sessions.lastSeen))
+}
+
+// ObserveSpotifyRequest - count spotify call, status 0 means no response was received
+func ObserveSpotifyRequest(method string, URL string, status int, latency time.Duration) {
+	endpoint := SpotifyEndpoint(URL)
+	statusLabel := "error"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+	SpotifyRequests.WithLabelValues(endpoint, method, statusLabel).Inc()
+	SpotifyRequestDuration.WithLabelValues(endpoint, method).Observe(latency.Seconds())
+}
+
+// spotify ids are base62 strings of 22 characters, user ids may be anything else
+var spotifyID = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// SpotifyEndpoint - host and path of url with ids replaced so that label values stay few
+func SpotifyEndpoint(URL string) string {
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return "unknown"
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	for i, segment := range segments {
+		switch {
+		case i > 0 && (segments[i-1] == "users" || segments[i-1] == "playlists"):
+			segments[i] = "{id}"
+		case spotifyID.MatchString(segment):
+			segments[i] = "{id}"
+		}
+	}
+	return parsed.Host + "/" + strings.Join(segments, "/")
+}
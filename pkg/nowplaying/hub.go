@@ -0,0 +1,213 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+	"utilserver/pkg/logging"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+type Player interface {
+	GetCurrentlyPlaying(userID string, market string) (*[]byte, error)
+}
+
+type Cache interface {
+	Get(key string) (interface{}, error)
+	Set(key string, value interface{}, expiration time.Duration) error
+	SetNX(key string, value interface{}, expiration time.Duration) (bool, error)
+	ExtendLock(key string, token string, expiration time.Duration) (bool, error)
+	ReleaseLock(key string, token string) error
+	Clear(key string) error
+	Publish(channel string, message interface{}) error
+	Subscribe(channel string) (<-chan string, func() error)
+}
+
+// payload pushed when nothing is playing, spotify answers with empty body in that case
+var idlePayload = []byte(`{"is_playing":false,"item":null}`)
+
+// Hub - fan out currently playing changes of a user to every open connection.
+// Each replica runs one poller per active user, pollers of the same user coordinate through
+// a redis lock so only the poller holding it polls spotify and publishes changes to pub/sub
+type Hub struct {
+	player   Player
+	cache    Cache
+	interval time.Duration
+	logger   *logging.Logger
+	mutex    sync.Mutex
+	pollers  map[string]*poller
+}
+
+type poller struct {
+	userID string
+	// token - value of the lock while this poller holds it, unique so no other poller extends
+	// or releases it
+	token       string
+	subscribers map[chan []byte]struct{}
+	stop        chan struct{}
+}
+
+func NewHub(player Player, cache Cache, interval time.Duration, logger *logging.Logger) *Hub {
+	return &Hub{
+		player:   player,
+		cache:    cache,
+		interval: interval,
+		logger:   logger,
+		pollers:  map[string]*poller{},
+	}
+}
+
+func channelKey(userID string) string {
+	return "user:" + userID + ":now-playing"
+}
+
+func lastKey(userID string) string {
+	return "user:" + userID + ":now-playing:last"
+}
+
+func lockKey(userID string) string {
+	return "user:" + userID + ":now-playing:lock"
+}
+
+// Subscribe - register connection for user's now playing updates, last known state is sent first.
+// returned func must be called when connection is closed
+func (hub *Hub) Subscribe(userID string) (<-chan []byte, func()) {
+	updates := make(chan []byte, 4)
+	if last, err := hub.cache.Get(lastKey(userID)); err == nil {
+		if payload, ok := last.(string); ok {
+			updates <- []byte(payload)
+		}
+	}
+
+	hub.mutex.Lock()
+	p, ok := hub.pollers[userID]
+	if !ok {
+		p = &poller{
+			userID:      userID,
+			token:       shortuuid.New(),
+			subscribers: map[chan []byte]struct{}{},
+			stop:        make(chan struct{}),
+		}
+		hub.pollers[userID] = p
+		go hub.run(p)
+	}
+	p.subscribers[updates] = struct{}{}
+	hub.mutex.Unlock()
+
+	return updates, func() {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		delete(p.subscribers, updates)
+		// stop poller when the last connection of the user is gone
+		if len(p.subscribers) == 0 && hub.pollers[userID] == p {
+			delete(hub.pollers, userID)
+			close(p.stop)
+		}
+	}
+}
+
+// forward payload to local subscribers, slow connections drop their oldest update
+func (hub *Hub) broadcast(p *poller, payload []byte) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for subscriber := range p.subscribers {
+		select {
+		case subscriber <- payload:
+		default:
+			select {
+			case <-subscriber:
+			default:
+			}
+			subscriber <- payload
+		}
+	}
+}
+
+func (hub *Hub) run(p *poller) {
+	messages, closeSubscription := hub.cache.Subscribe(channelKey(p.userID))
+	go func() {
+		for message := range messages {
+			hub.broadcast(p, []byte(message))
+		}
+	}()
+
+	ticker := time.NewTicker(hub.interval)
+	defer ticker.Stop()
+	for {
+		if hub.holdLock(p) {
+			hub.poll(p.userID)
+		}
+		select {
+		case <-p.stop:
+			hub.releaseLock(p)
+			closeSubscription()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire polling lock of the user or extend it while the poller holds it, lock expires if
+// the holding replica goes away
+func (hub *Hub) holdLock(p *poller) bool {
+	ttl := hub.interval * 3
+	held, err := hub.cache.SetNX(lockKey(p.userID), p.token, ttl)
+	if err == nil && !held {
+		held, err = hub.cache.ExtendLock(lockKey(p.userID), p.token, ttl)
+	}
+	if err != nil {
+		hub.logger.Warn("now playing lock failed", "user_id", p.userID, "error", err)
+		return false
+	}
+	return held
+}
+
+func (hub *Hub) releaseLock(p *poller) {
+	if err := hub.cache.ReleaseLock(lockKey(p.userID), p.token); err != nil {
+		hub.logger.Warn("now playing lock not released", "user_id", p.userID, "error", err)
+	}
+}
+
+// fingerprint of playback state, progress is left out since clients interpolate it
+func fingerprint(payload []byte) string {
+	var state struct {
+		IsPlaying bool `json:"is_playing"`
+		Item      *struct {
+			ID string `json:"id"`
+		} `json:"item"`
+	}
+	json.Unmarshal(payload, &state)
+	if state.Item == nil {
+		return ""
+	}
+	if state.IsPlaying {
+		return state.Item.ID + ":playing"
+	}
+	return state.Item.ID + ":paused"
+}
+
+// poll spotify and publish state when it changed from the last published one
+func (hub *Hub) poll(userID string) {
+	resp, err := hub.player.GetCurrentlyPlaying(userID, "")
+	if err != nil {
+		hub.logger.Warn("now playing poll failed", "user_id", userID, "error", err)
+		return
+	}
+	payload := idlePayload
+	if resp != nil && len(*resp) > 0 {
+		payload = *resp
+	}
+	if last, err := hub.cache.Get(lastKey(userID)); err == nil {
+		if last, ok := last.(string); ok && fingerprint([]byte(last)) == fingerprint(payload) {
+			return
+		}
+	}
+	if err := hub.cache.Set(lastKey(userID), string(payload), time.Hour); err != nil {
+		hub.logger.Warn("now playing state not saved", "user_id", userID, "error", err)
+		return
+	}
+	if err := hub.cache.Publish(channelKey(userID), string(payload)); err != nil {
+		hub.logger.Warn("now playing not published", "user_id", userID, "error", err)
+	}
+}
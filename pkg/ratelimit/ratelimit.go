@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Bucket - token bucket holding up to Capacity tokens, refilled at Capacity per Window
+type Bucket struct {
+	Capacity float64
+	Window   time.Duration
+}
+
+// Rate - tokens added per second
+func (bucket Bucket) Rate() float64 {
+	return bucket.Capacity / bucket.Window.Seconds()
+}
+
+// Result - outcome of taking tokens from a bucket
+type Result struct {
+	Allowed   bool
+	Remaining float64
+	// RetryAfter - wait until the tokens asked for are available, zero when allowed
+	RetryAfter time.Duration
+	// ResetAfter - wait until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Store - shared buckets, taking tokens is atomic across replicas. Reserve tokens are left
+// in the bucket for takers of higher priority
+type Store interface {
+	TakeTokens(key string, bucket Bucket, tokens float64, reserve float64) (Result, error)
+}
+
+// Limit - requests allowed in window
+type Limit struct {
+	Limit         int `json:"limit"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+func (limit Limit) bucket() Bucket {
+	return Bucket{Capacity: float64(limit.Limit), Window: time.Duration(limit.WindowSeconds) * time.Second}
+}
+
+func (limit Limit) valid() bool {
+	return limit.Limit > 0 && limit.WindowSeconds > 0
+}
+
+// Config - limit of every route template not listed in Routes and limits of listed ones,
+// routes without their own limit share the default bucket of a client
+type Config struct {
+	// TrustForwardedFor - key anonymous clients by first address of X-Forwarded-For,
+	// only safe behind a proxy that sets it
+	TrustForwardedFor bool             `json:"trust_forwarded_for"`
+	Default           Limit            `json:"default"`
+	Routes            map[string]Limit `json:"routes"`
+}
+
+var defaultConfig = Config{
+	Default: Limit{Limit: 120, WindowSeconds: 60},
+	Routes:  map[string]Limit{},
+}
+
+// LoadConfig - config of RATE_LIMITS_FILE or config/rate-limits.json, defaults when there is none
+func LoadConfig() (Config, error) {
+	path := os.Getenv("RATE_LIMITS_FILE")
+	if path == "" {
+		path = "config/rate-limits.json"
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return defaultConfig, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	config := defaultConfig
+	// routes are decoded into a map of their own, the one of defaultConfig is shared
+	config.Routes = map[string]Limit{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return Config{}, err
+	}
+	if !config.Default.valid() {
+		config.Default = defaultConfig.Default
+	}
+	return config, nil
+}
+
+// Limiter - per route limits of clients kept in store
+type Limiter struct {
+	store  Store
+	config Config
+}
+
+func NewLimiter(store Store, config Config) *Limiter {
+	return &Limiter{store: store, config: config}
+}
+
+// TrustForwardedFor - whether clients are identified by X-Forwarded-For
+func (limiter *Limiter) TrustForwardedFor() bool {
+	return limiter.config.TrustForwardedFor
+}
+
+// Take - take a request of client from bucket of route, returned limit is the one applied
+func (limiter *Limiter) Take(route string, client string) (Limit, Result, error) {
+	name := route
+	limit, ok := limiter.config.Routes[route]
+	if !ok || !limit.valid() {
+		name = "default"
+		limit = limiter.config.Default
+	}
+	result, err := limiter.store.TakeTokens("ratelimit:"+name+":"+client, limit.bucket(), 1, 0)
+	return limit, result, err
+}
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimitValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		valid bool
+	}{
+		{"limit and window", Limit{Limit: 10, WindowSeconds: 60}, true},
+		{"zero value", Limit{}, false},
+		{"no window", Limit{Limit: 10}, false},
+		{"no limit", Limit{WindowSeconds: 60}, false},
+		{"negative limit", Limit{Limit: -1, WindowSeconds: 60}, false},
+		{"negative window", Limit{Limit: 10, WindowSeconds: -60}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := test.limit.valid(); valid != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, valid)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	previous, set := os.LookupEnv("RATE_LIMITS_FILE")
+	defer func() {
+		if set {
+			os.Setenv("RATE_LIMITS_FILE", previous)
+		} else {
+			os.Unsetenv("RATE_LIMITS_FILE")
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "missing file",
+			want: defaultConfig,
+		},
+		{
+			name:    "routes keep default",
+			content: `{"routes": {"/api/v1/spotify/search": {"limit": 5, "window_seconds": 10}}}`,
+			want: Config{
+				Default: defaultConfig.Default,
+				Routes:  map[string]Limit{"/api/v1/spotify/search": {Limit: 5, WindowSeconds: 10}},
+			},
+		},
+		{
+			name:    "own default",
+			content: `{"trust_forwarded_for": true, "default": {"limit": 30, "window_seconds": 1}}`,
+			want:    Config{TrustForwardedFor: true, Default: Limit{Limit: 30, WindowSeconds: 1}, Routes: map[string]Limit{}},
+		},
+		{
+			name:    "invalid default",
+			content: `{"default": {"limit": 0, "window_seconds": 60}}`,
+			want:    Config{Default: defaultConfig.Default, Routes: map[string]Limit{}},
+		},
+		{
+			name:    "malformed",
+			content: `{"default": `,
+			wantErr: true,
+		},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.json")
+			if test.content != "" {
+				path = filepath.Join(dir, "rate-limits-"+string(rune('a'+i))+".json")
+				if err := ioutil.WriteFile(path, []byte(test.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			os.Setenv("RATE_LIMITS_FILE", path)
+			config, err := LoadConfig()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.TrustForwardedFor != test.want.TrustForwardedFor || config.Default != test.want.Default {
+				t.Errorf("expected %+v, got %+v", test.want, config)
+			}
+			if len(config.Routes) != len(test.want.Routes) {
+				t.Fatalf("expected routes %v, got %v", test.want.Routes, config.Routes)
+			}
+			for route, limit := range test.want.Routes {
+				if config.Routes[route] != limit {
+					t.Errorf("route %s: expected %+v, got %+v", route, limit, config.Routes[route])
+				}
+			}
+		})
+	}
+}
+
+// recordingStore - store remembering what was taken last
+type recordingStore struct {
+	key    string
+	bucket Bucket
+	tokens float64
+}
+
+func (store *recordingStore) TakeTokens(key string, bucket Bucket, tokens float64, reserve float64) (Result, error) {
+	store.key = key
+	store.bucket = bucket
+	store.tokens = tokens
+	return Result{Allowed: true, Remaining: bucket.Capacity - tokens}, nil
+}
+
+func TestTake(t *testing.T) {
+	config := Config{
+		Default: Limit{Limit: 120, WindowSeconds: 60},
+		Routes: map[string]Limit{
+			"/api/v1/spotify/search": {Limit: 10, WindowSeconds: 5},
+			"/api/v1/export":         {Limit: 0, WindowSeconds: 60},
+		},
+	}
+	tests := []struct {
+		name      string
+		route     string
+		wantKey   string
+		wantLimit Limit
+	}{
+		{"own limit", "/api/v1/spotify/search", "ratelimit:/api/v1/spotify/search:user:1", Limit{Limit: 10, WindowSeconds: 5}},
+		{"unlisted route", "/api/v1/me", "ratelimit:default:user:1", config.Default},
+		{"invalid route limit", "/api/v1/export", "ratelimit:default:user:1", config.Default},
+		{"unmatched route", "", "ratelimit:default:user:1", config.Default},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &recordingStore{}
+			limit, result, err := NewLimiter(store, config).Take(test.route, "user:1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limit != test.wantLimit {
+				t.Errorf("expected limit %+v, got %+v", test.wantLimit, limit)
+			}
+			if store.key != test.wantKey {
+				t.Errorf("expected key %s, got %s", test.wantKey, store.key)
+			}
+			if store.tokens != 1 || !result.Allowed {
+				t.Errorf("expected a single token taken, got %v", store.tokens)
+			}
+			want := Bucket{Capacity: float64(test.wantLimit.Limit), Window: time.Duration(test.wantLimit.WindowSeconds) * time.Second}
+			if store.bucket != want {
+				t.Errorf("expected bucket %+v, got %+v", want, store.bucket)
+			}
+		})
+	}
+}
+
+func TestBucketRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket Bucket
+		rate   float64
+	}{
+		{"per minute", Bucket{Capacity: 120, Window: time.Minute}, 2},
+		{"per second", Bucket{Capacity: 10, Window: time.Second}, 10},
+		{"slower than a token a second", Bucket{Capacity: 1, Window: 4 * time.Second}, 0.25},
+		{"sub second window", Bucket{Capacity: 5, Window: 500 * time.Millisecond}, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rate := test.bucket.Rate(); rate != test.rate {
+				t.Errorf("expected rate %v, got %v", test.rate, rate)
+			}
+		})
+	}
+}
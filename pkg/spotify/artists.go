@@ -0,0 +1,319 @@
+package spotify
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"time"
+)
+
+// kinds of catalog items holding links to other items
+const (
+	CatalogArtist = "artist"
+	CatalogAlbum  = "album"
+)
+
+// graph stops growing once it has this many artists
+const maxGraphNodes = 250
+
+func artistIDs(artists []Artist) []string {
+	ids := make([]string, 0, len(artists))
+	for _, artist := range artists {
+		if artist.ID != "" {
+			ids = append(ids, artist.ID)
+		}
+	}
+	return ids
+}
+
+func trackIDs(tracks []Track) []string {
+	ids := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		if track.ID != "" {
+			ids = append(ids, track.ID)
+		}
+	}
+	return ids
+}
+
+// market of request, country of user's profile when it isn't given
+func (service *Service) marketOf(userID string, market string) (string, error) {
+	if market != "" {
+		return market, nil
+	}
+	profile, err := service.storage.GetProfile(userID)
+	if err != nil {
+		return "", err
+	}
+	if profile == nil || profile.Country == "" {
+		return "US", nil
+	}
+	return profile.Country, nil
+}
+
+// linkedIDs - ids linked to a catalog item, fetched from spotify when missing or stale and stored back
+func (service *Service) linkedIDs(kind string, id string, field string, fetch func() ([]string, error)) ([]string, error) {
+	ids, err := service.storage.GetCatalogLinks(kind, id, field, time.Now().Add(-catalogMaxAge()))
+	if err != nil {
+		return nil, err
+	}
+	if ids != nil {
+		return ids, nil
+	}
+	ids, err = fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := service.storage.SetCatalogLinks(kind, id, field, ids); err != nil {
+		service.logger.Warn("catalog links not saved", "error", err)
+	}
+	return ids, nil
+}
+
+func (service *Service) relatedArtistIDs(userID string, id string) ([]string, error) {
+	return service.linkedIDs(CatalogArtist, id, "related_artists", func() ([]string, error) {
+		resp, err := service.callSpotify(userID, "GET", os.Getenv("SPOTIFY_ARTISTS")+"/"+url.PathEscape(id)+"/related-artists", nil)
+		if err != nil {
+			return nil, err
+		}
+		var container struct {
+			Artists []Artist `json:"artists"`
+		}
+		if err := json.Unmarshal(*resp, &container); err != nil {
+			return nil, err
+		}
+		service.catalog(nil, container.Artists, nil)
+		return artistIDs(container.Artists), nil
+	})
+}
+
+func (service *Service) artistTopTrackIDs(userID string, id string, market string) ([]string, error) {
+	return service.linkedIDs(CatalogArtist, id, "top_tracks_"+market, func() ([]string, error) {
+		resp, err := service.callSpotify(userID, "GET",
+			os.Getenv("SPOTIFY_ARTISTS")+"/"+url.PathEscape(id)+"/top-tracks?market="+url.QueryEscape(market), nil)
+		if err != nil {
+			return nil, err
+		}
+		var container struct {
+			Tracks []Track `json:"tracks"`
+		}
+		if err := json.Unmarshal(*resp, &container); err != nil {
+			return nil, err
+		}
+		service.catalog(container.Tracks, nil, nil)
+		return trackIDs(container.Tracks), nil
+	})
+}
+
+func (service *Service) artistAlbumIDs(userID string, id string, market string) ([]string, error) {
+	return service.linkedIDs(CatalogArtist, id, "albums_"+market, func() ([]string, error) {
+		resp, err := service.callSpotify(userID, "GET",
+			os.Getenv("SPOTIFY_ARTISTS")+"/"+url.PathEscape(id)+"/albums?include_groups=album,single&limit=50&market="+url.QueryEscape(market), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page AlbumPage
+		if err := json.Unmarshal(*resp, &page); err != nil {
+			return nil, err
+		}
+		service.catalog(nil, nil, page.Items)
+		ids := make([]string, 0, len(page.Items))
+		for _, album := range page.Items {
+			ids = append(ids, album.ID)
+		}
+		return ids, nil
+	})
+}
+
+// page through album tracks
+func (service *Service) albumTrackIDs(userID string, id string) ([]string, error) {
+	return service.linkedIDs(CatalogAlbum, id, "tracks", func() ([]string, error) {
+		ids := []string{}
+		URL := os.Getenv("SPOTIFY_ALBUMS") + "/" + url.PathEscape(id) + "/tracks?limit=50"
+		for URL != "" {
+			resp, err := service.callSpotify(userID, "GET", URL, nil)
+			if err != nil {
+				return nil, err
+			}
+			var page TrackPage
+			if err := json.Unmarshal(*resp, &page); err != nil {
+				return nil, err
+			}
+			ids = append(ids, trackIDs(page.Items)...)
+			URL = page.Next
+		}
+		return ids, nil
+	})
+}
+
+// GetArtistDetail - artist with top tracks, albums and related artists
+func (service *Service) GetArtistDetail(userID string, id string, market string) (*ArtistDetail, error) {
+	artist, err := service.GetArtist(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	market, err = service.marketOf(userID, market)
+	if err != nil {
+		return nil, err
+	}
+	topTrackIDs, err := service.artistTopTrackIDs(userID, id, market)
+	if err != nil {
+		return nil, err
+	}
+	topTracks, err := service.GetTracks(userID, topTrackIDs)
+	if err != nil {
+		return nil, err
+	}
+	albumIDs, err := service.artistAlbumIDs(userID, id, market)
+	if err != nil {
+		return nil, err
+	}
+	// simplified albums stored by artist albums lookup are enough here
+	albums, err := service.storage.GetAlbums(albumIDs, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	relatedIDs, err := service.relatedArtistIDs(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	related, err := service.GetArtists(userID, relatedIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &ArtistDetail{
+		Artist:         *artist,
+		TopTracks:      topTracks,
+		Albums:         albums,
+		RelatedArtists: related,
+	}, nil
+}
+
+// GetAlbumDetail - album with its tracks and their audio features
+func (service *Service) GetAlbumDetail(userID string, id string) (*AlbumDetail, error) {
+	album, err := service.GetAlbum(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := service.albumTrackIDs(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := service.GetTracks(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	audioFeatures := []AudioFeatures{}
+	for start := 0; start < len(ids); start += 100 {
+		end := start + 100
+		if end > len(ids) {
+			end = len(ids)
+		}
+		resp, err := service.GetTracksAudioFeatures(userID, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		var container struct {
+			AudioFeatures []*AudioFeatures `json:"audio_features"`
+		}
+		if err := json.Unmarshal(*resp, &container); err != nil {
+			return nil, err
+		}
+		for _, features := range container.AudioFeatures {
+			if features != nil {
+				audioFeatures = append(audioFeatures, *features)
+			}
+		}
+	}
+	return &AlbumDetail{
+		Album:         *album,
+		Tracks:        tracks,
+		AudioFeatures: audioFeatures,
+	}, nil
+}
+
+func artistNode(artist Artist, depth int) ArtistNode {
+	node := ArtistNode{
+		ID:         artist.ID,
+		Name:       artist.Name,
+		Genres:     artist.Genres,
+		Popularity: artist.Popularity,
+		Depth:      depth,
+		Seed:       depth == 0,
+	}
+	if node.Genres == nil {
+		node.Genres = []string{}
+	}
+	if len(artist.Images) > 0 {
+		node.Image = artist.Images[0].URL
+	}
+	return node
+}
+
+// GetRelatedArtistGraph - walk related artists up to hops away from user's top artists.
+// every artist expands to at most fanout related artists and the graph is capped at maxGraphNodes
+func (service *Service) GetRelatedArtistGraph(userID string, timeRange string, seeds int, hops int, fanout int) (*ArtistGraph, error) {
+	if seeds < 1 || seeds > 50 {
+		return nil, newArgumentError("seeds must be between 1 and 50")
+	}
+	if hops < 1 || hops > 3 {
+		return nil, newArgumentError("hops must be between 1 and 3")
+	}
+	if fanout < 1 || fanout > 20 {
+		return nil, newArgumentError("fanout must be between 1 and 20")
+	}
+	top, err := service.getTopArtists(userID, timeRange)
+	if err != nil {
+		return nil, err
+	}
+	if len(top) > seeds {
+		top = top[:seeds]
+	}
+
+	graph := &ArtistGraph{Nodes: []ArtistNode{}, Edges: []ArtistEdge{}}
+	nodes := map[string]bool{}
+	edges := map[string]bool{}
+	frontier := []string{}
+	for _, artist := range top {
+		nodes[artist.ID] = true
+		graph.Nodes = append(graph.Nodes, artistNode(artist, 0))
+		frontier = append(frontier, artist.ID)
+	}
+	for depth := 1; depth <= hops && len(frontier) > 0; depth++ {
+		next := []string{}
+		for _, id := range frontier {
+			relatedIDs, err := service.relatedArtistIDs(userID, id)
+			if err != nil {
+				return nil, err
+			}
+			if len(relatedIDs) > fanout {
+				relatedIDs = relatedIDs[:fanout]
+			}
+			related, err := service.GetArtists(userID, relatedIDs)
+			if err != nil {
+				return nil, err
+			}
+			for _, artist := range related {
+				if !nodes[artist.ID] {
+					if len(nodes) >= maxGraphNodes {
+						continue
+					}
+					nodes[artist.ID] = true
+					graph.Nodes = append(graph.Nodes, artistNode(artist, depth))
+					next = append(next, artist.ID)
+				}
+				// edges are undirected, related artists usually point back to each other
+				key := id + ":" + artist.ID
+				if artist.ID < id {
+					key = artist.ID + ":" + id
+				}
+				if !edges[key] {
+					edges[key] = true
+					graph.Edges = append(graph.Edges, ArtistEdge{Source: id, Target: artist.ID})
+				}
+			}
+		}
+		frontier = next
+	}
+	return graph, nil
+}
@@ -5,21 +5,22 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
-
-	"github.com/golang-jwt/jwt/v4"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/tracing"
 )
 
 // Login - login logic
-func (service *Service) Login(email string) (*Profile, error) {
-	profile, profileErr := service.storage.GetProfileWithEmail(email)
+func (service *Service) Login(userID string) (*Profile, error) {
+	profile, profileErr := service.storage.GetProfile(userID)
 	return profile, profileErr
 }
 
 func (service *Service) GetCredentials(authorizationCode string) (*Credentials, error) {
 	secretToken := base64.StdEncoding.EncodeToString([]byte(os.Getenv("CLIENT_ID") + ":" + os.Getenv("CLIENT_SECRET")))
-	resp, err := service.httpClient.Request(
+	resp, err := service.request(
 		"POST",
 		os.Getenv("SPOTIFY_TOKEN_GENERATOR_ENTPOINT"),
 		map[string]interface{}{
@@ -45,7 +46,7 @@ func (service *Service) GetCredentials(authorizationCode string) (*Credentials,
 }
 
 func (service *Service) GetProfileFromSpotify(accessToken string) (*Profile, error) {
-	profileResp, err := service.httpClient.Request(
+	profileResp, err := service.request(
 		"GET",
 		os.Getenv("SPOTIFY_PROFILE_URL"), nil,
 		"application/json",
@@ -67,13 +68,16 @@ func (service *Service) GetProfileFromSpotify(accessToken string) (*Profile, err
 	return &profile, err
 }
 
-// AuthCallback - callback function when spotify hit the  authorization endpoint
-func (service *Service) AuthCallback(authorizationCode string) (*LoginResponse, error) {
+// FetchProfile - exchange authorization code of spotify callback for credentials and get the
+// profile of the account with them. Nothing is stored until the account is linked, see SaveProfile
+func (service *Service) FetchProfile(authorizationCode string) (*Profile, error) {
 	credentials, err := service.GetCredentials(authorizationCode)
-
 	if err != nil {
 		return nil, err
 	}
+	if credentials.AccessToken == "" {
+		return nil, errors.New("spotify didn't issue an access token")
+	}
 
 	profile, err := service.GetProfileFromSpotify(credentials.AccessToken)
 	if err != nil {
@@ -81,34 +85,31 @@ func (service *Service) AuthCallback(authorizationCode string) (*LoginResponse,
 	}
 
 	profile.Credentials = *credentials
-	profileArtifact, createError := service.storage.CreateOrUpdateProfile(*profile)
-	if createError != nil {
-		return nil, createError
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, CustomClaims{
-		profile.Email,
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 1)),
-		},
-	})
-	tokenString, signedErr := token.SignedString([]byte(os.Getenv("SECRET")))
+	return profile, nil
+}
 
-	if err != nil {
-		return nil, signedErr
-	}
-	respose := LoginResponse{
-		Profile: profileArtifact,
-		Token:   tokenString,
-	}
-	return &respose, nil
+// SaveProfile - store profile fetched with FetchProfile with its credentials as the profile of
+// user, after user linked the account or logged in again with it
+func (service *Service) SaveProfile(profile Profile, userID string) error {
+	service.cache.Clear(reauthNoticeKey(userID))
+	profile.UserID = userID
+	_, err := service.storage.CreateOrUpdateProfile(profile)
+	return err
+}
+
+// DisconnectProfile - forget spotify profile and credentials of user after the account was unlinked
+func (service *Service) DisconnectProfile(userID string) error {
+	return service.storage.DeleteProfile(userID)
 }
 
 // GetValidToken - return credentials with valid token meaning if token is expred, token will be refreshed
-func (service *Service) GetValidToken(email string) (*Credentials, error) {
-	if email == "" {
-		return nil, errors.New("email expected")
+func (service *Service) GetValidToken(userID string) (*Credentials, error) {
+	service, span := service.span("GetValidToken", tracing.UserID(userID))
+	defer span.End()
+	if userID == "" {
+		return nil, errors.New("user id expected")
 	}
-	profile, err := service.storage.GetProfileWithEmail(email)
+	profile, err := service.storage.GetProfile(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -118,10 +119,19 @@ func (service *Service) GetValidToken(email string) (*Credentials, error) {
 	remaingTokenTime := 3600 - time.Since(profile.Credentials.UpdatedAt).Seconds()
 	if remaingTokenTime <= 10 {
 		refreshCredentials, err := service.RefreshToken(profile.Credentials.RefreshToken)
+		switch {
+		case errors.Is(err, ErrReauthRequired):
+			metrics.TokenRefreshes.WithLabelValues("reauth_required").Inc()
+			service.reauthRequired(userID)
+		case err != nil:
+			metrics.TokenRefreshes.WithLabelValues("error").Inc()
+		default:
+			metrics.TokenRefreshes.WithLabelValues("success").Inc()
+		}
 		if err != nil {
 			return nil, err
 		}
-		_, updateErr := service.storage.UpdateCredentials(email, refreshCredentials)
+		_, updateErr := service.storage.UpdateCredentials(userID, refreshCredentials)
 		if updateErr != nil {
 			return nil, updateErr
 		}
@@ -131,8 +141,10 @@ func (service *Service) GetValidToken(email string) (*Credentials, error) {
 }
 
 func (service *Service) RefreshToken(refreshToken string) (*Credentials, error) {
+	service, span := service.span("RefreshToken")
+	defer span.End()
 	secretToken := base64.StdEncoding.EncodeToString([]byte(os.Getenv("CLIENT_ID") + ":" + os.Getenv("CLIENT_SECRET")))
-	profileResp, err := service.httpClient.Request(
+	profileResp, err := service.request(
 		"POST",
 		os.Getenv("SPOTIFY_TOKEN_GENERATOR_ENTPOINT"),
 		map[string]interface{}{
@@ -152,6 +164,17 @@ func (service *Service) RefreshToken(refreshToken string) (*Credentials, error)
 	if err != nil {
 		return nil, err
 	}
+	if profileResp.StatusCode >= http.StatusBadRequest {
+		var tokenErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(refreshTokenResp, &tokenErr)
+		if tokenErr.Error == "invalid_grant" {
+			return nil, ErrReauthRequired
+		}
+		return nil, &APIError{Status: profileResp.StatusCode, Message: tokenErr.Description}
+	}
 
 	var refreshTokenPayload Credentials
 	json.Unmarshal(refreshTokenResp, &refreshTokenPayload)
@@ -0,0 +1,56 @@
+package spotify
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"utilserver/pkg/breaker"
+)
+
+// breakers of spotify endpoint groups, an outage of one api doesn't stop calls to the others
+var endpointBreakers *breaker.Group
+var loadEndpointBreakers sync.Once
+
+func getEndpointBreakers() *breaker.Group {
+	loadEndpointBreakers.Do(func() {
+		endpointBreakers = breaker.NewGroupFromEnv()
+	})
+	return endpointBreakers
+}
+
+// endpointGroup - breaker name of url: spotify:accounts for token calls, spotify:me/<resource>
+// for calls about the user and spotify:<resource> for the rest of the web api
+func endpointGroup(URL string) string {
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return "spotify"
+	}
+	if strings.HasPrefix(parsed.Host, "accounts.") {
+		return "spotify:accounts"
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) > 0 && segments[0] == "v1" {
+		segments = segments[1:]
+	}
+	if len(segments) == 0 || segments[0] == "" {
+		return "spotify"
+	}
+	if segments[0] == "me" && len(segments) > 1 {
+		return "spotify:me/" + segments[1]
+	}
+	return "spotify:" + segments[0]
+}
+
+// report outcome of call to breaker, only missing answers and server errors count as failures.
+// calls given up by the caller say nothing about spotify
+func (service *Service) reportOutcome(endpoint *breaker.Breaker, resp *http.Response, err error) {
+	switch {
+	case err != nil && service.context().Err() != nil:
+		return
+	case err != nil, resp.StatusCode >= http.StatusInternalServerError:
+		endpoint.Failure()
+	default:
+		endpoint.Success()
+	}
+}
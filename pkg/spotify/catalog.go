@@ -0,0 +1,500 @@
+package spotify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"utilserver/pkg/breaker"
+)
+
+// ErrNotFound - requested item doesn't exist on spotify
+var ErrNotFound = errors.New("not found")
+
+// catalogMaxAge - how long catalog entries are served before they are fetched from spotify again
+func catalogMaxAge() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("CATALOG_MAX_AGE_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 24 * 7
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// catalog - save every track, artist and album seen in spotify responses to local catalog,
+// artists and albums nested in tracks are saved as well. Entries this replica wrote recently
+// are skipped, so reads like the now playing poll don't write on every call. failures don't
+// fail the request
+func (service *Service) catalog(tracks []Track, artists []Artist, albums []Album) {
+	for _, track := range tracks {
+		artists = append(artists, track.Artists...)
+		if track.Album != nil {
+			albums = append(albums, *track.Album)
+		}
+	}
+	for _, album := range albums {
+		artists = append(artists, album.Artists...)
+	}
+	tracks, trackKeys := dueTracks(uniqueTracks(tracks))
+	if err := service.storage.UpsertTracks(tracks); err != nil {
+		recentlyCataloged.forget(trackKeys)
+		service.logger.Warn("catalog tracks not saved", "error", err)
+	}
+	albums, albumKeys := dueAlbums(uniqueAlbums(albums))
+	if err := service.storage.UpsertAlbums(albums); err != nil {
+		recentlyCataloged.forget(albumKeys)
+		service.logger.Warn("catalog albums not saved", "error", err)
+	}
+	artists, artistKeys := dueArtists(uniqueArtists(artists))
+	if err := service.storage.UpsertArtists(artists); err != nil {
+		recentlyCataloged.forget(artistKeys)
+		service.logger.Warn("catalog artists not saved", "error", err)
+	}
+}
+
+// catalogMemo - entries written to the catalog by this replica and when
+type catalogMemo struct {
+	mutex   sync.Mutex
+	written map[string]catalogWrite
+}
+
+type catalogWrite struct {
+	at       time.Time
+	complete bool
+}
+
+// entries are remembered for at most this many keys, expired ones are dropped beyond it
+const catalogMemoSize = 50000
+
+var recentlyCataloged = &catalogMemo{written: map[string]catalogWrite{}}
+
+// claim - whether entry of key must be written, it's remembered as written right away. Entries
+// are written again once half of catalogMaxAge passed, so they never turn stale for readers,
+// or when a complete object replaces a simplified one
+func (memo *catalogMemo) claim(key string, complete bool) bool {
+	now := time.Now()
+	refresh := catalogMaxAge() / 2
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+	if written, ok := memo.written[key]; ok && now.Sub(written.at) < refresh && (written.complete || !complete) {
+		return false
+	}
+	if len(memo.written) >= catalogMemoSize {
+		for key, written := range memo.written {
+			if now.Sub(written.at) >= refresh {
+				delete(memo.written, key)
+			}
+		}
+	}
+	memo.written[key] = catalogWrite{at: now, complete: complete}
+	return true
+}
+
+// forget - entries of keys whose write failed, they're written with the next response again
+func (memo *catalogMemo) forget(keys []string) {
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+	for _, key := range keys {
+		delete(memo.written, key)
+	}
+}
+
+// entries which must be written with their keys in the memo
+func dueArtists(artists []Artist) ([]Artist, []string) {
+	due := []Artist{}
+	keys := []string{}
+	for _, artist := range artists {
+		if key := "artist:" + artist.ID; recentlyCataloged.claim(key, artist.Complete()) {
+			due = append(due, artist)
+			keys = append(keys, key)
+		}
+	}
+	return due, keys
+}
+
+func dueAlbums(albums []Album) ([]Album, []string) {
+	due := []Album{}
+	keys := []string{}
+	for _, album := range albums {
+		if key := "album:" + album.ID; recentlyCataloged.claim(key, album.Complete()) {
+			due = append(due, album)
+			keys = append(keys, key)
+		}
+	}
+	return due, keys
+}
+
+func dueTracks(tracks []Track) ([]Track, []string) {
+	due := []Track{}
+	keys := []string{}
+	for _, track := range tracks {
+		if key := "track:" + track.ID; recentlyCataloged.claim(key, track.Complete()) {
+			due = append(due, track)
+			keys = append(keys, key)
+		}
+	}
+	return due, keys
+}
+
+// unique items by id, complete objects win over simplified ones
+func uniqueArtists(artists []Artist) []Artist {
+	index := map[string]int{}
+	unique := []Artist{}
+	for _, artist := range artists {
+		if i, ok := index[artist.ID]; ok {
+			if !unique[i].Complete() && artist.Complete() {
+				unique[i] = artist
+			}
+			continue
+		}
+		index[artist.ID] = len(unique)
+		unique = append(unique, artist)
+	}
+	return unique
+}
+
+func uniqueAlbums(albums []Album) []Album {
+	index := map[string]int{}
+	unique := []Album{}
+	for _, album := range albums {
+		if i, ok := index[album.ID]; ok {
+			if !unique[i].Complete() && album.Complete() {
+				unique[i] = album
+			}
+			continue
+		}
+		index[album.ID] = len(unique)
+		unique = append(unique, album)
+	}
+	return unique
+}
+
+func uniqueTracks(tracks []Track) []Track {
+	index := map[string]int{}
+	unique := []Track{}
+	for _, track := range tracks {
+		if i, ok := index[track.ID]; ok {
+			if !unique[i].Complete() && track.Complete() {
+				unique[i] = track
+			}
+			continue
+		}
+		index[track.ID] = len(unique)
+		unique = append(unique, track)
+	}
+	return unique
+}
+
+// catalog items of top artists or tracks response
+func (service *Service) catalogTopItems(topType string, body []byte) {
+	if topType == "artists" {
+		var container struct {
+			Items []Artist `json:"items"`
+		}
+		if json.Unmarshal(body, &container) == nil {
+			service.catalog(nil, container.Items, nil)
+		}
+		return
+	}
+	var container struct {
+		Items []Track `json:"items"`
+	}
+	if json.Unmarshal(body, &container) == nil {
+		service.catalog(container.Items, nil, nil)
+	}
+}
+
+// catalog tracks of recently played response
+func (service *Service) catalogRecentlyPlayed(body []byte) {
+	var container struct {
+		Items []struct {
+			Track Track `json:"track"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(body, &container) != nil {
+		return
+	}
+	tracks := make([]Track, 0, len(container.Items))
+	for _, item := range container.Items {
+		tracks = append(tracks, item.Track)
+	}
+	service.catalog(tracks, nil, nil)
+}
+
+// catalog item of playback state or currently playing response, episodes are skipped
+func (service *Service) catalogPlayback(body []byte) {
+	var container struct {
+		CurrentlyPlayingType string `json:"currently_playing_type"`
+		Item                 *Track `json:"item"`
+	}
+	if json.Unmarshal(body, &container) != nil || container.Item == nil || container.CurrentlyPlayingType != "track" {
+		return
+	}
+	service.catalog([]Track{*container.Item}, nil, nil)
+}
+
+// ids of the list which aren't found yet
+func missingIDs(ids []string, found func(id string) bool) []string {
+	missing := []string{}
+	for _, id := range ids {
+		if id != "" && !found(id) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// request spotify several-items endpoint in chunks of ids, handle is called with every response body
+func (service *Service) fetchSeveral(userID string, URL string, ids []string, chunkSize int, handle func(body []byte) error) error {
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		resp, err := service.callSpotify(userID, "GET", URL+"?ids="+url.QueryEscape(strings.Join(ids[start:end], ",")), nil)
+		if err != nil {
+			return err
+		}
+		if err := handle(*resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveStale - outdated entries of missing ids are loaded with loadStale when spotify is down,
+// err is cleared when the catalog has every one of them so they are served
+func (service *Service) serveStale(kind string, missing []string, err error, has func(id string) bool, loadStale func(ids []string) error) error {
+	if !errors.Is(err, breaker.ErrUnavailable) {
+		return err
+	}
+	if staleErr := loadStale(missing); staleErr != nil || len(missingIDs(missing, has)) > 0 {
+		return err
+	}
+	service.logger.Warn("spotify unavailable, serving stale catalog", "kind", kind, "error", err)
+	return nil
+}
+
+// personal responses are kept this long to be served while spotify is unavailable
+const staleResponseTTL = 24 * time.Hour
+
+// personal responses are cached under user prefix so erasing the account clears them
+func staleResponseKey(userID string, URL string) string {
+	sum := sha256.Sum256([]byte(URL))
+	return "user:" + userID + ":stale:" + hex.EncodeToString(sum[:])
+}
+
+// callSpotifyStale - callSpotify for personal GET requests. Responses are remembered and the
+// last one is served while the endpoint group is unavailable
+func (service *Service) callSpotifyStale(userID string, URL string) (*[]byte, error) {
+	content, err := service.callSpotify(userID, "GET", URL, nil)
+	if err == nil {
+		if cacheErr := service.cache.Set(staleResponseKey(userID, URL), string(*content), staleResponseTTL); cacheErr != nil {
+			service.logger.Warn("spotify response not cached", "error", cacheErr)
+		}
+		return content, nil
+	}
+	if !errors.Is(err, breaker.ErrUnavailable) {
+		return nil, err
+	}
+	stale, found, cacheErr := service.cache.Lookup(staleResponseKey(userID, URL))
+	if cacheErr != nil || !found {
+		return nil, err
+	}
+	service.logger.Warn("spotify unavailable, serving stale response", "error", err)
+	staleContent := []byte(stale)
+	return &staleContent, nil
+}
+
+// GetArtists - artists by id from local catalog, missing and stale entries are fetched from spotify.
+// While spotify is unavailable stale entries are served
+func (service *Service) GetArtists(userID string, ids []string) ([]Artist, error) {
+	cached, err := service.storage.GetArtists(ids, time.Now().Add(-catalogMaxAge()))
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]Artist{}
+	for _, artist := range cached {
+		byID[artist.ID] = artist
+	}
+	has := func(id string) bool { _, ok := byID[id]; return ok }
+	missing := missingIDs(ids, has)
+	err = service.fetchSeveral(userID, os.Getenv("SPOTIFY_ARTISTS"), missing, 50, func(body []byte) error {
+		var container struct {
+			Artists []Artist `json:"artists"`
+		}
+		if err := json.Unmarshal(body, &container); err != nil {
+			return err
+		}
+		// unknown ids are returned as null
+		artists := uniqueArtists(container.Artists)
+		service.catalog(nil, artists, nil)
+		for _, artist := range artists {
+			if artist.ID != "" {
+				byID[artist.ID] = artist
+			}
+		}
+		return nil
+	})
+	err = service.serveStale("artist", missing, err, has, func(ids []string) error {
+		stale, err := service.storage.GetArtists(ids, time.Time{})
+		for _, artist := range stale {
+			if !has(artist.ID) {
+				byID[artist.ID] = artist
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	artists := make([]Artist, 0, len(ids))
+	for _, id := range ids {
+		if artist, ok := byID[id]; ok {
+			artists = append(artists, artist)
+		}
+	}
+	return artists, nil
+}
+
+// GetArtist - single artist by id from local catalog or spotify
+func (service *Service) GetArtist(userID string, id string) (*Artist, error) {
+	artists, err := service.GetArtists(userID, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(artists) == 0 {
+		return nil, fmt.Errorf("artist %s: %w", id, ErrNotFound)
+	}
+	return &artists[0], nil
+}
+
+// GetAlbums - albums by id from local catalog, missing and stale entries are fetched from spotify.
+// While spotify is unavailable stale entries are served
+func (service *Service) GetAlbums(userID string, ids []string) ([]Album, error) {
+	cached, err := service.storage.GetAlbums(ids, time.Now().Add(-catalogMaxAge()))
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]Album{}
+	for _, album := range cached {
+		byID[album.ID] = album
+	}
+	has := func(id string) bool { _, ok := byID[id]; return ok }
+	missing := missingIDs(ids, has)
+	err = service.fetchSeveral(userID, os.Getenv("SPOTIFY_ALBUMS"), missing, 20, func(body []byte) error {
+		var container struct {
+			Albums []Album `json:"albums"`
+		}
+		if err := json.Unmarshal(body, &container); err != nil {
+			return err
+		}
+		albums := uniqueAlbums(container.Albums)
+		service.catalog(nil, nil, albums)
+		for _, album := range albums {
+			if album.ID != "" {
+				byID[album.ID] = album
+			}
+		}
+		return nil
+	})
+	err = service.serveStale("album", missing, err, has, func(ids []string) error {
+		stale, err := service.storage.GetAlbums(ids, time.Time{})
+		for _, album := range stale {
+			if !has(album.ID) {
+				byID[album.ID] = album
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	albums := make([]Album, 0, len(ids))
+	for _, id := range ids {
+		if album, ok := byID[id]; ok {
+			albums = append(albums, album)
+		}
+	}
+	return albums, nil
+}
+
+// GetAlbum - single album by id from local catalog or spotify
+func (service *Service) GetAlbum(userID string, id string) (*Album, error) {
+	albums, err := service.GetAlbums(userID, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(albums) == 0 {
+		return nil, fmt.Errorf("album %s: %w", id, ErrNotFound)
+	}
+	return &albums[0], nil
+}
+
+// GetTracks - tracks by id from local catalog, missing and stale entries are fetched from spotify.
+// While spotify is unavailable stale entries are served
+func (service *Service) GetTracks(userID string, ids []string) ([]Track, error) {
+	cached, err := service.storage.GetTracks(ids, time.Now().Add(-catalogMaxAge()))
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]Track{}
+	for _, track := range cached {
+		byID[track.ID] = track
+	}
+	has := func(id string) bool { _, ok := byID[id]; return ok }
+	missing := missingIDs(ids, has)
+	err = service.fetchSeveral(userID, os.Getenv("SPOTIFY_TRACKS"), missing, 50, func(body []byte) error {
+		var container struct {
+			Tracks []Track `json:"tracks"`
+		}
+		if err := json.Unmarshal(body, &container); err != nil {
+			return err
+		}
+		tracks := uniqueTracks(container.Tracks)
+		service.catalog(tracks, nil, nil)
+		for _, track := range tracks {
+			if track.ID != "" {
+				byID[track.ID] = track
+			}
+		}
+		return nil
+	})
+	err = service.serveStale("track", missing, err, has, func(ids []string) error {
+		stale, err := service.storage.GetTracks(ids, time.Time{})
+		for _, track := range stale {
+			if !has(track.ID) {
+				byID[track.ID] = track
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(ids))
+	for _, id := range ids {
+		if track, ok := byID[id]; ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks, nil
+}
+
+// GetTrack - single track by id from local catalog or spotify
+func (service *Service) GetTrack(userID string, id string) (*Track, error) {
+	tracks, err := service.GetTracks(userID, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("track %s: %w", id, ErrNotFound)
+	}
+	return &tracks[0], nil
+}
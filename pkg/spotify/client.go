@@ -0,0 +1,153 @@
+package spotify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/metrics"
+)
+
+// APIError - error returned by spotify web api with its http status
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+	// RetryAfter - wait spotify asked for when it rate limited the call
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return "spotify: request failed with status " + strconv.Itoa(e.Status)
+	}
+	return "spotify: " + e.Message
+}
+
+// ArgumentError - invalid argument passed to service
+type ArgumentError struct {
+	Message string
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Message
+}
+
+func newArgumentError(message string) error {
+	return &ArgumentError{Message: message}
+}
+
+// callSpotify - request spotify web api on behalf of the user with a valid access token
+// and return raw response body, non 2xx responses are returned as *APIError
+func (service *Service) callSpotify(userID string, method string, URL string, body map[string]interface{}) (*[]byte, error) {
+	credentials, err := service.GetValidToken(userID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := service.request(
+		method,
+		URL,
+		body,
+		"application/json",
+		"Bearer "+credentials.AccessToken,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := newAPIError(resp.StatusCode, content)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			apiErr.RetryAfter = retryAfter(resp)
+		}
+		return nil, apiErr
+	}
+	return &content, nil
+}
+
+// rate limited calls are retried when spotify asks to wait no longer than maxRetryAfter
+const (
+	maxRateLimitRetries = 2
+	maxRetryAfter       = 5 * time.Second
+)
+
+// request - send request to spotify within quota of the app and log it with its status and
+// latency. Calls fail fast with *breaker.UnavailableError while the endpoint group is down.
+// Rate limited requests pause calls of every replica for the wait spotify asks for and are
+// retried after it when it's short
+func (service *Service) request(method string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error) {
+	endpoint := getEndpointBreakers().Get(endpointGroup(URL))
+	for retries := 0; ; retries++ {
+		// quota is taken first, a probe the breaker lets through is always sent and its outcome
+		// reported, so waiting for quota can't hold the breaker half open
+		if err := service.acquireQuota(); err != nil {
+			return nil, err
+		}
+		if err := endpoint.Allow(); err != nil {
+			service.logger.Warn("spotify request rejected, endpoint unavailable", "method", method, "url", logging.RedactURL(URL), "breaker", endpoint.Name())
+			return nil, err
+		}
+		started := time.Now()
+		resp, err := service.httpClient.RequestWithContext(service.context(), method, URL, body, contentType, auth)
+		latency := time.Since(started)
+		service.reportOutcome(endpoint, resp, err)
+		if err != nil {
+			metrics.ObserveSpotifyRequest(method, URL, 0, latency)
+			service.logger.Warn("spotify request failed", "method", method, "url", logging.RedactURL(URL), "latency_ms", latency.Milliseconds(), "error", err)
+			return nil, err
+		}
+		metrics.ObserveSpotifyRequest(method, URL, resp.StatusCode, latency)
+		service.logger.Info("spotify request", "method", method, "url", logging.RedactURL(URL), "status", resp.StatusCode, "latency_ms", latency.Milliseconds())
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		wait := retryAfter(resp)
+		service.pauseQuota(wait)
+		if retries >= maxRateLimitRetries || wait > maxRetryAfter {
+			return resp, nil
+		}
+		resp.Body.Close()
+		// waits out the pause when acquiring quota again
+		metrics.SpotifyRetries.WithLabelValues(metrics.SpotifyEndpoint(URL)).Inc()
+		service.logger.Warn("spotify rate limited, retrying", "url", logging.RedactURL(URL), "retry_after", wait)
+	}
+}
+
+// wait asked for by Retry-After header of response, a second when it's missing
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func newAPIError(status int, content []byte) *APIError {
+	var container struct {
+		Error APIError `json:"error"`
+	}
+	apiErr := &APIError{Status: status}
+	if json.Unmarshal(content, &container) == nil && container.Error.Message != "" {
+		apiErr.Message = container.Error.Message
+		apiErr.Reason = container.Error.Reason
+	}
+	return apiErr
+}
+
+// toBody - convert request payload struct to map accepted by http client
+func toBody(payload interface{}) (map[string]interface{}, error) {
+	byteArr, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	err = json.Unmarshal(byteArr, &body)
+	return body, err
+}
@@ -0,0 +1,139 @@
+package spotify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditErasure - action of audit record written after account erasure
+const AuditErasure = "account.erased"
+
+// revoked sessions have to be remembered longer than any token lives
+const revocationTTL = 24 * time.Hour
+
+// AuditRecord - entry of append only audit log. Every record carries hash of the previous
+// one so editing or removing a record breaks the chain from that point on
+type AuditRecord struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Sequence     int64              `bson:"sequence" json:"sequence"`
+	Action       string             `bson:"action" json:"action"`
+	Subject      string             `bson:"subject" json:"subject"`
+	Details      []string           `bson:"details" json:"details"`
+	At           time.Time          `bson:"at" json:"at"`
+	PreviousHash string             `bson:"previous_hash" json:"previous_hash"`
+	Hash         string             `bson:"hash" json:"hash"`
+}
+
+// ComputeHash - hash of record content chained to hash of previous record
+func (record AuditRecord) ComputeHash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		strconv.FormatInt(record.Sequence, 10),
+		record.Action,
+		record.Subject,
+		strings.Join(record.Details, ","),
+		// mongo keeps milliseconds only
+		record.At.UTC().Format("2006-01-02T15:04:05.000Z"),
+		record.PreviousHash,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain - check records ordered by sequence form an unbroken chain
+func VerifyAuditChain(records []AuditRecord) error {
+	for i, record := range records {
+		if record.Hash != record.ComputeHash() {
+			return errors.New("audit record " + strconv.FormatInt(record.Sequence, 10) + " has been modified")
+		}
+		if i > 0 && (record.PreviousHash != records[i-1].Hash || record.Sequence != records[i-1].Sequence+1) {
+			return errors.New("audit chain broken before record " + strconv.FormatInt(record.Sequence, 10))
+		}
+	}
+	return nil
+}
+
+// auditSubject - keyed hash of user id, erased user stays identifiable only to someone knowing the id
+func auditSubject(userID string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET")))
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func revokedSessionsKey(userID string) string {
+	return "session:revoked:" + userID
+}
+
+// RevokeSessions - invalidate every token of user issued until now
+func (service *Service) RevokeSessions(userID string) error {
+	return service.cache.Set(revokedSessionsKey(userID), time.Now().Unix(), revocationTTL)
+}
+
+// SessionRevoked - whether token issued at time has been revoked. Failing to read the revocation
+// is returned as error, callers refuse the token then instead of letting revoked sessions in
+func (service *Service) SessionRevoked(userID string, issuedAt time.Time) (bool, error) {
+	revokedAt, found, err := service.cache.Lookup(revokedSessionsKey(userID))
+	if err != nil || !found {
+		return false, err
+	}
+	revokedAtUnix, err := strconv.ParseInt(revokedAt, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Unix() <= revokedAtUnix, nil
+}
+
+// ErasedData - data of user kept by another service, erased along with the account and
+// listed in audit record under Name
+type ErasedData struct {
+	Name  string
+	Erase func(userID string) error
+}
+
+// DeleteAccount - erase everything stored about user including data of other services, then
+// revoke user's sessions and record the erasure in audit log. Every step is repeatable so a
+// failed erasure can be retried, sessions stay valid for that until all data is gone
+func (service *Service) DeleteAccount(userID string, others ...ErasedData) error {
+	if err := service.storage.DeleteExports(userID); err != nil {
+		return err
+	}
+	if err := service.storage.DeleteLibrarySnapshots(userID); err != nil {
+		return err
+	}
+	if err := service.storage.DeletePlaylistVersions(userID); err != nil {
+		return err
+	}
+	if err := service.storage.DeletePlays(userID); err != nil {
+		return err
+	}
+	if err := service.cache.ClearPrefix("user:" + userID + ":"); err != nil {
+		return err
+	}
+	details := []string{"history", "library", "playlists", "exports", "cache"}
+	for _, other := range others {
+		if err := other.Erase(userID); err != nil {
+			return err
+		}
+		details = append(details, other.Name)
+	}
+	if err := service.storage.DeleteProfile(userID); err != nil {
+		return err
+	}
+	if err := service.RevokeSessions(userID); err != nil {
+		return err
+	}
+	details = append(details, "profile", "credentials", "sessions")
+	_, err := service.storage.AppendAuditRecord(AuditRecord{
+		Action:  AuditErasure,
+		Subject: auditSubject(userID),
+		Details: details,
+		At:      time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,81 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"utilserver/pkg/webhooks"
+)
+
+// ErrReauthRequired - user revoked access of the app or its refresh token expired
+var ErrReauthRequired = errors.New("spotify authorization has been revoked, log in again")
+
+// a user is told once a day to log in again
+const reauthNoticeTTL = 24 * time.Hour
+
+// top items are compared with the ones seen in the last month
+const topItemsTTL = 30 * 24 * time.Hour
+
+// TopItemsChange - top artists or tracks of user differing from the ones seen before
+type TopItemsChange struct {
+	Type      string   `json:"type"`
+	TimeRange string   `json:"time_range"`
+	Items     []string `json:"items"`
+	Previous  []string `json:"previous"`
+}
+
+func (service *Service) publish(userID string, event string, data interface{}) {
+	if service.publisher != nil {
+		service.publisher.Publish(userID, event, data)
+	}
+}
+
+func reauthNoticeKey(userID string) string {
+	return "user:" + userID + ":reauth-required"
+}
+
+// tell user once to log in again after spotify rejected the refresh token
+func (service *Service) reauthRequired(userID string) {
+	if _, err := service.cache.Get(reauthNoticeKey(userID)); err == nil {
+		return
+	}
+	service.cache.Set(reauthNoticeKey(userID), time.Now().Unix(), reauthNoticeTTL)
+	service.publish(userID, webhooks.EventReauthRequired, map[string]string{"provider": "spotify"})
+}
+
+func topItemsKey(userID string, topType string, timeRange string) string {
+	return "user:" + userID + ":top:" + topType + ":" + timeRange
+}
+
+// compare first page of top items with the one seen last time and publish the change
+func (service *Service) detectTopItemsChange(userID string, topType string, timeRange string, body []byte) {
+	var container struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(body, &container) != nil || len(container.Items) == 0 {
+		return
+	}
+	items := make([]string, 0, len(container.Items))
+	for _, item := range container.Items {
+		items = append(items, item.ID)
+	}
+	key := topItemsKey(userID, topType, timeRange)
+	current := strings.Join(items, ",")
+	previous, err := service.cache.Get(key)
+	service.cache.Set(key, current, topItemsTTL)
+	if err != nil {
+		// nothing to compare with yet
+		return
+	}
+	if previous, ok := previous.(string); ok && previous != current {
+		service.publish(userID, webhooks.EventTopItemsChanged, TopItemsChange{
+			Type:      topType,
+			TimeRange: timeRange,
+			Items:     items,
+			Previous:  strings.Split(previous, ","),
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"utilserver/pkg/export"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// statuses of export job
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+var exportTimeRanges = [...]string{"short_term", "medium_term", "long_term"}
+
+// running exports not finished after exportStaleAfter were interrupted by a restart, they're run
+// again until they were started maxExportAttempts times
+const (
+	exportStaleAfter  = 30 * time.Minute
+	maxExportAttempts = 3
+)
+
+// Export - background job producing archive of user's data
+type Export struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      string             `bson:"user_id" json:"-"`
+	Format      string             `bson:"format" json:"format"`
+	Status      string             `bson:"status" json:"status"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	FileID      primitive.ObjectID `bson:"file_id,omitempty" json:"-"`
+	Size        int64              `bson:"size,omitempty" json:"size,omitempty"`
+	Attempts    int                `bson:"attempts" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt   *time.Time         `bson:"started_at,omitempty" json:"-"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// rows of exported tables, tags drive csv headers and parquet schema
+type PlayRow struct {
+	PlayedAt  string `csv:"played_at" parquet:"name=played_at, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TrackID   string `csv:"track_id" parquet:"name=track_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TrackName string `csv:"track_name" parquet:"name=track_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Artists   string `csv:"artists" parquet:"name=artists, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AlbumName string `csv:"album_name" parquet:"name=album_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MSPlayed  int32  `csv:"ms_played" parquet:"name=ms_played, type=INT32"`
+	Source    string `csv:"source" parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type TopItemRow struct {
+	TimeRange  string `csv:"time_range" parquet:"name=time_range, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rank       int32  `csv:"rank" parquet:"name=rank, type=INT32"`
+	ID         string `csv:"id" parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name       string `csv:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Artists    string `csv:"artists" parquet:"name=artists, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Genres     string `csv:"genres" parquet:"name=genres, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Popularity int32  `csv:"popularity" parquet:"name=popularity, type=INT32"`
+}
+
+type PlaylistRow struct {
+	ID            string `csv:"id" parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name          string `csv:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Owner         string `csv:"owner" parquet:"name=owner, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Public        bool   `csv:"public" parquet:"name=public, type=BOOLEAN"`
+	Collaborative bool   `csv:"collaborative" parquet:"name=collaborative, type=BOOLEAN"`
+	Tracks        int32  `csv:"tracks" parquet:"name=tracks, type=INT32"`
+	SnapshotID    string `csv:"snapshot_id" parquet:"name=snapshot_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type AudioFeaturesRow struct {
+	TimeRange        string  `csv:"time_range" parquet:"name=time_range, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Danceability     float64 `csv:"danceability" parquet:"name=danceability, type=DOUBLE"`
+	Energy           float64 `csv:"energy" parquet:"name=energy, type=DOUBLE"`
+	Loudness         float64 `csv:"loudness" parquet:"name=loudness, type=DOUBLE"`
+	Speechiness      float64 `csv:"speechiness" parquet:"name=speechiness, type=DOUBLE"`
+	Acousticness     float64 `csv:"acousticness" parquet:"name=acousticness, type=DOUBLE"`
+	Instrumentalness float64 `csv:"instrumentalness" parquet:"name=instrumentalness, type=DOUBLE"`
+	Liveness         float64 `csv:"liveness" parquet:"name=liveness, type=DOUBLE"`
+	Valence          float64 `csv:"valence" parquet:"name=valence, type=DOUBLE"`
+	Tempo            float64 `csv:"tempo" parquet:"name=tempo, type=DOUBLE"`
+}
+
+// exportRetention - how long finished exports can be downloaded
+func exportRetention() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("EXPORT_RETENTION_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 72
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func artistNames(artists []Artist) string {
+	names := make([]string, 0, len(artists))
+	for _, artist := range artists {
+		names = append(names, artist.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// CreateExport - register export of user's data, it's generated by RunPendingExports
+func (service *Service) CreateExport(userID string, format string) (*Export, error) {
+	if !export.ValidFormat(format) {
+		return nil, newArgumentError("format must be one of json, csv, parquet")
+	}
+	job := Export{
+		UserID:    userID,
+		Format:    format,
+		Status:    ExportPending,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(exportRetention()),
+	}
+	return service.storage.CreateExport(job)
+}
+
+// GetExport - export job of user
+func (service *Service) GetExport(userID string, id string) (*Export, error) {
+	job, err := service.storage.GetExport(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// OpenExportArchive - finished export of user with reader of its archive, caller closes it
+func (service *Service) OpenExportArchive(userID string, id string) (*Export, io.ReadCloser, error) {
+	job, err := service.GetExport(userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Status != ExportDone {
+		return nil, nil, fmt.Errorf("export is %s: %w", job.Status, ErrNotFound)
+	}
+	archive, err := service.storage.OpenExportArchive(job.FileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, archive, nil
+}
+
+// RunPendingExports - generate pending exports one after another, exports left running by a
+// replica which went away are run again
+func (service *Service) RunPendingExports() error {
+	for {
+		job, err := service.storage.ClaimExport(time.Now().Add(-exportStaleAfter))
+		if err != nil || job == nil {
+			return err
+		}
+		service.runExport(*job)
+	}
+}
+
+// runExport - generate archive of export claimed as running, the archive is streamed into
+// storage while it's written
+func (service *Service) runExport(job Export) {
+	var err error
+	if job.Attempts > maxExportAttempts {
+		err = fmt.Errorf("export was interrupted %d times", job.Attempts-1)
+	} else {
+		job.Size, job.FileID, err = service.saveExport(job)
+	}
+	completedAt := time.Now()
+	job.CompletedAt = &completedAt
+	job.Status = ExportDone
+	if err != nil {
+		service.logger.Error("export failed", "export_id", job.ID.Hex(), "error", err)
+		job.Status = ExportFailed
+		job.Error = err.Error()
+	}
+	if err := service.storage.UpdateExport(job); err != nil {
+		service.logger.Error("export failed", "export_id", job.ID.Hex(), "error", err)
+	}
+}
+
+// write archive of export into storage through a pipe and return its size and file id. A failed
+// export fails the upload and a failed upload stops the export
+func (service *Service) saveExport(job Export) (int64, primitive.ObjectID, error) {
+	reader, writer := io.Pipe()
+	archive := &countingWriter{w: writer}
+	exported := make(chan error, 1)
+	go func() {
+		err := service.writeExport(archive, job)
+		writer.CloseWithError(err)
+		exported <- err
+	}()
+	fileID, err := service.storage.SaveExportArchive("export-"+job.ID.Hex()+".zip", reader)
+	reader.CloseWithError(err)
+	// error of export is the cause when upload failed reading it
+	if exportErr := <-exported; exportErr != nil && !errors.Is(exportErr, io.ErrClosedPipe) {
+		err = exportErr
+	}
+	return archive.written, fileID, err
+}
+
+// countingWriter - writer counting bytes written through it
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.written += int64(n)
+	return n, err
+}
+
+// collect user's data and write it as archive
+func (service *Service) writeExport(archive io.Writer, job Export) error {
+	profile, err := service.storage.GetProfile(job.UserID)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return ErrNotFound
+	}
+	// credentials never leave the server
+	var profileDocument map[string]interface{}
+	profileByteArr, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(profileByteArr, &profileDocument); err != nil {
+		return err
+	}
+	delete(profileDocument, "credentials")
+
+	history, err := service.exportHistory(job.UserID)
+	if err != nil {
+		return err
+	}
+	topTracks := []TopItemRow{}
+	topArtists := []TopItemRow{}
+	audioFeatures := []AudioFeaturesRow{}
+	for _, timeRange := range exportTimeRanges {
+		resp, err := service.GetTopArtistsOrTracks(job.UserID, "tracks", timeRange, 50, 0)
+		if err != nil {
+			return err
+		}
+		var tracks struct {
+			Items []Track `json:"items"`
+		}
+		if err := json.Unmarshal(*resp, &tracks); err != nil {
+			return err
+		}
+		for i, track := range tracks.Items {
+			topTracks = append(topTracks, TopItemRow{
+				TimeRange:  timeRange,
+				Rank:       int32(i + 1),
+				ID:         track.ID,
+				Name:       track.Name,
+				Artists:    artistNames(track.Artists),
+				Popularity: int32(track.Popularity),
+			})
+		}
+		artists, err := service.getTopArtists(job.UserID, timeRange)
+		if err != nil {
+			return err
+		}
+		for i, artist := range artists {
+			topArtists = append(topArtists, TopItemRow{
+				TimeRange:  timeRange,
+				Rank:       int32(i + 1),
+				ID:         artist.ID,
+				Name:       artist.Name,
+				Genres:     strings.Join(artist.Genres, ", "),
+				Popularity: int32(artist.Popularity),
+			})
+		}
+		if len(tracks.Items) == 0 {
+			continue
+		}
+		features, err := service.averageAudioFeatures(job.UserID, tracks.Items)
+		if err != nil {
+			return err
+		}
+		audioFeatures = append(audioFeatures, AudioFeaturesRow{
+			TimeRange:        timeRange,
+			Danceability:     features.Danceability,
+			Energy:           features.Energy,
+			Loudness:         features.Loudness,
+			Speechiness:      features.Speechiness,
+			Acousticness:     features.Acousticness,
+			Instrumentalness: features.Instrumentalness,
+			Liveness:         features.Liveness,
+			Valence:          features.Valence,
+			Tempo:            features.Tempo,
+		})
+	}
+	playlists, err := service.fetchUserPlaylists(job.UserID)
+	if err != nil {
+		return err
+	}
+	playlistRows := make([]PlaylistRow, 0, len(playlists))
+	for _, playlist := range playlists {
+		playlistRows = append(playlistRows, PlaylistRow{
+			ID:            playlist.ID,
+			Name:          playlist.Name,
+			Owner:         playlist.Owner.ID,
+			Public:        playlist.Public,
+			Collaborative: playlist.Collaborative,
+			Tracks:        int32(playlist.Tracks.Total),
+			SnapshotID:    playlist.SnapshotID,
+		})
+	}
+
+	return export.WriteArchive(archive, job.Format,
+		map[string]interface{}{"profile": profileDocument},
+		[]export.Table{
+			{Name: "listening_history", Rows: history, Schema: new(PlayRow)},
+			{Name: "top_tracks", Rows: topTracks, Schema: new(TopItemRow)},
+			{Name: "top_artists", Rows: topArtists, Schema: new(TopItemRow)},
+			{Name: "playlists", Rows: playlistRows, Schema: new(PlaylistRow)},
+			{Name: "audio_features", Rows: audioFeatures, Schema: new(AudioFeaturesRow)},
+		},
+	)
+}
+
+// whole stored listening history, brought up to date with recently played first
+func (service *Service) exportHistory(userID string) ([]PlayRow, error) {
+	if _, err := service.IngestRecentlyPlayed(userID); err != nil {
+		service.logger.Warn("history ingest failed", "error", err)
+	}
+	plays, err := service.storage.GetPlays(userID, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]PlayRow, 0, len(plays))
+	for _, play := range plays {
+		rows = append(rows, PlayRow{
+			PlayedAt:  play.PlayedAt.UTC().Format(time.RFC3339),
+			TrackID:   play.TrackID,
+			TrackName: play.TrackName,
+			Artists:   play.ArtistName,
+			AlbumName: play.AlbumName,
+			MSPlayed:  int32(play.MSPlayed),
+			Source:    play.Source,
+		})
+	}
+	return rows, nil
+}
+
+// DeleteExpiredExports - remove exports past their retention with their archives
+func (service *Service) DeleteExpiredExports() error {
+	_, err := service.storage.DeleteExpiredExports(time.Now())
+	return err
+}
@@ -0,0 +1,209 @@
+package spotify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"utilserver/pkg/logging"
+)
+
+// genres with positive share change between long and short term kept in breakdown
+const emergingGenresLimit = 10
+
+type GenreShare struct {
+	Genre   string  `json:"genre"`
+	Share   float64 `json:"share"`
+	Artists int     `json:"artists"`
+}
+
+type GenreFamilyShare struct {
+	Family string   `json:"family"`
+	Share  float64  `json:"share"`
+	Genres []string `json:"genres"`
+}
+
+type EmergingGenre struct {
+	Genre          string  `json:"genre"`
+	ShortTermShare float64 `json:"short_term_share"`
+	LongTermShare  float64 `json:"long_term_share"`
+	Delta          float64 `json:"delta"`
+}
+
+// GenreBreakdown - weighted genre shares of user's top artists
+type GenreBreakdown struct {
+	TimeRange string             `json:"time_range"`
+	Genres    []GenreShare       `json:"genres"`
+	Families  []GenreFamilyShare `json:"families"`
+	Emerging  []EmergingGenre    `json:"emerging"`
+}
+
+var genreFamilies map[string][]string
+var loadGenreFamilies sync.Once
+
+// load genre family mapping file once, family name maps to keywords matched against spotify genres
+func getGenreFamilies(logger *logging.Logger) map[string][]string {
+	loadGenreFamilies.Do(func() {
+		genreFamilies = map[string][]string{}
+		path := os.Getenv("GENRE_FAMILIES_FILE")
+		if path == "" {
+			path = "config/genre-families.json"
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Warn("genre families not loaded", "path", path, "error", err)
+			return
+		}
+		if err := json.Unmarshal(content, &genreFamilies); err != nil {
+			logger.Warn("genre families not loaded", "path", path, "error", err)
+		}
+	})
+	return genreFamilies
+}
+
+// genreFamily - family of genre with the longest keyword contained in genre, "other" if none matches
+func genreFamily(genre string, families map[string][]string) string {
+	family := "other"
+	matched := 0
+	for name, keywords := range families {
+		for _, keyword := range keywords {
+			keyword = strings.ToLower(keyword)
+			if !strings.Contains(genre, keyword) {
+				continue
+			}
+			if len(keyword) > matched || (len(keyword) == matched && name < family) {
+				family = name
+				matched = len(keyword)
+			}
+		}
+	}
+	return family
+}
+
+// top artists of user in given time range, ordered by affinity
+func (service *Service) getTopArtists(userID string, timeRange string) ([]Artist, error) {
+	resp, err := service.GetTopArtistsOrTracks(userID, "artists", timeRange, 50, 0)
+	if err != nil {
+		return nil, err
+	}
+	var container struct {
+		Items []Artist `json:"items"`
+	}
+	if err := json.Unmarshal(*resp, &container); err != nil {
+		return nil, err
+	}
+	return container.Items, nil
+}
+
+// genreShares - share of every genre weighted by artist rank, higher ranked artists weigh more
+// and weight of an artist is split evenly between its genres
+func genreShares(artists []Artist) []GenreShare {
+	weights := map[string]float64{}
+	counts := map[string]int{}
+	total := 0.0
+	for rank, artist := range artists {
+		if len(artist.Genres) == 0 {
+			continue
+		}
+		weight := float64(len(artists)-rank) / float64(len(artist.Genres))
+		for _, genre := range artist.Genres {
+			weights[genre] += weight
+			counts[genre]++
+			total += weight
+		}
+	}
+	shares := make([]GenreShare, 0, len(weights))
+	for genre, weight := range weights {
+		shares = append(shares, GenreShare{Genre: genre, Share: weight / total, Artists: counts[genre]})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Share == shares[j].Share {
+			return shares[i].Genre < shares[j].Genre
+		}
+		return shares[i].Share > shares[j].Share
+	})
+	return shares
+}
+
+// roll genre shares up into families
+func familyShares(shares []GenreShare, families map[string][]string) []GenreFamilyShare {
+	index := map[string]int{}
+	rollup := []GenreFamilyShare{}
+	for _, share := range shares {
+		family := genreFamily(strings.ToLower(share.Genre), families)
+		i, ok := index[family]
+		if !ok {
+			i = len(rollup)
+			index[family] = i
+			rollup = append(rollup, GenreFamilyShare{Family: family, Genres: []string{}})
+		}
+		rollup[i].Share += share.Share
+		rollup[i].Genres = append(rollup[i].Genres, share.Genre)
+	}
+	sort.Slice(rollup, func(i, j int) bool {
+		if rollup[i].Share == rollup[j].Share {
+			return rollup[i].Family < rollup[j].Family
+		}
+		return rollup[i].Share > rollup[j].Share
+	})
+	return rollup
+}
+
+// genres gaining share in short term compared to long term
+func emergingGenres(shortTerm []GenreShare, longTerm []GenreShare) []EmergingGenre {
+	longTermShares := map[string]float64{}
+	for _, share := range longTerm {
+		longTermShares[share.Genre] = share.Share
+	}
+	emerging := []EmergingGenre{}
+	for _, share := range shortTerm {
+		delta := share.Share - longTermShares[share.Genre]
+		if delta <= 0 {
+			continue
+		}
+		emerging = append(emerging, EmergingGenre{
+			Genre:          share.Genre,
+			ShortTermShare: share.Share,
+			LongTermShare:  longTermShares[share.Genre],
+			Delta:          delta,
+		})
+	}
+	sort.Slice(emerging, func(i, j int) bool {
+		if emerging[i].Delta == emerging[j].Delta {
+			return emerging[i].Genre < emerging[j].Genre
+		}
+		return emerging[i].Delta > emerging[j].Delta
+	})
+	if len(emerging) > emergingGenresLimit {
+		emerging = emerging[:emergingGenresLimit]
+	}
+	return emerging
+}
+
+// GetGenreBreakdown - genre and genre family shares of user's top artists in time range
+// along with genres emerging in short term compared to long term
+func (service *Service) GetGenreBreakdown(userID string, timeRangeStr string) (*GenreBreakdown, error) {
+	timeRange, err := TopQueryValidator(timeRangeStr, "time_range")
+	if err != nil {
+		return nil, err
+	}
+	shares := map[string][]GenreShare{}
+	for _, r := range []string{timeRange, "short_term", "long_term"} {
+		if _, ok := shares[r]; ok {
+			continue
+		}
+		artists, err := service.getTopArtists(userID, r)
+		if err != nil {
+			return nil, err
+		}
+		shares[r] = genreShares(artists)
+	}
+	return &GenreBreakdown{
+		TimeRange: timeRange,
+		Genres:    shares[timeRange],
+		Families:  familyShares(shares[timeRange], getGenreFamilies(service.logger)),
+		Emerging:  emergingGenres(shares["short_term"], shares["long_term"]),
+	}, nil
+}
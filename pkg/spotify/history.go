@@ -0,0 +1,531 @@
+package spotify
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"utilserver/pkg/breaker"
+	"utilserver/pkg/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sources of listening history
+const (
+	PlaySourceAPI    = "api"
+	PlaySourceImport = "import"
+	PlaySourceLastFM = "lastfm"
+)
+
+// plays of the same track closer than this are the same play seen by two sources,
+// account data timestamps are rounded to minutes
+const playDedupeWindow = 90 * time.Second
+
+// longest play considered when looking for duplicates by start of the play
+const maxPlayLength = 30 * time.Minute
+
+// unpacked size of streaming history files in a zipped privacy export, a year of extended
+// history is a few tens of megabytes. Larger archives are refused instead of filling memory
+const (
+	maxHistoryFileSize    = 128 << 20
+	maxHistoryArchiveSize = 1 << 30
+)
+
+// errHistoryTooLarge - streaming history of archive unpacks to more than the limits
+var errHistoryTooLarge = newArgumentError("streaming history of archive is too large when unpacked")
+
+// PlaysListener - told about plays newly added to user's listening history
+type PlaysListener func(userID string, plays []Play)
+
+// Play - one play of a track in user's listening history, played at is the end of the play.
+// Plays of unknown length have zero ms played and played at is their start as well
+type Play struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID     string             `bson:"user_id" json:"-"`
+	PlayedAt   time.Time          `bson:"played_at" json:"played_at"`
+	TrackID    string             `bson:"track_id,omitempty" json:"track_id,omitempty"`
+	TrackName  string             `bson:"track_name" json:"track_name"`
+	ArtistName string             `bson:"artist_name" json:"artist_name"`
+	AlbumName  string             `bson:"album_name,omitempty" json:"album_name,omitempty"`
+	MSPlayed   int                `bson:"ms_played" json:"ms_played"`
+	Source     string             `bson:"source" json:"source"`
+	// Pending - track of play isn't in catalog yet, it's looked up by ResolvePendingPlays
+	Pending bool `bson:"pending,omitempty" json:"-"`
+}
+
+// HistoryImport - outcome of importing plays into listening history
+type HistoryImport struct {
+	Parsed     int `json:"parsed"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Resolved   int `json:"resolved"`
+	Unresolved int `json:"unresolved"`
+	// Pending - plays whose tracks are looked up in spotify in the background
+	Pending int `json:"pending"`
+}
+
+// HistoryPage - page of listening history newest first
+type HistoryPage struct {
+	Items  []Play `json:"items"`
+	Total  int64  `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// entry of StreamingHistory*.json (account data) or endsong_*.json (extended streaming history).
+// Extended streaming history only names the album artist, which differs from the first artist of
+// the track the api gives for features and compilations, its plays are matched by track uri
+type streamingHistoryEntry struct {
+	EndTime    string `json:"endTime"`
+	ArtistName string `json:"artistName"`
+	TrackName  string `json:"trackName"`
+	MSPlayed   int    `json:"msPlayed"`
+
+	Timestamp  string `json:"ts"`
+	MSPlayedX  int    `json:"ms_played"`
+	Track      string `json:"master_metadata_track_name"`
+	Artist     string `json:"master_metadata_album_artist_name"`
+	Album      string `json:"master_metadata_album_album_name"`
+	TrackURI   string `json:"spotify_track_uri"`
+	EpisodeURI string `json:"spotify_episode_uri"`
+}
+
+// ParseStreamingHistory - read plays from a file of spotify privacy export, both account data
+// and extended streaming history are accepted. Podcast episodes are skipped
+func ParseStreamingHistory(r io.Reader) ([]Play, error) {
+	var entries []streamingHistoryEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		if errors.Is(err, errHistoryTooLarge) {
+			return nil, err
+		}
+		return nil, newArgumentError("not a spotify streaming history file: " + err.Error())
+	}
+	plays := make([]Play, 0, len(entries))
+	for _, entry := range entries {
+		play := Play{Source: PlaySourceImport}
+		if entry.Timestamp != "" {
+			playedAt, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				return nil, newArgumentError("invalid ts " + entry.Timestamp)
+			}
+			play.PlayedAt = playedAt
+			play.TrackName = entry.Track
+			play.ArtistName = entry.Artist
+			play.AlbumName = entry.Album
+			play.MSPlayed = entry.MSPlayedX
+			if strings.HasPrefix(entry.TrackURI, "spotify:track:") {
+				play.TrackID = strings.TrimPrefix(entry.TrackURI, "spotify:track:")
+			}
+		} else {
+			playedAt, err := time.Parse("2006-01-02 15:04", entry.EndTime)
+			if err != nil {
+				return nil, newArgumentError("invalid endTime " + entry.EndTime)
+			}
+			play.PlayedAt = playedAt
+			play.TrackName = entry.TrackName
+			play.ArtistName = entry.ArtistName
+			play.MSPlayed = entry.MSPlayed
+		}
+		if play.TrackName == "" || entry.EpisodeURI != "" {
+			continue
+		}
+		plays = append(plays, play)
+	}
+	return plays, nil
+}
+
+// IsStreamingHistoryFile - whether file of privacy export holds streaming history
+func IsStreamingHistoryFile(name string) bool {
+	base := path.Base(name)
+	if path.Ext(base) != ".json" {
+		return false
+	}
+	return strings.HasPrefix(base, "StreamingHistory") ||
+		strings.HasPrefix(base, "endsong_") ||
+		strings.HasPrefix(base, "Streaming_History_Audio_")
+}
+
+// limitedReader - reader failing with errHistoryTooLarge once more than remaining bytes are read,
+// sizes in zip headers can't be trusted
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errHistoryTooLarge
+	}
+	return n, err
+}
+
+// ParseStreamingHistoryArchive - read plays from every streaming history file of zipped privacy
+// export. Fails with an argument error when a file unpacks to more than maxHistoryFileSize or
+// the files together to more than maxHistoryArchiveSize
+func ParseStreamingHistoryArchive(r io.ReaderAt, size int64) ([]Play, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, newArgumentError("not a zip archive: " + err.Error())
+	}
+	plays := []Play{}
+	unpacked := int64(0)
+	for _, file := range archive.File {
+		if !IsStreamingHistoryFile(file.Name) {
+			continue
+		}
+		limit := maxHistoryArchiveSize - unpacked
+		if limit > maxHistoryFileSize {
+			limit = maxHistoryFileSize
+		}
+		if file.UncompressedSize64 > uint64(limit) {
+			return nil, errHistoryTooLarge
+		}
+		content, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		limited := &limitedReader{r: content, remaining: limit}
+		filePlays, err := ParseStreamingHistory(limited)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+		unpacked += limit - limited.remaining
+		plays = append(plays, filePlays...)
+	}
+	return plays, nil
+}
+
+// playKey - artist and name of the played track, artists of imports are album artists
+func playKey(play Play) string {
+	return strings.ToLower(play.ArtistName) + "\x00" + strings.ToLower(play.TrackName)
+}
+
+// StartedAt - when the play started
+func (play Play) StartedAt() time.Time {
+	return play.PlayedAt.Add(-time.Duration(play.MSPlayed) * time.Millisecond)
+}
+
+func within(a time.Time, b time.Time, window time.Duration) bool {
+	diff := a.Sub(b)
+	return diff < window && diff > -window
+}
+
+// plays of the same track are the same play when they end or start at about the same time,
+// scrobbles only know when the play started. Tracks are the same when their ids match, artist
+// and name are only compared when one of the plays has no id
+func samePlay(a Play, b Play) bool {
+	if a.TrackID != "" && b.TrackID != "" {
+		if a.TrackID != b.TrackID {
+			return false
+		}
+	} else if playKey(a) != playKey(b) {
+		return false
+	}
+	return within(a.PlayedAt, b.PlayedAt, playDedupeWindow) || within(a.StartedAt(), b.StartedAt(), playDedupeWindow)
+}
+
+// ImportHistory - add imported plays to user's listening history, plays already known from
+// the api or an earlier import are skipped and tracks are resolved to catalog entries. Tracks
+// missing in catalog are looked up later by ResolvePendingPlays, so imports don't wait for
+// spotify. Plays without source are privacy export imports
+func (service *Service) ImportHistory(userID string, plays []Play) (*HistoryImport, error) {
+	result := &HistoryImport{Parsed: len(plays)}
+	if len(plays) == 0 {
+		return result, nil
+	}
+	for i := range plays {
+		plays[i].UserID = userID
+		if plays[i].Source == "" {
+			plays[i].Source = PlaySourceImport
+		}
+	}
+	if err := service.resolvePlays(plays, result); err != nil {
+		return nil, err
+	}
+	imported, err := service.savePlays(userID, plays)
+	if err != nil {
+		return nil, err
+	}
+	result.Imported = len(imported)
+	result.Duplicates = len(plays) - len(imported)
+	return result, nil
+}
+
+// fill track ids and albums of plays from catalog, tracks with uri are looked up by id
+// and tracks without it by name and artist. Plays whose track id isn't in catalog are pending
+func (service *Service) resolvePlays(plays []Play, result *HistoryImport) error {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, play := range plays {
+		if play.TrackID != "" && !seen[play.TrackID] {
+			seen[play.TrackID] = true
+			ids = append(ids, play.TrackID)
+		}
+	}
+	byID := map[string]Track{}
+	if len(ids) > 0 {
+		tracks, err := service.storage.GetTracks(ids, time.Time{})
+		if err != nil {
+			return err
+		}
+		for _, track := range tracks {
+			byID[track.ID] = track
+		}
+	}
+	byName := map[string]*Track{}
+	for i, play := range plays {
+		if play.TrackID == "" {
+			key := playKey(play)
+			track, ok := byName[key]
+			if !ok {
+				var err error
+				track, err = service.storage.FindTrack(play.TrackName, play.ArtistName)
+				if err != nil {
+					return err
+				}
+				byName[key] = track
+			}
+			if track == nil {
+				result.Unresolved++
+				continue
+			}
+			plays[i].TrackID = track.ID
+			byID[track.ID] = *track
+		}
+		track, ok := byID[plays[i].TrackID]
+		if !ok {
+			plays[i].Pending = true
+			result.Pending++
+			continue
+		}
+		result.Resolved++
+		resolvePlay(&plays[i], track)
+	}
+	return nil
+}
+
+// resolvePlay - fill album of play from its track, plays of unknown length take length of the track
+func resolvePlay(play *Play, track Track) {
+	if play.AlbumName == "" && track.Album != nil {
+		play.AlbumName = track.Album.Name
+	}
+	if play.MSPlayed == 0 && track.DurationMS > 0 {
+		play.MSPlayed = track.DurationMS
+		play.PlayedAt = play.PlayedAt.Add(time.Duration(track.DurationMS) * time.Millisecond)
+	}
+}
+
+// pending plays resolved per run of ResolvePendingPlays
+const pendingPlaysBatch = 1000
+
+// ResolvePendingPlays - look up tracks of pending plays in spotify and fill the plays from them.
+// Meant as background job, plays stay pending while spotify is rate limited or unavailable.
+// Plays of users whose tracks can't be looked up otherwise are left unresolved
+func (service *Service) ResolvePendingPlays() error {
+	pending, err := service.storage.GetPendingPlays(pendingPlaysBatch)
+	if err != nil {
+		return err
+	}
+	userIDs := []string{}
+	byUser := map[string][]Play{}
+	for _, play := range pending {
+		if _, ok := byUser[play.UserID]; !ok {
+			userIDs = append(userIDs, play.UserID)
+		}
+		byUser[play.UserID] = append(byUser[play.UserID], play)
+	}
+	for _, userID := range userIDs {
+		plays := byUser[userID]
+		ids := []string{}
+		seen := map[string]bool{}
+		for _, play := range plays {
+			if !seen[play.TrackID] {
+				seen[play.TrackID] = true
+				ids = append(ids, play.TrackID)
+			}
+		}
+		byID := map[string]Track{}
+		tracks, err := service.GetTracks(userID, ids)
+		switch {
+		case errors.Is(err, ErrRateLimited), errors.Is(err, breaker.ErrUnavailable):
+			return err
+		case err != nil:
+			service.logger.Warn("pending plays not resolved", "user_id", userID, "error", err)
+		}
+		for _, track := range tracks {
+			byID[track.ID] = track
+		}
+		for i := range plays {
+			if track, ok := byID[plays[i].TrackID]; ok {
+				resolvePlay(&plays[i], track)
+			}
+			plays[i].Pending = false
+		}
+		if err := service.storage.UpdatePlays(plays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plays deduplicated and stored at once, existing plays are only loaded around each batch
+const savePlaysBatch = 1000
+
+// store plays which aren't in history yet and return the stored ones
+func (service *Service) savePlays(userID string, plays []Play) ([]Play, error) {
+	sort.Slice(plays, func(i, j int) bool { return plays[i].PlayedAt.Before(plays[j].PlayedAt) })
+	fresh := []Play{}
+	for start := 0; start < len(plays); start += savePlaysBatch {
+		end := start + savePlaysBatch
+		if end > len(plays) {
+			end = len(plays)
+		}
+		saved, err := service.savePlaysBatch(userID, plays[start:end])
+		if err != nil {
+			return nil, err
+		}
+		fresh = append(fresh, saved...)
+	}
+	return fresh, nil
+}
+
+// store plays sorted by time which aren't in history yet, plays of earlier batches are stored
+// already and found in history like any other
+func (service *Service) savePlaysBatch(userID string, plays []Play) ([]Play, error) {
+	existing, err := service.storage.GetPlays(userID,
+		plays[0].PlayedAt.Add(-playDedupeWindow-maxPlayLength),
+		plays[len(plays)-1].PlayedAt.Add(playDedupeWindow+maxPlayLength),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// candidates of a play are the known plays of its track id and of its artist and name
+	byID := map[string][]Play{}
+	byName := map[string][]Play{}
+	remember := func(play Play) {
+		if play.TrackID != "" {
+			byID[play.TrackID] = append(byID[play.TrackID], play)
+		}
+		byName[playKey(play)] = append(byName[playKey(play)], play)
+	}
+	for _, play := range existing {
+		remember(play)
+	}
+	fresh := []Play{}
+	for _, play := range plays {
+		duplicate := false
+		for _, candidates := range [][]Play{byID[play.TrackID], byName[playKey(play)]} {
+			for _, other := range candidates {
+				duplicate = duplicate || samePlay(play, other)
+			}
+		}
+		if duplicate {
+			continue
+		}
+		remember(play)
+		fresh = append(fresh, play)
+	}
+	if len(fresh) == 0 {
+		return fresh, nil
+	}
+	if err := service.storage.SavePlays(fresh); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// IngestRecentlyPlayed - add recently played tracks of user to listening history, returns plays
+// which weren't in history yet
+func (service *Service) IngestRecentlyPlayed(userID string) ([]Play, error) {
+	resp, err := service.GetRecentlyPlayed(userID, 50, "-6795364578871", "-6795364578871")
+	if err != nil {
+		return nil, err
+	}
+	var recentlyPlayed struct {
+		Items []struct {
+			PlayedAt time.Time `json:"played_at"`
+			Track    Track     `json:"track"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(*resp, &recentlyPlayed); err != nil {
+		return nil, err
+	}
+	plays := make([]Play, 0, len(recentlyPlayed.Items))
+	for _, item := range recentlyPlayed.Items {
+		play := Play{
+			UserID:    userID,
+			PlayedAt:  item.PlayedAt,
+			TrackID:   item.Track.ID,
+			TrackName: item.Track.Name,
+			MSPlayed:  item.Track.DurationMS,
+			Source:    PlaySourceAPI,
+		}
+		if len(item.Track.Artists) > 0 {
+			play.ArtistName = item.Track.Artists[0].Name
+		}
+		if item.Track.Album != nil {
+			play.AlbumName = item.Track.Album.Name
+		}
+		plays = append(plays, play)
+	}
+	if len(plays) == 0 {
+		return plays, nil
+	}
+	fresh, err := service.savePlays(userID, plays)
+	if err != nil {
+		return nil, err
+	}
+	for _, play := range fresh {
+		service.publish(userID, webhooks.EventTrackPlayed, play)
+	}
+	return fresh, nil
+}
+
+// IngestAllRecentlyPlayed - add recently played tracks of every user to listening history,
+// listeners get new plays of each user
+func (service *Service) IngestAllRecentlyPlayed(listeners ...PlaysListener) error {
+	userIDs, err := service.storage.ListProfileUserIDs()
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		plays, err := service.forUser(userID).IngestRecentlyPlayed(userID)
+		if err != nil {
+			service.logger.Error("history ingest failed", "user_id", userID, "error", err)
+			continue
+		}
+		if len(plays) == 0 {
+			continue
+		}
+		for _, listener := range listeners {
+			listener(userID, plays)
+		}
+	}
+	return nil
+}
+
+// GetHistory - page of user's listening history between from and to, zero times leave range open
+func (service *Service) GetHistory(userID string, from time.Time, to time.Time, limit int, offset int) (*HistoryPage, error) {
+	if limit < 1 || limit > 500 {
+		return nil, newArgumentError("limit must be between 1 and 500")
+	}
+	if offset < 0 {
+		return nil, newArgumentError("offset must not be negative")
+	}
+	plays, total, err := service.storage.GetPlaysPage(userID, from, to, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryPage{Items: plays, Total: total, Limit: limit, Offset: offset}, nil
+}
@@ -0,0 +1,413 @@
+package spotify
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kinds of items in user's library
+const (
+	LibraryTracks  = "tracks"
+	LibraryAlbums  = "albums"
+	LibraryArtists = "artists"
+)
+
+var libraryKinds = [...]string{LibraryTracks, LibraryAlbums, LibraryArtists}
+
+type LibraryItem struct {
+	ID      string    `bson:"id" json:"id"`
+	AddedAt time.Time `bson:"added_at" json:"added_at"`
+}
+
+// LibrarySnapshot - items of one kind in user's library at sync time along with
+// items added and removed since previous sync, first snapshot of a kind has no diff
+type LibrarySnapshot struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID   string             `bson:"user_id" json:"-"`
+	Kind     string             `bson:"kind" json:"kind"`
+	SyncedAt time.Time          `bson:"synced_at" json:"synced_at"`
+	Count    int                `bson:"count" json:"count"`
+	Added    []string           `bson:"added" json:"added"`
+	Removed  []string           `bson:"removed" json:"removed"`
+	Items    []LibraryItem      `bson:"items,omitempty" json:"items,omitempty"`
+}
+
+type LibraryEntry struct {
+	AddedAt time.Time `json:"added_at"`
+	Track   *Track    `json:"track,omitempty"`
+	Album   *Album    `json:"album,omitempty"`
+	Artist  *Artist   `json:"artist,omitempty"`
+}
+
+// LibraryPage - page of latest synced library items joined with catalog
+type LibraryPage struct {
+	Kind     string         `json:"kind"`
+	SyncedAt time.Time      `json:"synced_at"`
+	Total    int            `json:"total"`
+	Limit    int            `json:"limit"`
+	Offset   int            `json:"offset"`
+	Items    []LibraryEntry `json:"items"`
+}
+
+type LibraryGrowthMonth struct {
+	Month string `json:"month"`
+	Added int    `json:"added"`
+	Total int    `json:"total"`
+}
+
+// LibraryGrowth - library size over time by month items were added, and per sync changes
+type LibraryGrowth struct {
+	Kind   string               `json:"kind"`
+	Months []LibraryGrowthMonth `json:"months"`
+	Syncs  []LibrarySnapshot    `json:"syncs"`
+}
+
+func validateLibraryKind(kind string) error {
+	for _, v := range libraryKinds {
+		if v == kind {
+			return nil
+		}
+	}
+	return newArgumentError("kind must be one of " + strings.Join(libraryKinds[:], ", "))
+}
+
+// page through saved tracks of user
+func (service *Service) fetchSavedTracks(userID string) ([]LibraryItem, error) {
+	items := []LibraryItem{}
+	URL := os.Getenv("SPOTIFY_SAVED_TRACKS") + "?limit=50"
+	for URL != "" {
+		resp, err := service.callSpotify(userID, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				AddedAt time.Time `json:"added_at"`
+				Track   Track     `json:"track"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		if err := json.Unmarshal(*resp, &page); err != nil {
+			return nil, err
+		}
+		tracks := make([]Track, 0, len(page.Items))
+		for _, item := range page.Items {
+			items = append(items, LibraryItem{ID: item.Track.ID, AddedAt: item.AddedAt})
+			tracks = append(tracks, item.Track)
+		}
+		service.catalog(tracks, nil, nil)
+		URL = page.Next
+	}
+	return items, nil
+}
+
+// page through saved albums of user
+func (service *Service) fetchSavedAlbums(userID string) ([]LibraryItem, error) {
+	items := []LibraryItem{}
+	URL := os.Getenv("SPOTIFY_SAVED_ALBUMS") + "?limit=50"
+	for URL != "" {
+		resp, err := service.callSpotify(userID, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				AddedAt time.Time `json:"added_at"`
+				Album   Album     `json:"album"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		if err := json.Unmarshal(*resp, &page); err != nil {
+			return nil, err
+		}
+		albums := make([]Album, 0, len(page.Items))
+		for _, item := range page.Items {
+			items = append(items, LibraryItem{ID: item.Album.ID, AddedAt: item.AddedAt})
+			albums = append(albums, item.Album)
+		}
+		service.catalog(nil, nil, albums)
+		URL = page.Next
+	}
+	return items, nil
+}
+
+// page through followed artists of user, spotify has no follow date so the
+// time artist was first seen in a sync is used instead
+func (service *Service) fetchFollowedArtists(userID string, previous *LibrarySnapshot, syncedAt time.Time) ([]LibraryItem, error) {
+	firstSeen := map[string]time.Time{}
+	if previous != nil {
+		for _, item := range previous.Items {
+			firstSeen[item.ID] = item.AddedAt
+		}
+	}
+	items := []LibraryItem{}
+	URL := os.Getenv("SPOTIFY_FOLLOWING") + "?type=artist&limit=50"
+	for URL != "" {
+		resp, err := service.callSpotify(userID, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var container struct {
+			Artists struct {
+				Items []Artist `json:"items"`
+				Next  string   `json:"next"`
+			} `json:"artists"`
+		}
+		if err := json.Unmarshal(*resp, &container); err != nil {
+			return nil, err
+		}
+		for _, artist := range container.Artists.Items {
+			addedAt, ok := firstSeen[artist.ID]
+			if !ok {
+				addedAt = syncedAt
+			}
+			items = append(items, LibraryItem{ID: artist.ID, AddedAt: addedAt})
+		}
+		service.catalog(nil, container.Artists.Items, nil)
+		URL = container.Artists.Next
+	}
+	return items, nil
+}
+
+// diff ids of two library item lists
+func diffLibraryItems(previous []LibraryItem, current []LibraryItem) ([]string, []string) {
+	previousIDs := map[string]bool{}
+	for _, item := range previous {
+		previousIDs[item.ID] = true
+	}
+	currentIDs := map[string]bool{}
+	added := []string{}
+	for _, item := range current {
+		currentIDs[item.ID] = true
+		if !previousIDs[item.ID] {
+			added = append(added, item.ID)
+		}
+	}
+	removed := []string{}
+	for _, item := range previous {
+		if !currentIDs[item.ID] {
+			removed = append(removed, item.ID)
+		}
+	}
+	return added, removed
+}
+
+// syncLibraryKind - snapshot one kind of user's library and diff it with the previous snapshot
+func (service *Service) syncLibraryKind(userID string, kind string) (*LibrarySnapshot, error) {
+	previous, err := service.storage.GetLatestLibrarySnapshot(userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	syncedAt := time.Now()
+	var items []LibraryItem
+	switch kind {
+	case LibraryTracks:
+		items, err = service.fetchSavedTracks(userID)
+	case LibraryAlbums:
+		items, err = service.fetchSavedAlbums(userID)
+	case LibraryArtists:
+		items, err = service.fetchFollowedArtists(userID, previous, syncedAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := LibrarySnapshot{
+		UserID:   userID,
+		Kind:     kind,
+		SyncedAt: syncedAt,
+		Count:    len(items),
+		Added:    []string{},
+		Removed:  []string{},
+		Items:    items,
+	}
+	if previous != nil {
+		snapshot.Added, snapshot.Removed = diffLibraryItems(previous.Items, items)
+	}
+	if err := service.storage.SaveLibrarySnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// SyncLibrary - snapshot saved tracks, saved albums and followed artists of user,
+// returned snapshots carry the diff only
+func (service *Service) SyncLibrary(userID string) ([]LibrarySnapshot, error) {
+	snapshots := []LibrarySnapshot{}
+	for _, kind := range libraryKinds {
+		snapshot, err := service.syncLibraryKind(userID, kind)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Items = nil
+		snapshots = append(snapshots, *snapshot)
+	}
+	return snapshots, nil
+}
+
+// GetLibrary - page of latest library snapshot, newest items first, joined with catalog entries
+func (service *Service) GetLibrary(userID string, kind string, limit int, offset int) (*LibraryPage, error) {
+	if err := validateLibraryKind(kind); err != nil {
+		return nil, err
+	}
+	snapshot, err := service.storage.GetLatestLibrarySnapshot(userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, newArgumentError("library hasn't been synced yet")
+	}
+	items := snapshot.Items
+	sort.SliceStable(items, func(i, j int) bool { return items[i].AddedAt.After(items[j].AddedAt) })
+	page := &LibraryPage{
+		Kind:     kind,
+		SyncedAt: snapshot.SyncedAt,
+		Total:    len(items),
+		Limit:    limit,
+		Offset:   offset,
+		Items:    []LibraryEntry{},
+	}
+	if offset >= len(items) {
+		return page, nil
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	// library sync keeps catalog populated, stale entries are fine for listing
+	switch kind {
+	case LibraryTracks:
+		tracks, err := service.storage.GetTracks(ids, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		byID := map[string]Track{}
+		for _, track := range tracks {
+			byID[track.ID] = track
+		}
+		for _, item := range items {
+			track := byID[item.ID]
+			page.Items = append(page.Items, LibraryEntry{AddedAt: item.AddedAt, Track: &track})
+		}
+	case LibraryAlbums:
+		albums, err := service.storage.GetAlbums(ids, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		byID := map[string]Album{}
+		for _, album := range albums {
+			byID[album.ID] = album
+		}
+		for _, item := range items {
+			album := byID[item.ID]
+			page.Items = append(page.Items, LibraryEntry{AddedAt: item.AddedAt, Album: &album})
+		}
+	case LibraryArtists:
+		artists, err := service.storage.GetArtists(ids, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		byID := map[string]Artist{}
+		for _, artist := range artists {
+			byID[artist.ID] = artist
+		}
+		for _, item := range items {
+			artist := byID[item.ID]
+			page.Items = append(page.Items, LibraryEntry{AddedAt: item.AddedAt, Artist: &artist})
+		}
+	}
+	return page, nil
+}
+
+// GetLibraryGrowth - cumulative library size per month items were added in and changes of every sync
+func (service *Service) GetLibraryGrowth(userID string, kind string) (*LibraryGrowth, error) {
+	if err := validateLibraryKind(kind); err != nil {
+		return nil, err
+	}
+	growth := &LibraryGrowth{Kind: kind, Months: []LibraryGrowthMonth{}, Syncs: []LibrarySnapshot{}}
+	snapshot, err := service.storage.GetLatestLibrarySnapshot(userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return growth, nil
+	}
+	added := map[string]int{}
+	for _, item := range snapshot.Items {
+		added[item.AddedAt.UTC().Format("2006-01")]++
+	}
+	months := make([]string, 0, len(added))
+	for month := range added {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	total := 0
+	for _, month := range months {
+		total += added[month]
+		growth.Months = append(growth.Months, LibraryGrowthMonth{Month: month, Added: added[month], Total: total})
+	}
+	growth.Syncs, err = service.storage.GetLibraryHistory(userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	return growth, nil
+}
+
+// endpoint and batch size of library writes
+func libraryWriteURL(kind string) (string, int) {
+	switch kind {
+	case LibraryAlbums:
+		return os.Getenv("SPOTIFY_SAVED_ALBUMS") + "?", 20
+	case LibraryArtists:
+		return os.Getenv("SPOTIFY_FOLLOWING") + "?type=artist&", 50
+	}
+	return os.Getenv("SPOTIFY_SAVED_TRACKS") + "?", 50
+}
+
+func (service *Service) writeLibrary(userID string, method string, kind string, ids []string) error {
+	if err := validateLibraryKind(kind); err != nil {
+		return err
+	}
+	nonEmpty := []string{}
+	for _, id := range ids {
+		if id != "" {
+			nonEmpty = append(nonEmpty, id)
+		}
+	}
+	ids = nonEmpty
+	if len(ids) == 0 {
+		return newArgumentError("ids expected")
+	}
+	URL, batchSize := libraryWriteURL(kind)
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		_, err := service.callSpotify(userID, method, URL+"ids="+url.QueryEscape(strings.Join(ids[start:end], ",")), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveToLibrary - save tracks or albums, or follow artists
+func (service *Service) SaveToLibrary(userID string, kind string, ids []string) error {
+	return service.writeLibrary(userID, "PUT", kind, ids)
+}
+
+// RemoveFromLibrary - remove saved tracks or albums, or unfollow artists
+func (service *Service) RemoveFromLibrary(userID string, kind string, ids []string) error {
+	return service.writeLibrary(userID, "DELETE", kind, ids)
+}
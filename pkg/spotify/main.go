@@ -1,14 +1,22 @@
 package spotify
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"time"
+	"utilserver/pkg/logging"
+	"utilserver/pkg/ratelimit"
+	"utilserver/pkg/tracing"
 
 	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// CustomClaims - claims of session token, subject is the internal user id
 type CustomClaims struct {
-	Email string `json:"email"`
 	jwt.RegisteredClaims
 }
 
@@ -16,62 +24,287 @@ type CustomClaims struct {
 
 type Storage interface {
 	CreateOrUpdateProfile(profile Profile) (*Profile, error)
-	GetProfileWithEmail(email string) (*Profile, error)
-	UpdateCredentials(email string, credentials *Credentials) (*Profile, error)
+	GetProfile(userID string) (*Profile, error)
+	UpdateCredentials(userID string, credentials *Credentials) (*Profile, error)
+	ListProfileUserIDs() ([]string, error)
+	DeleteProfile(userID string) error
+	AppendAuditRecord(record AuditRecord) (*AuditRecord, error)
+	CatalogStorage
+	LibraryStorage
+	PlaylistStorage
+	ExportStorage
+	HistoryStorage
+}
+
+// HistoryStorage - plays of user's listening history
+type HistoryStorage interface {
+	SavePlays(plays []Play) error
+	GetPlays(userID string, from time.Time, to time.Time) ([]Play, error)
+	GetPendingPlays(limit int) ([]Play, error)
+	UpdatePlays(plays []Play) error
+	GetPlaysPage(userID string, from time.Time, to time.Time, limit int, offset int) ([]Play, int64, error)
+	DeletePlays(userID string) error
+}
+
+// ExportStorage - export jobs and their archives
+type ExportStorage interface {
+	CreateExport(job Export) (*Export, error)
+	UpdateExport(job Export) error
+	ClaimExport(staleBefore time.Time) (*Export, error)
+	GetExport(userID string, id string) (*Export, error)
+	SaveExportArchive(name string, archive io.Reader) (primitive.ObjectID, error)
+	OpenExportArchive(fileID primitive.ObjectID) (io.ReadCloser, error)
+	DeleteExpiredExports(before time.Time) (int, error)
+	DeleteExports(userID string) error
+}
+
+// PlaylistStorage - versioned copies of user's playlists
+type PlaylistStorage interface {
+	SavePlaylistVersion(version PlaylistVersion) error
+	GetLatestPlaylistVersion(userID string, playlistID string) (*PlaylistVersion, error)
+	GetPlaylistVersion(userID string, playlistID string, version int) (*PlaylistVersion, error)
+	GetPlaylistVersions(userID string, playlistID string) ([]PlaylistVersion, error)
+	DeletePlaylistVersions(userID string) error
+}
+
+// LibraryStorage - snapshots of user's saved tracks, saved albums and followed artists
+type LibraryStorage interface {
+	SaveLibrarySnapshot(snapshot LibrarySnapshot) error
+	GetLatestLibrarySnapshot(userID string, kind string) (*LibrarySnapshot, error)
+	GetLibraryHistory(userID string, kind string) ([]LibrarySnapshot, error)
+	DeleteLibrarySnapshots(userID string) error
+}
+
+// CatalogStorage - local copy of spotify artists, albums and tracks
+type CatalogStorage interface {
+	UpsertArtists(artists []Artist) error
+	UpsertAlbums(albums []Album) error
+	UpsertTracks(tracks []Track) error
+	GetArtists(ids []string, fetchedAfter time.Time) ([]Artist, error)
+	GetAlbums(ids []string, fetchedAfter time.Time) ([]Album, error)
+	GetTracks(ids []string, fetchedAfter time.Time) ([]Track, error)
+	FindTrack(name string, artistName string) (*Track, error)
+	SetCatalogLinks(kind string, id string, field string, ids []string) error
+	GetCatalogLinks(kind string, id string, field string, fetchedAfter time.Time) ([]string, error)
 }
 
 type Cache interface {
 	Get(key string) (interface{}, error)
+	Lookup(key string) (string, bool, error)
 	Set(key string, value interface{}, expiration time.Duration) error
+	SetIfGreater(key string, value int64, expiration time.Duration) (bool, error)
 	Clear(key string) error
+	ClearPrefix(prefix string) error
+	TakeTokens(key string, bucket ratelimit.Bucket, tokens float64, reserve float64) (ratelimit.Result, error)
 }
 
-type HTTPClient interface {
-	Request(methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error)
+// Publisher - receiver of events about users, like webhooks
+type Publisher interface {
+	Publish(userID string, event string, data interface{})
 }
 
-type LoginResponse struct {
-	Token   string   `json:"token"`
-	Profile *Profile `json:"profile"`
+type HTTPClient interface {
+	RequestWithContext(ctx context.Context, methodType string, URL string, body map[string]interface{}, contentType string, auth string) (*http.Response, error)
 }
 
 type Services struct {
 	Auth         AuthService
 	PersonalInfo PersonalInfoService
 	General      GeneralService
+	Player       PlayerService
+	Search       SearchService
+	Catalog      CatalogService
+	Library      LibraryService
+	Playlists    PlaylistService
+	Export       ExportService
+	History      HistoryService
 }
 
 // AuthService - functions implemented
 type AuthService interface {
-	Login(email string) (*Profile, error)
-	AuthCallback(authorizationCode string) (*LoginResponse, error)
+	Login(userID string) (*Profile, error)
+	FetchProfile(authorizationCode string) (*Profile, error)
+	SaveProfile(profile Profile, userID string) error
+	DisconnectProfile(userID string) error
 	GetCredentials(authorizationCode string) (*Credentials, error)
-	GetValidToken(email string) (*Credentials, error)
+	GetValidToken(userID string) (*Credentials, error)
 	GetProfileFromSpotify(accessToken string) (*Profile, error)
+	RevokeSessions(userID string) error
+	SessionRevoked(userID string, issuedAt time.Time) (bool, error)
+	DeleteAccount(userID string, others ...ErasedData) error
 }
 
 type PersonalInfoService interface {
-	GetRecentlyPlayed(email string, limit int, before string, after string) (*[]byte, error)
-	GetPersonalAudioFeatures(email string, timespan string) (*[]byte, error)
-	GetTopArtistsOrTracks(email string, top string, timeRange string, limit int, offset int) (*[]byte, error)
-	GetUserPlaylists(email string, limit int, offset int) (*[]byte, error)
+	GetRecentlyPlayed(userID string, limit int, before string, after string) (*[]byte, error)
+	GetPersonalAudioFeatures(userID string, timespan string) (*[]byte, error)
+	GetTopArtistsOrTracks(userID string, top string, timeRange string, limit int, offset int) (*[]byte, error)
+	GetUserPlaylists(userID string, limit int, offset int) (*[]byte, error)
+	GetGenreBreakdown(userID string, timeRange string) (*GenreBreakdown, error)
 }
 
 type GeneralService interface {
-	GetTracksAudioFeatures(email string, trackIDs []string) (*[]byte, error)
+	GetTracksAudioFeatures(userID string, trackIDs []string) (*[]byte, error)
+}
+
+type SearchService interface {
+	Search(userID string, query SearchQuery) (*SearchResult, error)
+}
+
+// CatalogService - lookups of artists, albums and tracks served from local catalog while fresh
+type CatalogService interface {
+	GetArtist(userID string, id string) (*Artist, error)
+	GetArtists(userID string, ids []string) ([]Artist, error)
+	GetAlbum(userID string, id string) (*Album, error)
+	GetAlbums(userID string, ids []string) ([]Album, error)
+	GetTrack(userID string, id string) (*Track, error)
+	GetTracks(userID string, ids []string) ([]Track, error)
+	GetArtistDetail(userID string, id string, market string) (*ArtistDetail, error)
+	GetAlbumDetail(userID string, id string) (*AlbumDetail, error)
+	GetRelatedArtistGraph(userID string, timeRange string, seeds int, hops int, fanout int) (*ArtistGraph, error)
+}
+
+// LibraryService - sync and edit user's saved tracks, saved albums and followed artists
+type LibraryService interface {
+	SyncLibrary(userID string) ([]LibrarySnapshot, error)
+	GetLibrary(userID string, kind string, limit int, offset int) (*LibraryPage, error)
+	GetLibraryGrowth(userID string, kind string) (*LibraryGrowth, error)
+	SaveToLibrary(userID string, kind string, ids []string) error
+	RemoveFromLibrary(userID string, kind string, ids []string) error
+}
+
+// PlaylistService - playlist snapshots, change history and restore
+type PlaylistService interface {
+	SnapshotPlaylists(userID string) (int, error)
+	SnapshotAllPlaylists() error
+	GetPlaylistHistory(userID string, playlistID string) (*PlaylistHistory, error)
+	RestorePlaylist(userID string, playlistID string, version int) (*PlaylistVersion, error)
+}
+
+// HistoryService - listening history collected from the api and imported from privacy exports
+type HistoryService interface {
+	ImportHistory(userID string, plays []Play) (*HistoryImport, error)
+	IngestRecentlyPlayed(userID string) ([]Play, error)
+	IngestAllRecentlyPlayed(listeners ...PlaysListener) error
+	ResolvePendingPlays() error
+	GetHistory(userID string, from time.Time, to time.Time, limit int, offset int) (*HistoryPage, error)
+}
+
+// ExportService - asynchronous export of user's data as downloadable archive
+type ExportService interface {
+	CreateExport(userID string, format string) (*Export, error)
+	GetExport(userID string, id string) (*Export, error)
+	OpenExportArchive(userID string, id string) (*Export, io.ReadCloser, error)
+	RunPendingExports() error
+	DeleteExpiredExports() error
+}
+
+// PlayerService - remote control of user's spotify playback
+type PlayerService interface {
+	GetPlaybackState(userID string, market string) (*[]byte, error)
+	GetCurrentlyPlaying(userID string, market string) (*[]byte, error)
+	GetDevices(userID string) (*[]byte, error)
+	TransferPlayback(userID string, deviceIDs []string, play bool) error
+	Play(userID string, deviceID string, options PlayOptions) error
+	Pause(userID string, deviceID string) error
+	SkipToNext(userID string, deviceID string) error
+	SkipToPrevious(userID string, deviceID string) error
+	Seek(userID string, positionMs int, deviceID string) error
+	SetVolume(userID string, volumePercent int, deviceID string) error
+	SetShuffle(userID string, state bool, deviceID string) error
+	SetRepeat(userID string, state string, deviceID string) error
+	GetQueue(userID string) (*[]byte, error)
+	AddToQueue(userID string, uri string, deviceID string) error
 }
 
 type Service struct {
 	storage    Storage
 	httpClient HTTPClient
 	cache      Cache
+	publisher  Publisher
+	logger     *logging.Logger
+	// ctx - context of request the service works for, carries its trace
+	ctx      context.Context
+	priority Priority
+}
+
+// New - return map of both serivces, publisher may be nil
+func NewServices(storage Storage, httpClient HTTPClient, cache Cache, publisher Publisher, logger *logging.Logger) Services {
+	return newServices(&Service{storage: storage, httpClient: httpClient, cache: cache, publisher: publisher, logger: logger})
 }
 
-// New - return map of both serivces
-func NewServices(storage Storage, httpClient HTTPClient, cache Cache) Services {
+func newServices(service *Service) Services {
 	return Services{
-		Auth:         &Service{storage, httpClient, cache},
-		PersonalInfo: &Service{storage, httpClient, cache},
-		General:      &Service{storage, httpClient, cache},
+		Auth:         service,
+		PersonalInfo: service,
+		General:      service,
+		Player:       service,
+		Search:       service,
+		Catalog:      service,
+		Library:      service,
+		Playlists:    service,
+		Export:       service,
+		History:      service,
+	}
+}
+
+// WithLogger - services logging through logger, used to tag logs of a request with its id and user
+func (services Services) WithLogger(logger *logging.Logger) Services {
+	service, ok := services.Auth.(*Service)
+	if !ok {
+		return services
+	}
+	return newServices(service.withLogger(logger))
+}
+
+func (service *Service) withLogger(logger *logging.Logger) *Service {
+	scoped := *service
+	scoped.logger = logger
+	return &scoped
+}
+
+// WithPriority - services drawing on spotify quota of the app with priority
+func (services Services) WithPriority(priority Priority) Services {
+	service, ok := services.Auth.(*Service)
+	if !ok {
+		return services
 	}
+	scoped := *service
+	scoped.priority = priority
+	return newServices(&scoped)
+}
+
+// WithContext - services tracing their calls as part of request of ctx, calls aren't
+// cancelled with ctx so work started by the request may outlive it
+func (services Services) WithContext(ctx context.Context) Services {
+	service, ok := services.Auth.(*Service)
+	if !ok {
+		return services
+	}
+	return newServices(service.withContext(ctx))
+}
+
+func (service *Service) withContext(ctx context.Context) *Service {
+	scoped := *service
+	scoped.ctx = tracing.Detach(ctx)
+	scoped.storage = tracing.Bind(service.storage, ctx).(Storage)
+	scoped.cache = tracing.Bind(service.cache, ctx).(Cache)
+	return &scoped
+}
+
+func (service *Service) context() context.Context {
+	if service.ctx == nil {
+		return context.Background()
+	}
+	return service.ctx
+}
+
+// span - service whose calls are traced as children of new span of operation
+func (service *Service) span(operation string, attributes ...attribute.KeyValue) (*Service, trace.Span) {
+	ctx, span := tracing.Start(service.context(), "spotify."+operation, trace.WithAttributes(attributes...))
+	return service.withContext(ctx), span
+}
+
+// forUser - service tagging its logs with user, for work done outside of requests
+func (service *Service) forUser(userID string) *Service {
+	return service.withLogger(service.logger.With("user_id", userID))
 }
@@ -3,42 +3,45 @@ package spotify
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"os"
 	"strconv"
+	"utilserver/pkg/tracing"
 )
 
+// TopQueryValidator - check type or time_range of top items query, omitted values get the
+// default of spotify and unknown ones are rejected
 func TopQueryValidator(e string, paramType string) (string, error) {
 	topType := [...]string{"tracks", "artists"}
 	timeRange := [...]string{"short_term", "medium_term", "long_term"}
 	switch paramType {
 	case "type":
+		if e == "" {
+			return "tracks", nil
+		}
 		for _, v := range topType {
 			if v == e {
 				return e, nil
 			}
 		}
-		return "tracks", nil
+		return "", newArgumentError("type must be one of tracks, artists")
 	case "time_range":
+		if e == "" {
+			return "medium_term", nil
+		}
 		for _, v := range timeRange {
 			if v == e {
 				return e, nil
 			}
 		}
-		return "medium_term", nil
+		return "", newArgumentError("time_range must be one of short_term, medium_term, long_term")
 	}
 	return "", errors.New("incorrect format parameter")
 }
 
 func (service *Service) GetRecentlyPlayed(
-	email string, limit int,
+	userID string, limit int,
 	before string, after string,
 ) (*[]byte, error) {
-	credentials, err := service.GetValidToken(email)
-	if err != nil {
-		return nil, err
-	}
 	URL := "https://api.spotify.com/v1/me/player/recently-played"
 	if limit != 0 {
 		URL = URL + "?limit=" + strconv.Itoa(limit)
@@ -49,34 +52,21 @@ func (service *Service) GetRecentlyPlayed(
 	if after != "-6795364578871" {
 		URL = URL + "&after=" + after
 	}
-	recentlyPlayedResp, err := service.httpClient.Request(
-		"GET",
-		URL,
-		nil,
-		"application/json",
-		"Bearer "+credentials.AccessToken,
-	)
+	recentlyPlayed, err := service.callSpotifyStale(userID, URL)
 	if err != nil {
 		return nil, err
 	}
-	defer recentlyPlayedResp.Body.Close()
-
-	recentlyPlayed, err := ioutil.ReadAll(recentlyPlayedResp.Body)
-	if err != nil {
-		return nil, err
-	}
-	return &recentlyPlayed, nil
+	service.catalogRecentlyPlayed(*recentlyPlayed)
+	return recentlyPlayed, nil
 }
 
-func (service *Service) GetTopArtistsOrTracks(email string,
+func (service *Service) GetTopArtistsOrTracks(userID string,
 	top string,
 	timeRangeStr string,
 	limit int,
 	offset int) (*[]byte, error) {
-	credentials, err := service.GetValidToken(email)
-	if err != nil {
-		return nil, err
-	}
+	service, span := service.span("GetTopArtistsOrTracks", tracing.UserID(userID))
+	defer span.End()
 	toptype, err := TopQueryValidator(top, "type")
 	if err != nil {
 		return nil, err
@@ -89,57 +79,30 @@ func (service *Service) GetTopArtistsOrTracks(email string,
 		"?limit=" + strconv.Itoa(limit) +
 		"&offset=" + strconv.Itoa(offset) +
 		"&time_range=" + timeRange
-	topResp, err := service.httpClient.Request(
-		"GET",
-		URL,
-		nil,
-		"application/json",
-		"Bearer "+credentials.AccessToken,
-	)
+	topContainer, err := service.callSpotifyStale(userID, URL)
 	if err != nil {
 		return nil, err
 	}
-	defer topResp.Body.Close()
-
-	topContainer, err := ioutil.ReadAll(topResp.Body)
-	if err != nil {
-		return nil, err
+	service.catalogTopItems(toptype, *topContainer)
+	if offset == 0 {
+		service.detectTopItemsChange(userID, toptype, timeRange, *topContainer)
 	}
-	return &topContainer, nil
+	return topContainer, nil
 }
 
 // get user's palylists
-func (service *Service) GetUserPlaylists(email string, limit int, offset int) (*[]byte, error) {
-	credentials, err := service.GetValidToken(email)
-	if err != nil {
-		return nil, err
-	}
+func (service *Service) GetUserPlaylists(userID string, limit int, offset int) (*[]byte, error) {
 	URL := os.Getenv("SPOTIFY_PERSONAL_PLAYLISTS") +
 		"?limit=" + strconv.Itoa(limit) +
 		"&offset=" + strconv.Itoa(offset)
-	fmt.Println(URL)
-	playlistsResp, err := service.httpClient.Request(
-		"GET",
-		URL,
-		nil,
-		"application/json",
-		"Bearer "+credentials.AccessToken,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer playlistsResp.Body.Close()
-
-	playlists, err := ioutil.ReadAll(playlistsResp.Body)
-	if err != nil {
-		return nil, err
-	}
-	return &playlists, nil
+	return service.callSpotifyStale(userID, URL)
 }
 
 // get Top Tracks
-func (service *Service) GetPersonalAudioFeatures(email string, timespan string) (*[]byte, error) {
-	tracksByteArray, err := service.GetTopArtistsOrTracks(email, "tracks", timespan, 50, 0)
+func (service *Service) GetPersonalAudioFeatures(userID string, timespan string) (*[]byte, error) {
+	service, span := service.span("GetPersonalAudioFeatures", tracing.UserID(userID))
+	defer span.End()
+	tracksByteArray, err := service.GetTopArtistsOrTracks(userID, "tracks", timespan, 50, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -153,15 +116,27 @@ func (service *Service) GetPersonalAudioFeatures(email string, timespan string)
 	if (err) != nil {
 		return nil, err
 	}
+	audioFeaturesSum, err := service.averageAudioFeatures(userID, topItemsResp.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	// marshall audioFeaturesSum to byte array
+	audioFeaturesSumByteArray, err := json.Marshal(audioFeaturesSum)
+	return &audioFeaturesSumByteArray, err
+}
+
+// averageAudioFeatures - mean of audio features of tracks
+func (service *Service) averageAudioFeatures(userID string, tracks []Track) (AudioFeatures, error) {
 	// loop through tracks and concat id separated by ","
 	var trackIds []string
-	for _, track := range topItemsResp.Items {
+	for _, track := range tracks {
 		trackIds = append(trackIds, track.ID)
 	}
 
-	audioFeaturesByteArray, err := service.GetTracksAudioFeatures(email, trackIds)
+	audioFeaturesByteArray, err := service.GetTracksAudioFeatures(userID, trackIds)
 	if err != nil {
-		return nil, err
+		return AudioFeatures{}, err
 	}
 
 	type AudioFeaturesResp struct {
@@ -171,7 +146,7 @@ func (service *Service) GetPersonalAudioFeatures(email string, timespan string)
 	var audioFeaturesResep AudioFeaturesResp
 	err = json.Unmarshal(*audioFeaturesByteArray, &audioFeaturesResep)
 	if (err) != nil {
-		return nil, err
+		return AudioFeatures{}, err
 	}
 	// loop through audioFeatures and sum integer and float and float fields and reduce to one audio feature
 	var audioFeaturesSum AudioFeatures
@@ -198,8 +173,5 @@ func (service *Service) GetPersonalAudioFeatures(email string, timespan string)
 	audioFeaturesSum.Valence /= float64(len(audioFeaturesResep.AudioFeatures))
 	audioFeaturesSum.Tempo /= float64(len(audioFeaturesResep.AudioFeatures))
 	audioFeaturesSum.TimeSignature /= int(len(audioFeaturesResep.AudioFeatures))
-
-	// marshall audioFeaturesSum to byte array
-	audioFeaturesSumByteArray, err := json.Marshal(audioFeaturesSum)
-	return &audioFeaturesSumByteArray, err
+	return audioFeaturesSum, nil
 }
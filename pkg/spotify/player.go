@@ -0,0 +1,174 @@
+package spotify
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PlayOffset - where playback should start in a context
+type PlayOffset struct {
+	Position *int   `json:"position,omitempty"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// PlayOptions - body of start/resume playback request
+type PlayOptions struct {
+	ContextURI string      `json:"context_uri,omitempty"`
+	URIs       []string    `json:"uris,omitempty"`
+	Offset     *PlayOffset `json:"offset,omitempty"`
+	PositionMs int         `json:"position_ms,omitempty"`
+}
+
+// build player endpoint url with optional query parameters, empty values are skipped
+func playerURL(path string, params map[string]string) string {
+	URL := os.Getenv("SPOTIFY_PLAYER") + path
+	query := url.Values{}
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if len(query) > 0 {
+		URL = URL + "?" + query.Encode()
+	}
+	return URL
+}
+
+// GetPlaybackState - current playback state including device, progress, shuffle and repeat
+func (service *Service) GetPlaybackState(userID string, market string) (*[]byte, error) {
+	resp, err := service.callSpotify(userID, "GET", playerURL("", map[string]string{"market": market}), nil)
+	if err != nil {
+		return nil, err
+	}
+	service.catalogPlayback(*resp)
+	return resp, nil
+}
+
+// GetCurrentlyPlaying - track or episode currently playing on user's account
+func (service *Service) GetCurrentlyPlaying(userID string, market string) (*[]byte, error) {
+	resp, err := service.callSpotify(userID, "GET", playerURL("/currently-playing", map[string]string{"market": market}), nil)
+	if err != nil {
+		return nil, err
+	}
+	service.catalogPlayback(*resp)
+	return resp, nil
+}
+
+// GetDevices - devices available for playback
+func (service *Service) GetDevices(userID string) (*[]byte, error) {
+	return service.callSpotify(userID, "GET", playerURL("/devices", nil), nil)
+}
+
+// TransferPlayback - move playback to given device
+func (service *Service) TransferPlayback(userID string, deviceIDs []string, play bool) error {
+	if len(deviceIDs) != 1 {
+		return newArgumentError("exactly one device id expected")
+	}
+	_, err := service.callSpotify(userID, "PUT", playerURL("", nil), map[string]interface{}{
+		"device_ids": deviceIDs,
+		"play":       play,
+	})
+	return err
+}
+
+// Play - start new context or resume current playback
+func (service *Service) Play(userID string, deviceID string, options PlayOptions) error {
+	if options.ContextURI != "" && len(options.URIs) > 0 {
+		return newArgumentError("context_uri and uris can't be used together")
+	}
+	body, err := toBody(options)
+	if err != nil {
+		return err
+	}
+	_, err = service.callSpotify(userID, "PUT", playerURL("/play", map[string]string{"device_id": deviceID}), body)
+	return err
+}
+
+// Pause - pause playback
+func (service *Service) Pause(userID string, deviceID string) error {
+	_, err := service.callSpotify(userID, "PUT", playerURL("/pause", map[string]string{"device_id": deviceID}), nil)
+	return err
+}
+
+// SkipToNext - skip to next track in user's queue
+func (service *Service) SkipToNext(userID string, deviceID string) error {
+	_, err := service.callSpotify(userID, "POST", playerURL("/next", map[string]string{"device_id": deviceID}), nil)
+	return err
+}
+
+// SkipToPrevious - skip to previous track in user's queue
+func (service *Service) SkipToPrevious(userID string, deviceID string) error {
+	_, err := service.callSpotify(userID, "POST", playerURL("/previous", map[string]string{"device_id": deviceID}), nil)
+	return err
+}
+
+// Seek - seek to position in currently playing track
+func (service *Service) Seek(userID string, positionMs int, deviceID string) error {
+	if positionMs < 0 {
+		return newArgumentError("position_ms must be positive")
+	}
+	_, err := service.callSpotify(userID, "PUT", playerURL("/seek", map[string]string{
+		"position_ms": strconv.Itoa(positionMs),
+		"device_id":   deviceID,
+	}), nil)
+	return err
+}
+
+// SetVolume - set volume of the device
+func (service *Service) SetVolume(userID string, volumePercent int, deviceID string) error {
+	if volumePercent < 0 || volumePercent > 100 {
+		return newArgumentError("volume_percent must be between 0 and 100")
+	}
+	_, err := service.callSpotify(userID, "PUT", playerURL("/volume", map[string]string{
+		"volume_percent": strconv.Itoa(volumePercent),
+		"device_id":      deviceID,
+	}), nil)
+	return err
+}
+
+// SetShuffle - toggle shuffle on user's playback
+func (service *Service) SetShuffle(userID string, state bool, deviceID string) error {
+	_, err := service.callSpotify(userID, "PUT", playerURL("/shuffle", map[string]string{
+		"state":     strconv.FormatBool(state),
+		"device_id": deviceID,
+	}), nil)
+	return err
+}
+
+// SetRepeat - set repeat mode, one of track, context or off
+func (service *Service) SetRepeat(userID string, state string, deviceID string) error {
+	repeatStates := [...]string{"track", "context", "off"}
+	valid := false
+	for _, v := range repeatStates {
+		if v == state {
+			valid = true
+		}
+	}
+	if !valid {
+		return newArgumentError("state must be one of " + strings.Join(repeatStates[:], ", "))
+	}
+	_, err := service.callSpotify(userID, "PUT", playerURL("/repeat", map[string]string{
+		"state":     state,
+		"device_id": deviceID,
+	}), nil)
+	return err
+}
+
+// GetQueue - currently playing item and user's queue
+func (service *Service) GetQueue(userID string) (*[]byte, error) {
+	return service.callSpotify(userID, "GET", playerURL("/queue", nil), nil)
+}
+
+// AddToQueue - add track or episode uri to the end of user's queue
+func (service *Service) AddToQueue(userID string, uri string, deviceID string) error {
+	if uri == "" {
+		return newArgumentError("uri expected")
+	}
+	_, err := service.callSpotify(userID, "POST", playerURL("/queue", map[string]string{
+		"uri":       uri,
+		"device_id": deviceID,
+	}), nil)
+	return err
+}
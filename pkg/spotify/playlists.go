@@ -0,0 +1,396 @@
+package spotify
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"utilserver/pkg/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PlaylistTrack struct {
+	URI     string    `bson:"uri" json:"uri"`
+	ID      string    `bson:"id" json:"id"`
+	Name    string    `bson:"name" json:"name"`
+	AddedAt time.Time `bson:"added_at" json:"added_at"`
+	AddedBy string    `bson:"added_by" json:"added_by"`
+	IsLocal bool      `bson:"is_local" json:"is_local"`
+}
+
+// PlaylistVersion - copy of playlist metadata and track list captured when its snapshot id changed
+type PlaylistVersion struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID        string             `bson:"user_id" json:"-"`
+	PlaylistID    string             `bson:"playlist_id" json:"playlist_id"`
+	Version       int                `bson:"version" json:"version"`
+	SnapshotID    string             `bson:"snapshot_id" json:"snapshot_id"`
+	CapturedAt    time.Time          `bson:"captured_at" json:"captured_at"`
+	Name          string             `bson:"name" json:"name"`
+	Description   string             `bson:"description" json:"description"`
+	Public        bool               `bson:"public" json:"public"`
+	Collaborative bool               `bson:"collaborative" json:"collaborative"`
+	Owner         PlaylistOwner      `bson:"owner" json:"owner"`
+	Tracks        []PlaylistTrack    `bson:"tracks" json:"tracks,omitempty"`
+}
+
+type PlaylistTrackChange struct {
+	Track    PlaylistTrack `json:"track"`
+	Position int           `json:"position"`
+}
+
+type PlaylistTrackMove struct {
+	Track PlaylistTrack `json:"track"`
+	From  int           `json:"from"`
+	To    int           `json:"to"`
+}
+
+// PlaylistChange - difference of a version from the one before it
+type PlaylistChange struct {
+	Version         int                   `json:"version"`
+	SnapshotID      string                `json:"snapshot_id"`
+	CapturedAt      time.Time             `json:"captured_at"`
+	Name            string                `json:"name"`
+	TrackCount      int                   `json:"track_count"`
+	MetadataChanged []string              `json:"metadata_changed"`
+	Added           []PlaylistTrackChange `json:"added"`
+	Removed         []PlaylistTrackChange `json:"removed"`
+	Reordered       []PlaylistTrackMove   `json:"reordered"`
+}
+
+type PlaylistHistory struct {
+	PlaylistID string           `json:"playlist_id"`
+	Versions   []PlaylistChange `json:"versions"`
+}
+
+// occurrence of a track in a list, tracks can be added to a playlist several times
+type trackOccurrence struct {
+	uri string
+	nth int
+}
+
+func occurrences(tracks []PlaylistTrack) []trackOccurrence {
+	seen := map[string]int{}
+	list := make([]trackOccurrence, len(tracks))
+	for i, track := range tracks {
+		list[i] = trackOccurrence{uri: track.URI, nth: seen[track.URI]}
+		seen[track.URI]++
+	}
+	return list
+}
+
+// longest increasing subsequence of values, returns indexes of values belonging to it
+func longestIncreasing(values []int) map[int]bool {
+	tails := []int{}
+	previous := make([]int, len(values))
+	for i, value := range values {
+		position := sort.Search(len(tails), func(j int) bool { return values[tails[j]] >= value })
+		if position > 0 {
+			previous[i] = tails[position-1]
+		} else {
+			previous[i] = -1
+		}
+		if position == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[position] = i
+		}
+	}
+	members := map[int]bool{}
+	if len(tails) == 0 {
+		return members
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = previous[i] {
+		members[i] = true
+	}
+	return members
+}
+
+// diffPlaylistVersions - tracks added, removed and moved between versions. tracks kept in both versions
+// which aren't part of the longest run keeping their relative order are reported as reordered
+func diffPlaylistVersions(previous *PlaylistVersion, current *PlaylistVersion) PlaylistChange {
+	change := PlaylistChange{
+		Version:         current.Version,
+		SnapshotID:      current.SnapshotID,
+		CapturedAt:      current.CapturedAt,
+		Name:            current.Name,
+		TrackCount:      len(current.Tracks),
+		MetadataChanged: []string{},
+		Added:           []PlaylistTrackChange{},
+		Removed:         []PlaylistTrackChange{},
+		Reordered:       []PlaylistTrackMove{},
+	}
+	if previous == nil {
+		return change
+	}
+	if previous.Name != current.Name {
+		change.MetadataChanged = append(change.MetadataChanged, "name")
+	}
+	if previous.Description != current.Description {
+		change.MetadataChanged = append(change.MetadataChanged, "description")
+	}
+	if previous.Public != current.Public {
+		change.MetadataChanged = append(change.MetadataChanged, "public")
+	}
+	if previous.Collaborative != current.Collaborative {
+		change.MetadataChanged = append(change.MetadataChanged, "collaborative")
+	}
+
+	currentPositions := map[trackOccurrence]int{}
+	for i, occurrence := range occurrences(current.Tracks) {
+		currentPositions[occurrence] = i
+	}
+	kept := map[trackOccurrence]bool{}
+	keptFrom := []int{}
+	keptTo := []int{}
+	for i, occurrence := range occurrences(previous.Tracks) {
+		position, ok := currentPositions[occurrence]
+		if !ok {
+			change.Removed = append(change.Removed, PlaylistTrackChange{Track: previous.Tracks[i], Position: i})
+			continue
+		}
+		kept[occurrence] = true
+		keptFrom = append(keptFrom, i)
+		keptTo = append(keptTo, position)
+	}
+	for i, occurrence := range occurrences(current.Tracks) {
+		if !kept[occurrence] {
+			change.Added = append(change.Added, PlaylistTrackChange{Track: current.Tracks[i], Position: i})
+		}
+	}
+	inOrder := longestIncreasing(keptTo)
+	for i := range keptTo {
+		if !inOrder[i] {
+			change.Reordered = append(change.Reordered, PlaylistTrackMove{
+				Track: current.Tracks[keptTo[i]],
+				From:  keptFrom[i],
+				To:    keptTo[i],
+			})
+		}
+	}
+	return change
+}
+
+// page through playlists of user
+func (service *Service) fetchUserPlaylists(userID string) ([]Playlist, error) {
+	playlists := []Playlist{}
+	URL := os.Getenv("SPOTIFY_PERSONAL_PLAYLISTS") + "?limit=50"
+	for URL != "" {
+		resp, err := service.callSpotify(userID, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page PlaylistPage
+		if err := json.Unmarshal(*resp, &page); err != nil {
+			return nil, err
+		}
+		for _, playlist := range page.Items {
+			if playlist.ID != "" {
+				playlists = append(playlists, playlist)
+			}
+		}
+		URL = page.Next
+	}
+	return playlists, nil
+}
+
+// page through tracks of playlist, entries without a track are skipped
+func (service *Service) fetchPlaylistTracks(userID string, playlistID string) ([]PlaylistTrack, error) {
+	tracks := []PlaylistTrack{}
+	URL := os.Getenv("SPOTIFY_PLAYLISTS") + "/" + url.PathEscape(playlistID) + "/tracks?limit=100"
+	for URL != "" {
+		resp, err := service.callSpotify(userID, "GET", URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				AddedAt time.Time `json:"added_at"`
+				AddedBy *struct {
+					ID string `json:"id"`
+				} `json:"added_by"`
+				IsLocal bool   `json:"is_local"`
+				Track   *Track `json:"track"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		if err := json.Unmarshal(*resp, &page); err != nil {
+			return nil, err
+		}
+		catalogTracks := []Track{}
+		for _, item := range page.Items {
+			if item.Track == nil || item.Track.URI == "" {
+				continue
+			}
+			track := PlaylistTrack{
+				URI:     item.Track.URI,
+				ID:      item.Track.ID,
+				Name:    item.Track.Name,
+				AddedAt: item.AddedAt,
+				IsLocal: item.IsLocal,
+			}
+			if item.AddedBy != nil {
+				track.AddedBy = item.AddedBy.ID
+			}
+			tracks = append(tracks, track)
+			if item.Track.Type == "track" && !item.IsLocal {
+				catalogTracks = append(catalogTracks, *item.Track)
+			}
+		}
+		service.catalog(catalogTracks, nil, nil)
+		URL = page.Next
+	}
+	return tracks, nil
+}
+
+// snapshotPlaylist - store new version of playlist when its snapshot id changed since last version
+func (service *Service) snapshotPlaylist(userID string, playlist Playlist) (*PlaylistVersion, error) {
+	latest, err := service.storage.GetLatestPlaylistVersion(userID, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.SnapshotID == playlist.SnapshotID {
+		return nil, nil
+	}
+	tracks, err := service.fetchPlaylistTracks(userID, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	version := PlaylistVersion{
+		UserID:        userID,
+		PlaylistID:    playlist.ID,
+		Version:       1,
+		SnapshotID:    playlist.SnapshotID,
+		CapturedAt:    time.Now(),
+		Name:          playlist.Name,
+		Description:   playlist.Description,
+		Public:        playlist.Public,
+		Collaborative: playlist.Collaborative,
+		Owner:         playlist.Owner,
+		Tracks:        tracks,
+	}
+	if latest != nil {
+		version.Version = latest.Version + 1
+	}
+	if err := service.storage.SavePlaylistVersion(version); err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		service.publish(userID, webhooks.EventPlaylistChanged, PlaylistHistory{
+			PlaylistID: playlist.ID,
+			Versions:   []PlaylistChange{diffPlaylistVersions(latest, &version)},
+		})
+	}
+	return &version, nil
+}
+
+// SnapshotPlaylists - capture new versions of user's changed playlists, returns number of new versions
+func (service *Service) SnapshotPlaylists(userID string) (int, error) {
+	playlists, err := service.fetchUserPlaylists(userID)
+	if err != nil {
+		return 0, err
+	}
+	captured := 0
+	for _, playlist := range playlists {
+		version, err := service.snapshotPlaylist(userID, playlist)
+		if err != nil {
+			return captured, err
+		}
+		if version != nil {
+			captured++
+		}
+	}
+	return captured, nil
+}
+
+// SnapshotAllPlaylists - capture playlists of every user, failure of a user doesn't stop the others
+func (service *Service) SnapshotAllPlaylists() error {
+	userIDs, err := service.storage.ListProfileUserIDs()
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := service.forUser(userID).SnapshotPlaylists(userID); err != nil {
+			service.logger.Error("playlist snapshot failed", "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
+
+// GetPlaylistHistory - versions of playlist with their differences from previous version, oldest first
+func (service *Service) GetPlaylistHistory(userID string, playlistID string) (*PlaylistHistory, error) {
+	versions, err := service.storage.GetPlaylistVersions(userID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	history := &PlaylistHistory{PlaylistID: playlistID, Versions: []PlaylistChange{}}
+	var previous *PlaylistVersion
+	for i := range versions {
+		history.Versions = append(history.Versions, diffPlaylistVersions(previous, &versions[i]))
+		previous = &versions[i]
+	}
+	return history, nil
+}
+
+// RestorePlaylist - rewrite playlist with metadata and tracks of an earlier version and capture
+// the result as a new version. local files can't be added through the api and are left out
+func (service *Service) RestorePlaylist(userID string, playlistID string, versionNumber int) (*PlaylistVersion, error) {
+	version, err := service.storage.GetPlaylistVersion(userID, playlistID, versionNumber)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, newArgumentError("playlist version doesn't exist")
+	}
+	URL := os.Getenv("SPOTIFY_PLAYLISTS") + "/" + url.PathEscape(playlistID)
+	_, err = service.callSpotify(userID, "PUT", URL, map[string]interface{}{
+		"name":        version.Name,
+		"description": version.Description,
+		"public":      version.Public,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uris := []string{}
+	for _, track := range version.Tracks {
+		if !track.IsLocal && !strings.HasPrefix(track.URI, "spotify:local:") {
+			uris = append(uris, track.URI)
+		}
+	}
+	// first batch replaces playlist items, the rest is appended
+	for start := 0; start == 0 || start < len(uris); start += 100 {
+		end := start + 100
+		if end > len(uris) {
+			end = len(uris)
+		}
+		method := "POST"
+		if start == 0 {
+			method = "PUT"
+		}
+		_, err := service.callSpotify(userID, method, URL+"/tracks", map[string]interface{}{"uris": uris[start:end]})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := service.callSpotify(userID, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var playlist Playlist
+	if err := json.Unmarshal(*resp, &playlist); err != nil {
+		return nil, err
+	}
+	restored, err := service.snapshotPlaylist(userID, playlist)
+	if err != nil {
+		return nil, err
+	}
+	if restored == nil {
+		return service.storage.GetLatestPlaylistVersion(userID, playlistID)
+	}
+	return restored, nil
+}
@@ -0,0 +1,160 @@
+package spotify
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/ratelimit"
+)
+
+// Priority - class of work drawing on the spotify quota of the app
+type Priority int
+
+const (
+	// PriorityInteractive - requests of users, may use the whole quota
+	PriorityInteractive Priority = iota
+	// PriorityBackground - ingestion, snapshots and other jobs, leave a reserve to interactive requests
+	PriorityBackground
+)
+
+func (priority Priority) String() string {
+	if priority == PriorityBackground {
+		return "background"
+	}
+	return "interactive"
+}
+
+// spotify limits calls of an app over a rolling window, every replica draws from one bucket
+const (
+	quotaKey      = "spotify:quota"
+	quotaPauseKey = "spotify:quota:paused-until"
+	// longest waits for quota before a call is given up
+	maxInteractiveQuotaWait = 5 * time.Second
+	maxBackgroundQuotaWait  = 5 * time.Minute
+)
+
+// ErrRateLimited - spotify quota of the app is used up for longer than the caller can wait.
+// Match with errors.Is
+var ErrRateLimited = errors.New("spotify rate limit reached, try again later")
+
+// RateLimitedError - call given up because of the quota of the app
+type RateLimitedError struct {
+	// RetryAfter - time until the quota has a call again
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+// Is - every rate limited error matches ErrRateLimited
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// quota - bucket of calls shared by replicas, reserve is the share kept for interactive calls
+type quota struct {
+	bucket  ratelimit.Bucket
+	reserve float64
+}
+
+var appQuota quota
+var loadAppQuota sync.Once
+
+// quota of SPOTIFY_QUOTA_LIMIT calls per SPOTIFY_QUOTA_WINDOW_SECONDS with
+// SPOTIFY_QUOTA_INTERACTIVE_SHARE of it reserved for interactive calls
+func getAppQuota() quota {
+	loadAppQuota.Do(func() {
+		limit, err := strconv.Atoi(os.Getenv("SPOTIFY_QUOTA_LIMIT"))
+		if err != nil || limit < 1 {
+			limit = 150
+		}
+		window, err := strconv.Atoi(os.Getenv("SPOTIFY_QUOTA_WINDOW_SECONDS"))
+		if err != nil || window < 1 {
+			window = 30
+		}
+		share, err := strconv.ParseFloat(os.Getenv("SPOTIFY_QUOTA_INTERACTIVE_SHARE"), 64)
+		if err != nil || share < 0 || share >= 1 {
+			share = 0.3
+		}
+		appQuota = quota{
+			bucket:  ratelimit.Bucket{Capacity: float64(limit), Window: time.Duration(window) * time.Second},
+			reserve: float64(limit) * share,
+		}
+	})
+	return appQuota
+}
+
+// acquireQuota - wait for a call of the app quota, fails with *RateLimitedError when the wait
+// would be longer than priority of service allows. Calls aren't held back while redis fails
+func (service *Service) acquireQuota() error {
+	appQuota := getAppQuota()
+	maxWait, reserve := maxInteractiveQuotaWait, 0.0
+	if service.priority == PriorityBackground {
+		maxWait, reserve = maxBackgroundQuotaWait, appQuota.reserve
+	}
+	started := time.Now()
+	deadline := started.Add(maxWait)
+	for {
+		wait, err := service.quotaWait(appQuota, reserve)
+		if err != nil {
+			service.logger.Warn("spotify quota not checked", "error", err)
+			return nil
+		}
+		if wait == 0 {
+			metrics.SpotifyQuotaWait.WithLabelValues(service.priority.String()).Observe(time.Since(started).Seconds())
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			metrics.SpotifyQuotaRejections.WithLabelValues(service.priority.String()).Inc()
+			return &RateLimitedError{RetryAfter: wait}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// wait until a call may be made, zero when a call has been taken from the bucket
+func (service *Service) quotaWait(appQuota quota, reserve float64) (time.Duration, error) {
+	if paused := service.quotaPausedFor(); paused > 0 {
+		return paused, nil
+	}
+	result, err := service.cache.TakeTokens(quotaKey, appQuota.bucket, 1, reserve)
+	if err != nil {
+		return 0, err
+	}
+	if result.Allowed {
+		return 0, nil
+	}
+	return result.RetryAfter, nil
+}
+
+func (service *Service) quotaPausedFor() time.Duration {
+	stored, err := service.cache.Get(quotaPauseKey)
+	if err != nil {
+		return 0
+	}
+	storedStr, _ := stored.(string)
+	until, err := strconv.ParseInt(storedStr, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Until(time.Unix(0, until*int64(time.Millisecond)))
+}
+
+// pauseQuota - hold back calls of every replica for wait spotify asked for, a longer pause
+// set meanwhile by another replica is kept
+func (service *Service) pauseQuota(wait time.Duration) {
+	untilMS := time.Now().Add(wait).UnixNano() / int64(time.Millisecond)
+	paused, err := service.cache.SetIfGreater(quotaPauseKey, untilMS, wait)
+	if err != nil {
+		service.logger.Warn("spotify quota pause not saved", "error", err)
+		return
+	}
+	if paused {
+		metrics.SpotifyQuotaPauses.Inc()
+		service.logger.Warn("spotify quota paused", "retry_after", wait)
+	}
+}
@@ -0,0 +1,94 @@
+package spotify
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SearchQuery - parameters of catalog search
+type SearchQuery struct {
+	Query           string
+	Types           []string
+	Market          string
+	Limit           int
+	Offset          int
+	IncludeExternal string
+}
+
+// SearchResult - typed search response, only requested types are set
+type SearchResult struct {
+	Tracks    *TrackPage    `json:"tracks,omitempty"`
+	Artists   *ArtistPage   `json:"artists,omitempty"`
+	Albums    *AlbumPage    `json:"albums,omitempty"`
+	Playlists *PlaylistPage `json:"playlists,omitempty"`
+}
+
+// Search - search spotify catalog for tracks, artists, albums and playlists
+func (service *Service) Search(userID string, query SearchQuery) (*SearchResult, error) {
+	searchTypes := [...]string{"track", "artist", "album", "playlist"}
+	if query.Query == "" {
+		return nil, newArgumentError("search query expected")
+	}
+	if len(query.Types) == 0 {
+		return nil, newArgumentError("search type expected")
+	}
+	for _, t := range query.Types {
+		valid := false
+		for _, v := range searchTypes {
+			if v == t {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, newArgumentError("type must be one of " + strings.Join(searchTypes[:], ", "))
+		}
+	}
+	params := url.Values{}
+	params.Set("q", query.Query)
+	params.Set("type", strings.Join(query.Types, ","))
+	params.Set("limit", strconv.Itoa(query.Limit))
+	params.Set("offset", strconv.Itoa(query.Offset))
+	if query.Market != "" {
+		params.Set("market", query.Market)
+	}
+	if query.IncludeExternal != "" {
+		params.Set("include_external", query.IncludeExternal)
+	}
+
+	resp, err := service.callSpotify(userID, "GET", os.Getenv("SPOTIFY_SEARCH")+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result SearchResult
+	if err := json.Unmarshal(*resp, &result); err != nil {
+		return nil, err
+	}
+	// spotify may return null items for unavailable playlists
+	if result.Playlists != nil {
+		playlists := result.Playlists.Items[:0]
+		for _, playlist := range result.Playlists.Items {
+			if playlist.ID != "" {
+				playlists = append(playlists, playlist)
+			}
+		}
+		result.Playlists.Items = playlists
+	}
+
+	var tracks []Track
+	var artists []Artist
+	var albums []Album
+	if result.Tracks != nil {
+		tracks = result.Tracks.Items
+	}
+	if result.Artists != nil {
+		artists = result.Artists.Items
+	}
+	if result.Albums != nil {
+		albums = result.Albums.Items
+	}
+	service.catalog(tracks, artists, albums)
+	return &result, nil
+}
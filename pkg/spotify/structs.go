@@ -9,6 +9,7 @@ import (
 // Profile Interface
 type Profile struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	UserID          string             `bson:"user_id,omitempty" json:"-"`
 	CreatedAt       time.Time          `bson:"created_at,omitempty"`
 	UpdatedAt       time.Time          `bson:"updated_at,omitempty"`
 	Country         string             `bson:"country" json:"country"`
@@ -47,70 +48,60 @@ type Credentials struct {
 	Scope        string    `bson:"scope" json:"scope"`
 }
 
-// ALBUM
-// {
-// 	"album_type": "ALBUM",
-// 	"artists": [
-// 		{
-// 			"external_urls": {
-// 				"spotify": "https://open.spotify.com/artist/7m0BsF0t3K9WQFgKoPejfk"
-// 			},
-// 			"href": "https://api.spotify.com/v1/artists/7m0BsF0t3K9WQFgKoPejfk",
-// 			"id": "7m0BsF0t3K9WQFgKoPejfk",
-// 			"name": "ArrDee",
-// 			"type": "artist",
-// 			"uri": "spotify:artist:7m0BsF0t3K9WQFgKoPejfk"
-// 		}
-// 	],
-// 	"external_urls": {
-// 		"spotify": "https://open.spotify.com/album/2acy6L0ZXAGSHoW6TIVtyW"
-// 	},
-// 	"href": "https://api.spotify.com/v1/albums/2acy6L0ZXAGSHoW6TIVtyW",
-// 	"id": "2acy6L0ZXAGSHoW6TIVtyW",
-// 	"images": [
-// 		{
-// 			"height": 640,
-// 			"url": "https://i.scdn.co/image/ab67616d0000b27380e060e9c13d966d13607a01",
-// 			"width": 640
-// 		},
-// 		{
-// 			"height": 300,
-// 			"url": "https://i.scdn.co/image/ab67616d00001e0280e060e9c13d966d13607a01",
-// 			"width": 300
-// 		},
-// 		{
-// 			"height": 64,
-// 			"url": "https://i.scdn.co/image/ab67616d0000485180e060e9c13d966d13607a01",
-// 			"width": 64
-// 		}
-// 	],
-// 	"name": "Pier Pressure",
-// 	"release_date": "2022-03-18",
-// 	"release_date_precision": "day",
-// 	"total_tracks": 14,
-// 	"type": "album",
-// 	"uri": "spotify:album:2acy6L0ZXAGSHoW6TIVtyW"
-// }
-
-// ARTIST
-// "artists": [
-// 	{
-// 		"external_urls": {
-// 			"spotify": "https://open.spotify.com/artist/7m0BsF0t3K9WQFgKoPejfk"
-// 		},
-// 		"href": "https://api.spotify.com/v1/artists/7m0BsF0t3K9WQFgKoPejfk",
-// 		"id": "7m0BsF0t3K9WQFgKoPejfk",
-// 		"name": "ArrDee",
-// 		"type": "artist",
-// 		"uri": "spotify:artist:7m0BsF0t3K9WQFgKoPejfk"
-// 	}
-// ],
-
 // external url struct
 type ExternalUrls struct {
 	Spotify string `bson:"spotify" json:"spotify"`
 }
+
+type Image struct {
+	URL    string `bson:"url" json:"url"`
+	Height int    `bson:"height,omitempty" json:"height"`
+	Width  int    `bson:"width,omitempty" json:"width"`
+}
+
+type Followers struct {
+	Href  string `bson:"href,omitempty" json:"href"`
+	Total int    `bson:"total" json:"total"`
+}
+
+// Artist - full artist object, simplified artists nested in tracks and albums leave optional fields empty
+type Artist struct {
+	ExternalUrls ExternalUrls `bson:"external_urls" json:"external_urls"`
+	Followers    *Followers   `bson:"followers,omitempty" json:"followers,omitempty"`
+	Genres       []string     `bson:"genres,omitempty" json:"genres,omitempty"`
+	Href         string       `bson:"href" json:"href"`
+	ID           string       `bson:"id" json:"id"`
+	Images       []Image      `bson:"images,omitempty" json:"images,omitempty"`
+	Name         string       `bson:"name" json:"name"`
+	Popularity   int          `bson:"popularity,omitempty" json:"popularity,omitempty"`
+	Type         string       `bson:"type" json:"type"`
+	URI          string       `bson:"uri" json:"uri"`
+}
+
+// Album - full album object, simplified albums nested in tracks leave optional fields empty
+type Album struct {
+	AlbumType            string       `bson:"album_type" json:"album_type"`
+	Artists              []Artist     `bson:"artists,omitempty" json:"artists,omitempty"`
+	ExternalUrls         ExternalUrls `bson:"external_urls" json:"external_urls"`
+	Genres               []string     `bson:"genres,omitempty" json:"genres,omitempty"`
+	Href                 string       `bson:"href" json:"href"`
+	ID                   string       `bson:"id" json:"id"`
+	Images               []Image      `bson:"images,omitempty" json:"images,omitempty"`
+	Label                string       `bson:"label,omitempty" json:"label,omitempty"`
+	Name                 string       `bson:"name" json:"name"`
+	Popularity           int          `bson:"popularity,omitempty" json:"popularity,omitempty"`
+	ReleaseDate          string       `bson:"release_date,omitempty" json:"release_date"`
+	ReleaseDatePrecision string       `bson:"release_date_precision,omitempty" json:"release_date_precision"`
+	TotalTracks          int          `bson:"total_tracks,omitempty" json:"total_tracks"`
+	Type                 string       `bson:"type" json:"type"`
+	URI                  string       `bson:"uri" json:"uri"`
+}
+
 type Track struct {
+	Album        *Album       `bson:"album,omitempty" json:"album,omitempty"`
+	Artists      []Artist     `bson:"artists,omitempty" json:"artists,omitempty"`
+	DiscNumber   int          `bson:"disc_number,omitempty" json:"disc_number"`
+	DurationMS   int          `bson:"duration_ms,omitempty" json:"duration_ms"`
 	Explicit     bool         `bson:"explicit" json:"explicit"`
 	ExternalUrls ExternalUrls `bson:"external_urls" json:"external_urls"`
 	Href         string       `bson:"href" json:"href"`
@@ -121,7 +112,128 @@ type Track struct {
 	Type         string       `bson:"type" json:"type"`
 	URI          string       `bson:"uri" json:"uri"`
 	IsLocal      bool         `bson:"is_local" json:"is_local"`
-	Popularity   int          `bson:"popularity" json:"popularity"`
+	Popularity   int          `bson:"popularity,omitempty" json:"popularity"`
+}
+
+// Complete - whether artist is a full object, simplified artists have no followers
+func (artist Artist) Complete() bool {
+	return artist.Followers != nil
+}
+
+// Complete - whether album is a full object, simplified albums have no genres or label
+func (album Album) Complete() bool {
+	return album.Genres != nil || album.Label != ""
+}
+
+// Complete - whether track is a full object, simplified tracks nested in albums have no album
+func (track Track) Complete() bool {
+	return track.Album != nil
+}
+
+type PlaylistOwner struct {
+	DisplayName  string       `bson:"display_name" json:"display_name"`
+	ExternalUrls ExternalUrls `bson:"external_urls" json:"external_urls"`
+	Href         string       `bson:"href" json:"href"`
+	ID           string       `bson:"id" json:"id"`
+	Type         string       `bson:"type" json:"type"`
+	URI          string       `bson:"uri" json:"uri"`
+}
+
+// Playlist - simplified playlist object
+type Playlist struct {
+	Collaborative bool          `bson:"collaborative" json:"collaborative"`
+	Description   string        `bson:"description" json:"description"`
+	ExternalUrls  ExternalUrls  `bson:"external_urls" json:"external_urls"`
+	Href          string        `bson:"href" json:"href"`
+	ID            string        `bson:"id" json:"id"`
+	Images        []Image       `bson:"images" json:"images"`
+	Name          string        `bson:"name" json:"name"`
+	Owner         PlaylistOwner `bson:"owner" json:"owner"`
+	Public        bool          `bson:"public" json:"public"`
+	SnapshotID    string        `bson:"snapshot_id" json:"snapshot_id"`
+	Tracks        struct {
+		Href  string `bson:"href" json:"href"`
+		Total int    `bson:"total" json:"total"`
+	} `bson:"tracks" json:"tracks"`
+	Type string `bson:"type" json:"type"`
+	URI  string `bson:"uri" json:"uri"`
+}
+
+// ArtistDetail - artist with its top tracks, albums and related artists
+type ArtistDetail struct {
+	Artist
+	TopTracks      []Track  `json:"top_tracks"`
+	Albums         []Album  `json:"albums"`
+	RelatedArtists []Artist `json:"related_artists"`
+}
+
+// AlbumDetail - album with its tracks and their audio features
+type AlbumDetail struct {
+	Album
+	Tracks        []Track         `json:"tracks"`
+	AudioFeatures []AudioFeatures `json:"audio_features"`
+}
+
+type ArtistNode struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Genres     []string `json:"genres"`
+	Popularity int      `json:"popularity"`
+	Image      string   `json:"image,omitempty"`
+	Depth      int      `json:"depth"`
+	Seed       bool     `json:"seed"`
+}
+
+type ArtistEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// ArtistGraph - related artists reachable from user's top artists
+type ArtistGraph struct {
+	Nodes []ArtistNode `json:"nodes"`
+	Edges []ArtistEdge `json:"edges"`
+}
+
+// paging objects of spotify api, one per item type
+type TrackPage struct {
+	Href     string  `json:"href"`
+	Items    []Track `json:"items"`
+	Limit    int     `json:"limit"`
+	Next     string  `json:"next"`
+	Offset   int     `json:"offset"`
+	Previous string  `json:"previous"`
+	Total    int     `json:"total"`
+}
+
+type ArtistPage struct {
+	Href     string   `json:"href"`
+	Items    []Artist `json:"items"`
+	Limit    int      `json:"limit"`
+	Next     string   `json:"next"`
+	Offset   int      `json:"offset"`
+	Previous string   `json:"previous"`
+	Total    int      `json:"total"`
+}
+
+type AlbumPage struct {
+	Href     string  `json:"href"`
+	Items    []Album `json:"items"`
+	Limit    int     `json:"limit"`
+	Next     string  `json:"next"`
+	Offset   int     `json:"offset"`
+	Previous string  `json:"previous"`
+	Total    int     `json:"total"`
+}
+
+type PlaylistPage struct {
+	Href     string     `json:"href"`
+	Items    []Playlist `json:"items"`
+	Limit    int        `json:"limit"`
+	Next     string     `json:"next"`
+	Offset   int        `json:"offset"`
+	Previous string     `json:"previous"`
+	Total    int        `json:"total"`
 }
 
 type AudioFeatures struct {
@@ -1,15 +1,13 @@
 package spotify
 
 import (
-	"io/ioutil"
 	"os"
+	"utilserver/pkg/tracing"
 )
 
-func (service *Service) GetTracksAudioFeatures(email string, trackIDs []string) (*[]byte, error) {
-	profile, err := service.storage.GetProfileWithEmail(email)
-	if err != nil {
-		return nil, err
-	}
+func (service *Service) GetTracksAudioFeatures(userID string, trackIDs []string) (*[]byte, error) {
+	service, span := service.span("GetTracksAudioFeatures", tracing.UserID(userID))
+	defer span.End()
 	URL := os.Getenv("SPOTIFY_AUDIO_FEATURES") + "?ids="
 	for i, trackID := range trackIDs {
 		URL = URL + trackID
@@ -17,21 +15,5 @@ func (service *Service) GetTracksAudioFeatures(email string, trackIDs []string)
 			URL = URL + ","
 		}
 	}
-	container, err := service.httpClient.Request(
-		"GET",
-		URL,
-		nil,
-		"application/json",
-		"Bearer "+profile.Credentials.AccessToken,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer container.Body.Close()
-
-	features, err := ioutil.ReadAll(container.Body)
-	if err != nil {
-		return nil, err
-	}
-	return &features, nil
+	return service.callSpotifyStale(userID, URL)
 }
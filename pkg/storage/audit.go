@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	auditLogCollection = "audit-log"
+	auditAppendRetries = 5
+)
+
+// EnsureAuditIndexes - unique sequence keeps concurrent appends from forking the chain
+func (storage *Storage) EnsureAuditIndexes() error {
+	collection := storage.database.Collection(auditLogCollection)
+	_, err := collection.Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "sequence", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// AppendAuditRecord - chain record to the last one and append it to audit log
+func (storage *Storage) AppendAuditRecord(record spotify.AuditRecord) (*spotify.AuditRecord, error) {
+	collection := storage.database.Collection(auditLogCollection)
+	for attempt := 0; attempt < auditAppendRetries; attempt++ {
+		var last spotify.AuditRecord
+		err := collection.FindOne(storage.context(), bson.M{},
+			options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}}),
+		).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		record.Sequence = 1
+		record.PreviousHash = ""
+		if err == nil {
+			record.Sequence = last.Sequence + 1
+			record.PreviousHash = last.Hash
+		}
+		record.Hash = record.ComputeHash()
+		result, err := collection.InsertOne(storage.context(), record)
+		if isDuplicateKey(err) {
+			// another replica appended meanwhile, chain to its record
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record.ID = result.InsertedID.(primitive.ObjectID)
+		return &record, nil
+	}
+	return nil, errors.New("audit log: too many concurrent appends")
+}
+
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
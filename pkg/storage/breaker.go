@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+	"utilserver/pkg/breaker"
+
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// mongo gives no event when a server can't be selected, outages are noticed by pinging it
+const (
+	mongoPingInterval = 2 * time.Second
+	mongoPingTimeout  = time.Second
+)
+
+// closed is the done channel of unavailable contexts
+var closed = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// unavailableContext - context which is already done with the error of an open breaker, the
+// driver returns that error right away instead of waiting for a server
+type unavailableContext struct {
+	context.Context
+	err error
+}
+
+func (ctx unavailableContext) Done() <-chan struct{} {
+	return closed
+}
+
+func (ctx unavailableContext) Err() error {
+	return ctx.err
+}
+
+// guard - ctx of an operation, already done with *breaker.UnavailableError while mongo is down
+func (storage *Storage) guard(ctx context.Context) context.Context {
+	if storage.breaker == nil {
+		return ctx
+	}
+	if err := storage.breaker.Allow(); err != nil {
+		return unavailableContext{Context: ctx, err: err}
+	}
+	return ctx
+}
+
+// watch - ping mongo until the process exits and report the outcome to its breaker
+func (storage *Storage) watch() {
+	for range time.Tick(mongoPingInterval) {
+		if storage.breaker.Allow() != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), mongoPingTimeout)
+		err := storage.client.Ping(ctx, readpref.Primary())
+		cancel()
+		if err != nil {
+			storage.breaker.Failure()
+			continue
+		}
+		storage.breaker.Success()
+	}
+}
+
+// breakerHook - redis hook failing commands fast while redis is down, every command which
+// reaches redis reports its outcome
+type breakerHook struct {
+	breaker *breaker.Breaker
+}
+
+func (hook breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, hook.breaker.Allow()
+}
+
+func (hook breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	hook.report(cmd.Err())
+	return nil
+}
+
+func (hook breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, hook.breaker.Allow()
+}
+
+func (hook breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); isOutage(err) {
+			break
+		}
+	}
+	hook.report(err)
+	return nil
+}
+
+func (hook breakerHook) report(err error) {
+	switch {
+	case errors.Is(err, breaker.ErrUnavailable):
+		// rejected by the breaker itself
+	case isOutage(err):
+		hook.breaker.Failure()
+	default:
+		hook.breaker.Success()
+	}
+}
+
+// missing keys and error replies of redis are answers, anything else means redis wasn't reached
+func isOutage(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
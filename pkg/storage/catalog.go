@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	artistsCollection = "spotify-catalog-artists"
+	albumsCollection  = "spotify-catalog-albums"
+	tracksCollection  = "spotify-catalog-tracks"
+)
+
+// EnsureCatalogIndexes - create indexes of catalog collections, existing indexes are left as they are
+func (storage *Storage) EnsureCatalogIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		artistsCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "genres", Value: 1}}},
+			{Keys: bson.D{{Key: "popularity", Value: -1}}},
+			{Keys: bson.D{{Key: "fetched_at", Value: 1}}},
+		},
+		albumsCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "artists.id", Value: 1}}},
+			{Keys: bson.D{{Key: "genres", Value: 1}}},
+			{Keys: bson.D{{Key: "release_date", Value: -1}}},
+			{Keys: bson.D{{Key: "fetched_at", Value: 1}}},
+		},
+		tracksCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "album.id", Value: 1}}},
+			{Keys: bson.D{{Key: "artists.id", Value: 1}}},
+			{Keys: bson.D{{Key: "name", Value: 1}, {Key: "artists.name", Value: 1}}},
+			{Keys: bson.D{{Key: "popularity", Value: -1}}},
+			{Keys: bson.D{{Key: "fetched_at", Value: 1}}},
+		},
+	}
+	for collectionName, models := range indexes {
+		_, err := storage.database.Collection(collectionName).Indexes().CreateMany(storage.context(), models)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert documents by spotify id, fields left empty in document keep their stored value.
+// fetched_at is only moved for complete objects, it drives the freshness of catalog lookups
+func (storage *Storage) upsertByID(collectionName string, ids []string, documents []interface{}, complete []bool) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(documents))
+	for i, document := range documents {
+		if ids[i] == "" {
+			continue
+		}
+		update := map[string]interface{}{"$set": document}
+		if complete[i] {
+			update["$currentDate"] = map[string]bool{"fetched_at": true}
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(map[string]string{"id": ids[i]}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	collection := storage.database.Collection(collectionName)
+	_, err := collection.BulkWrite(storage.context(), models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// UpsertArtists - create or update artists in local catalog
+func (storage *Storage) UpsertArtists(artists []spotify.Artist) error {
+	ids := make([]string, len(artists))
+	documents := make([]interface{}, len(artists))
+	complete := make([]bool, len(artists))
+	for i, artist := range artists {
+		ids[i] = artist.ID
+		documents[i] = artist
+		complete[i] = artist.Complete()
+	}
+	return storage.upsertByID(artistsCollection, ids, documents, complete)
+}
+
+// GetArtists - artists of local catalog fetched from spotify after given time, missing or stale ones are left out
+func (storage *Storage) GetArtists(ids []string, fetchedAfter time.Time) ([]spotify.Artist, error) {
+	artists := []spotify.Artist{}
+	err := storage.findByIDs(artistsCollection, ids, fetchedAfter, &artists)
+	return artists, err
+}
+
+// UpsertAlbums - create or update albums in local catalog
+func (storage *Storage) UpsertAlbums(albums []spotify.Album) error {
+	ids := make([]string, len(albums))
+	documents := make([]interface{}, len(albums))
+	complete := make([]bool, len(albums))
+	for i, album := range albums {
+		ids[i] = album.ID
+		documents[i] = album
+		complete[i] = album.Complete()
+	}
+	return storage.upsertByID(albumsCollection, ids, documents, complete)
+}
+
+// GetAlbums - albums of local catalog fetched from spotify after given time, missing or stale ones are left out
+func (storage *Storage) GetAlbums(ids []string, fetchedAfter time.Time) ([]spotify.Album, error) {
+	albums := []spotify.Album{}
+	err := storage.findByIDs(albumsCollection, ids, fetchedAfter, &albums)
+	return albums, err
+}
+
+// UpsertTracks - create or update tracks in local catalog
+func (storage *Storage) UpsertTracks(tracks []spotify.Track) error {
+	ids := make([]string, len(tracks))
+	documents := make([]interface{}, len(tracks))
+	complete := make([]bool, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.ID
+		documents[i] = track
+		complete[i] = track.Complete()
+	}
+	return storage.upsertByID(tracksCollection, ids, documents, complete)
+}
+
+// GetTracks - tracks of local catalog fetched from spotify after given time, missing or stale ones are left out
+func (storage *Storage) GetTracks(ids []string, fetchedAfter time.Time) ([]spotify.Track, error) {
+	tracks := []spotify.Track{}
+	err := storage.findByIDs(tracksCollection, ids, fetchedAfter, &tracks)
+	return tracks, err
+}
+
+// FindTrack - most popular catalog track with exact name by artist, nil if there is none
+func (storage *Storage) FindTrack(name string, artistName string) (*spotify.Track, error) {
+	var track spotify.Track
+	collection := storage.database.Collection(tracksCollection)
+	err := collection.FindOne(storage.context(),
+		map[string]string{"name": name, "artists.name": artistName},
+		options.FindOne().SetSort(bson.D{{Key: "popularity", Value: -1}}),
+	).Decode(&track)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// find catalog entries by id, zero fetchedAfter returns entries regardless of their freshness
+func (storage *Storage) findByIDs(collectionName string, ids []string, fetchedAfter time.Time, results interface{}) error {
+	collection := storage.database.Collection(collectionName)
+	filter := map[string]interface{}{
+		"id": map[string]interface{}{"$in": ids},
+	}
+	if !fetchedAfter.IsZero() {
+		filter["fetched_at"] = map[string]interface{}{"$gte": fetchedAfter}
+	}
+	cursor, err := collection.Find(storage.context(), filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(storage.context(), results)
+}
+
+func catalogCollection(kind string) string {
+	if kind == spotify.CatalogAlbum {
+		return albumsCollection
+	}
+	return artistsCollection
+}
+
+// SetCatalogLinks - store ids related to an artist or album, like related artists or album tracks
+func (storage *Storage) SetCatalogLinks(kind string, id string, field string, ids []string) error {
+	collection := storage.database.Collection(catalogCollection(kind))
+	_, err := collection.UpdateOne(storage.context(),
+		map[string]string{"id": id},
+		map[string]interface{}{
+			"$set": map[string]interface{}{
+				"links." + field + ".ids":        ids,
+				"links." + field + ".fetched_at": time.Now(),
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetCatalogLinks - ids related to an artist or album stored after given time, nil if missing or stale
+func (storage *Storage) GetCatalogLinks(kind string, id string, field string, fetchedAfter time.Time) ([]string, error) {
+	var container struct {
+		Links map[string]struct {
+			IDs       []string  `bson:"ids"`
+			FetchedAt time.Time `bson:"fetched_at"`
+		} `bson:"links"`
+	}
+	collection := storage.database.Collection(catalogCollection(kind))
+	err := collection.FindOne(storage.context(), map[string]string{"id": id}).Decode(&container)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	link, ok := container.Links[field]
+	if !ok || link.FetchedAt.Before(fetchedAfter) {
+		return nil, nil
+	}
+	if link.IDs == nil {
+		return []string{}, nil
+	}
+	return link.IDs, nil
+}
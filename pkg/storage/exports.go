@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"io"
+	"time"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	exportsCollection = "spotify-exports"
+	exportsBucket     = "exports"
+)
+
+// EnsureExportIndexes - create indexes of exports
+func (storage *Storage) EnsureExportIndexes() error {
+	collection := storage.database.Collection(exportsCollection)
+	_, err := collection.Indexes().CreateMany(storage.context(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	return err
+}
+
+func (storage *Storage) exportsBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(storage.database, options.GridFSBucket().SetName(exportsBucket))
+}
+
+// CreateExport - store new export job
+func (storage *Storage) CreateExport(job spotify.Export) (*spotify.Export, error) {
+	collection := storage.database.Collection(exportsCollection)
+	result, err := collection.InsertOne(storage.context(), job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return &job, nil
+}
+
+// UpdateExport - replace export job with its current state
+func (storage *Storage) UpdateExport(job spotify.Export) error {
+	collection := storage.database.Collection(exportsCollection)
+	_, err := collection.ReplaceOne(storage.context(), bson.M{"_id": job.ID}, job)
+	return err
+}
+
+// ClaimExport - oldest pending export, or running export started before staleBefore, marked as
+// running by the caller. nil when there is none
+func (storage *Storage) ClaimExport(staleBefore time.Time) (*spotify.Export, error) {
+	var job spotify.Export
+	collection := storage.database.Collection(exportsCollection)
+	err := collection.FindOneAndUpdate(storage.context(),
+		bson.M{"$or": []bson.M{
+			{"status": spotify.ExportPending},
+			{"status": spotify.ExportRunning, "started_at": bson.M{"$lt": staleBefore}},
+		}},
+		bson.M{
+			"$set": bson.M{"status": spotify.ExportRunning, "started_at": time.Now()},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetExport - export job of user, nil if it doesn't exist
+func (storage *Storage) GetExport(userID string, id string) (*spotify.Export, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+	var job spotify.Export
+	collection := storage.database.Collection(exportsCollection)
+	err = collection.FindOne(storage.context(), bson.M{"_id": objectID, "user_id": userID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SaveExportArchive - upload archive to gridfs and return its file id
+func (storage *Storage) SaveExportArchive(name string, archive io.Reader) (primitive.ObjectID, error) {
+	bucket, err := storage.exportsBucket()
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return bucket.UploadFromStream(name, archive)
+}
+
+// OpenExportArchive - stream archive from gridfs, caller closes it
+func (storage *Storage) OpenExportArchive(fileID primitive.ObjectID) (io.ReadCloser, error) {
+	bucket, err := storage.exportsBucket()
+	if err != nil {
+		return nil, err
+	}
+	return bucket.OpenDownloadStream(fileID)
+}
+
+// DeleteExpiredExports - delete exports expired before time with their archives
+func (storage *Storage) DeleteExpiredExports(before time.Time) (int, error) {
+	jobs := []spotify.Export{}
+	collection := storage.database.Collection(exportsCollection)
+	cursor, err := collection.Find(storage.context(), bson.M{"expires_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	if err := cursor.All(storage.context(), &jobs); err != nil {
+		return 0, err
+	}
+	return storage.deleteExports(jobs)
+}
+
+// DeleteExports - delete every export of user with its archive
+func (storage *Storage) DeleteExports(userID string) error {
+	jobs := []spotify.Export{}
+	collection := storage.database.Collection(exportsCollection)
+	cursor, err := collection.Find(storage.context(), bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(storage.context(), &jobs); err != nil {
+		return err
+	}
+	_, err = storage.deleteExports(jobs)
+	return err
+}
+
+func (storage *Storage) deleteExports(jobs []spotify.Export) (int, error) {
+	bucket, err := storage.exportsBucket()
+	if err != nil {
+		return 0, err
+	}
+	collection := storage.database.Collection(exportsCollection)
+	for i, job := range jobs {
+		if !job.FileID.IsZero() {
+			if err := bucket.Delete(job.FileID); err != nil && err != gridfs.ErrFileNotFound {
+				return i, err
+			}
+		}
+		if _, err := collection.DeleteOne(storage.context(), bson.M{"_id": job.ID}); err != nil {
+			return i, err
+		}
+	}
+	return len(jobs), nil
+}
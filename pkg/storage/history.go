@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const playsCollection = "spotify-listening-history"
+
+// EnsureHistoryIndexes - create indexes of listening history
+func (storage *Storage) EnsureHistoryIndexes() error {
+	collection := storage.database.Collection(playsCollection)
+	_, err := collection.Indexes().CreateMany(storage.context(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "played_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "track_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "pending", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"pending": true}),
+		},
+	})
+	return err
+}
+
+func playedBetween(userID string, from time.Time, to time.Time) bson.M {
+	filter := bson.M{"user_id": userID}
+	playedAt := bson.M{}
+	if !from.IsZero() {
+		playedAt["$gte"] = from
+	}
+	if !to.IsZero() {
+		playedAt["$lte"] = to
+	}
+	if len(playedAt) > 0 {
+		filter["played_at"] = playedAt
+	}
+	return filter
+}
+
+// SavePlays - append plays to listening history
+func (storage *Storage) SavePlays(plays []spotify.Play) error {
+	documents := make([]interface{}, 0, len(plays))
+	for _, play := range plays {
+		documents = append(documents, play)
+	}
+	collection := storage.database.Collection(playsCollection)
+	_, err := collection.InsertMany(storage.context(), documents)
+	return err
+}
+
+// GetPlays - plays of user between from and to oldest first, zero times leave range open
+func (storage *Storage) GetPlays(userID string, from time.Time, to time.Time) ([]spotify.Play, error) {
+	plays := []spotify.Play{}
+	collection := storage.database.Collection(playsCollection)
+	cursor, err := collection.Find(storage.context(),
+		playedBetween(userID, from, to),
+		options.Find().SetSort(bson.D{{Key: "played_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &plays)
+	return plays, err
+}
+
+// GetPendingPlays - up to limit plays of any user whose tracks are still to be looked up
+func (storage *Storage) GetPendingPlays(limit int) ([]spotify.Play, error) {
+	plays := []spotify.Play{}
+	collection := storage.database.Collection(playsCollection)
+	cursor, err := collection.Find(storage.context(), bson.M{"pending": true}, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &plays)
+	return plays, err
+}
+
+// UpdatePlays - store track fields of plays which were pending
+func (storage *Storage) UpdatePlays(plays []spotify.Play) error {
+	if len(plays) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(plays))
+	for _, play := range plays {
+		update := bson.M{"$set": bson.M{
+			"album_name": play.AlbumName,
+			"ms_played":  play.MSPlayed,
+			"played_at":  play.PlayedAt,
+		}}
+		if !play.Pending {
+			update["$unset"] = bson.M{"pending": ""}
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": play.ID}).SetUpdate(update))
+	}
+	collection := storage.database.Collection(playsCollection)
+	_, err := collection.BulkWrite(storage.context(), models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// GetPlaysPage - page of plays of user between from and to newest first with count of all of them
+func (storage *Storage) GetPlaysPage(userID string, from time.Time, to time.Time, limit int, offset int) ([]spotify.Play, int64, error) {
+	plays := []spotify.Play{}
+	filter := playedBetween(userID, from, to)
+	collection := storage.database.Collection(playsCollection)
+	total, err := collection.CountDocuments(storage.context(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := collection.Find(storage.context(), filter,
+		options.Find().
+			SetSort(bson.D{{Key: "played_at", Value: -1}}).
+			SetSkip(int64(offset)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = cursor.All(storage.context(), &plays)
+	return plays, total, err
+}
+
+// DeletePlays - delete whole listening history of user
+func (storage *Storage) DeletePlays(userID string) error {
+	collection := storage.database.Collection(playsCollection)
+	_, err := collection.DeleteMany(storage.context(), map[string]string{"user_id": userID})
+	return err
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/lastfm"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const lastFMProfileCollection = "lastfm-profile"
+
+// EnsureLastFMIndexes - one last.fm profile per user
+func (storage *Storage) EnsureLastFMIndexes() error {
+	collection := storage.database.Collection(lastFMProfileCollection)
+	_, err := collection.Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// UpsertLastFMProfile - create last.fm profile of user, existing profile only takes name
+// and credentials so settings and backfill progress survive a new login
+func (storage *Storage) UpsertLastFMProfile(profile lastfm.Profile) error {
+	collection := storage.database.Collection(lastFMProfileCollection)
+	_, err := collection.UpdateOne(storage.context(),
+		map[string]string{"user_id": profile.UserID},
+		bson.M{
+			"$set": bson.M{
+				"name":        profile.Name,
+				"credentials": profile.Credentials,
+				"updated_at":  profile.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{
+				"scrobbling": profile.Scrobbling,
+				"created_at": profile.CreatedAt,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetLastFMProfile - last.fm profile of user, nil if user hasn't linked last.fm
+func (storage *Storage) GetLastFMProfile(userID string) (*lastfm.Profile, error) {
+	var profile lastfm.Profile
+	collection := storage.database.Collection(lastFMProfileCollection)
+	err := collection.FindOne(storage.context(), map[string]string{"user_id": userID}).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ListLastFMUserIDs - ids of every user with last.fm profile
+func (storage *Storage) ListLastFMUserIDs() ([]string, error) {
+	collection := storage.database.Collection(lastFMProfileCollection)
+	userIDs, err := collection.Distinct(storage.context(), "user_id", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID, ok := userID.(string); ok && userID != "" {
+			list = append(list, userID)
+		}
+	}
+	return list, nil
+}
+
+func (storage *Storage) updateLastFMProfile(userID string, fields bson.M) error {
+	fields["updated_at"] = time.Now()
+	collection := storage.database.Collection(lastFMProfileCollection)
+	_, err := collection.UpdateOne(storage.context(),
+		map[string]string{"user_id": userID},
+		bson.M{"$set": fields},
+	)
+	return err
+}
+
+// SetLastFMScrobbling - turn scrobbling of user on or off
+func (storage *Storage) SetLastFMScrobbling(userID string, enabled bool) error {
+	return storage.updateLastFMProfile(userID, bson.M{"scrobbling": enabled})
+}
+
+// SetLastFMBackfilledAt - time of newest scrobble imported into listening history
+func (storage *Storage) SetLastFMBackfilledAt(userID string, backfilledAt time.Time) error {
+	return storage.updateLastFMProfile(userID, bson.M{"backfilled_at": backfilledAt})
+}
+
+// DeleteLastFMProfile - delete last.fm profile with its credentials
+func (storage *Storage) DeleteLastFMProfile(userID string) error {
+	collection := storage.database.Collection(lastFMProfileCollection)
+	_, err := collection.DeleteMany(storage.context(), map[string]string{"user_id": userID})
+	return err
+}
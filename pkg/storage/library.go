@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const librarySnapshotsCollection = "spotify-library-snapshots"
+
+// EnsureLibraryIndexes - create indexes of library snapshots
+func (storage *Storage) EnsureLibraryIndexes() error {
+	collection := storage.database.Collection(librarySnapshotsCollection)
+	_, err := collection.Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "kind", Value: 1}, {Key: "synced_at", Value: -1}},
+	})
+	return err
+}
+
+// SaveLibrarySnapshot - store new snapshot of user's library
+func (storage *Storage) SaveLibrarySnapshot(snapshot spotify.LibrarySnapshot) error {
+	collection := storage.database.Collection(librarySnapshotsCollection)
+	_, err := collection.InsertOne(storage.context(), snapshot)
+	return err
+}
+
+// GetLatestLibrarySnapshot - most recent snapshot of a library kind, nil if library hasn't been synced
+func (storage *Storage) GetLatestLibrarySnapshot(userID string, kind string) (*spotify.LibrarySnapshot, error) {
+	var snapshot spotify.LibrarySnapshot
+	collection := storage.database.Collection(librarySnapshotsCollection)
+	err := collection.FindOne(storage.context(),
+		map[string]string{"user_id": userID, "kind": kind},
+		options.FindOne().SetSort(bson.D{{Key: "synced_at", Value: -1}}),
+	).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// GetLibraryHistory - snapshots of a library kind oldest first, without their items
+func (storage *Storage) GetLibraryHistory(userID string, kind string) ([]spotify.LibrarySnapshot, error) {
+	snapshots := []spotify.LibrarySnapshot{}
+	collection := storage.database.Collection(librarySnapshotsCollection)
+	cursor, err := collection.Find(storage.context(),
+		map[string]string{"user_id": userID, "kind": kind},
+		options.Find().
+			SetSort(bson.D{{Key: "synced_at", Value: 1}}).
+			SetProjection(map[string]int{"items": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &snapshots)
+	return snapshots, err
+}
+
+// DeleteLibrarySnapshots - delete every library snapshot of user
+func (storage *Storage) DeleteLibrarySnapshots(userID string) error {
+	collection := storage.database.Collection(librarySnapshotsCollection)
+	_, err := collection.DeleteMany(storage.context(), map[string]string{"user_id": userID})
+	return err
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/accounts"
+	"utilserver/pkg/lastfm"
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collections of user data which were keyed by email before users had ids
+var emailKeyedCollections = []string{
+	librarySnapshotsCollection,
+	playlistVersionsCollection,
+	exportsCollection,
+	playsCollection,
+}
+
+// indexes over email of those collections, migrated documents have no email and would all
+// index as null, which breaks the unique index of playlist versions
+var emailKeyedIndexes = map[string][]string{
+	librarySnapshotsCollection: {"email_1_kind_1_synced_at_-1"},
+	playlistVersionsCollection: {"email_1_playlist_id_1_version_-1"},
+	exportsCollection:          {"email_1_created_at_-1"},
+	playsCollection:            {"email_1_played_at_-1", "email_1_track_id_1"},
+}
+
+// MigrateToUserIDs - move documents keyed by email to internal user ids. Every spotify profile
+// without owner gets a user with the profile linked as spotify account, documents of other
+// collections take user id of the profile with their email. Safe to run repeatedly
+func (storage *Storage) MigrateToUserIDs() error {
+	profiles := storage.database.Collection(profileCollection)
+	legacy := []spotify.Profile{}
+	cursor, err := profiles.Find(storage.context(), bson.M{"user_id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(storage.context(), &legacy); err != nil {
+		return err
+	}
+	for _, profile := range legacy {
+		if profile.ProfileID == "" {
+			continue
+		}
+		user, err := storage.legacyProfileOwner(profile)
+		if err != nil {
+			return err
+		}
+		if err := storage.AttachProfile(profile.ProfileID, user.ID.Hex()); err != nil {
+			return err
+		}
+	}
+
+	owners := []spotify.Profile{}
+	cursor, err = profiles.Find(storage.context(), bson.M{"user_id": bson.M{"$exists": true}, "email": bson.M{"$ne": ""}})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(storage.context(), &owners); err != nil {
+		return err
+	}
+	for _, collectionName := range emailKeyedCollections {
+		for _, index := range emailKeyedIndexes[collectionName] {
+			if err := storage.dropIndex(collectionName, index); err != nil {
+				return err
+			}
+		}
+		collection := storage.database.Collection(collectionName)
+		for _, owner := range owners {
+			_, err := collection.UpdateMany(storage.context(),
+				bson.M{"email": owner.Email, "user_id": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"user_id": owner.UserID}, "$unset": bson.M{"email": ""}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UserIDOfEmail - id of user whose spotify profile has email, empty if there is none
+func (storage *Storage) UserIDOfEmail(email string) (string, error) {
+	var profile spotify.Profile
+	collection := storage.database.Collection(profileCollection)
+	err := collection.FindOne(storage.context(), bson.M{"email": email, "user_id": bson.M{"$exists": true}}).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return profile.UserID, err
+}
+
+// user owning legacy profile, created with the profile as its spotify account if there is none
+func (storage *Storage) legacyProfileOwner(profile spotify.Profile) (*accounts.User, error) {
+	user, err := storage.FindUserByAccount(accounts.ProviderSpotify, profile.ProfileID)
+	if err != nil || user != nil {
+		return user, err
+	}
+	user, err = storage.CreateUser(accounts.User{
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		CreatedAt:   profile.CreatedAt,
+		UpdatedAt:   time.Now(),
+		Accounts: []accounts.LinkedAccount{{
+			Provider:    accounts.ProviderSpotify,
+			Subject:     profile.ProfileID,
+			Email:       profile.Email,
+			DisplayName: profile.DisplayName,
+			LinkedAt:    profile.CreatedAt,
+		}},
+	})
+	if isDuplicateKey(err) {
+		// another replica migrated the profile meanwhile
+		return storage.FindUserByAccount(accounts.ProviderSpotify, profile.ProfileID)
+	}
+	return user, err
+}
+
+// MigrateLastFMCredentials - move last.fm session keys kept with linked accounts of users
+// into last.fm profiles. Safe to run repeatedly
+func (storage *Storage) MigrateLastFMCredentials() error {
+	var legacy []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Accounts []struct {
+			Provider    string            `bson:"provider"`
+			Subject     string            `bson:"subject"`
+			LinkedAt    time.Time         `bson:"linked_at"`
+			Credentials map[string]string `bson:"credentials"`
+		} `bson:"accounts"`
+	}
+	users := storage.database.Collection(usersCollection)
+	filter := bson.M{"accounts": bson.M{"$elemMatch": bson.M{
+		"provider":    accounts.ProviderLastFM,
+		"credentials": bson.M{"$exists": true},
+	}}}
+	cursor, err := users.Find(storage.context(), filter)
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(storage.context(), &legacy); err != nil {
+		return err
+	}
+	for _, user := range legacy {
+		for _, account := range user.Accounts {
+			if account.Provider != accounts.ProviderLastFM || account.Credentials["session_key"] == "" {
+				continue
+			}
+			err := storage.UpsertLastFMProfile(lastfm.Profile{
+				UserID:     user.ID.Hex(),
+				Name:       account.Subject,
+				Scrobbling: true,
+				CreatedAt:  account.LinkedAt,
+				UpdatedAt:  time.Now(),
+				Credentials: lastfm.Credentials{
+					SessionKey: account.Credentials["session_key"],
+					CreatedAt:  account.LinkedAt,
+					UpdatedAt:  time.Now(),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = users.UpdateMany(storage.context(), filter, bson.M{"$unset": bson.M{"accounts.$[].credentials": ""}})
+	return err
+}
@@ -2,15 +2,22 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
+	"utilserver/pkg/breaker"
 	"utilserver/pkg/spotify"
+	"utilserver/pkg/tracing"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+const profileCollection = "spotify-profile"
+
 var instance *mongo.Client = nil
 var doOnce sync.Once
 var instanceError error
@@ -18,12 +25,36 @@ var instanceError error
 type Storage struct {
 	client   *mongo.Client
 	database *mongo.Database
+	ctx      context.Context
+	breaker  *breaker.Breaker
+}
+
+// BindContext - storage whose operations are traced as part of ctx, cancelling ctx doesn't
+// cancel them so work outliving a request keeps its storage
+func (storage *Storage) BindContext(ctx context.Context) interface{} {
+	bound := *storage
+	bound.ctx = tracing.Detach(ctx)
+	return &bound
+}
+
+// Ping - check that primary of the database answers
+func (storage *Storage) Ping(ctx context.Context) error {
+	return storage.client.Ping(ctx, readpref.Primary())
+}
+
+// context - context of operations, already done with *breaker.UnavailableError while mongo is down
+func (storage *Storage) context() context.Context {
+	if storage.ctx == nil {
+		return storage.guard(context.TODO())
+	}
+	return storage.guard(storage.ctx)
 }
 
 // New - initialize Storage instance
 func NewStorage(connectionString string, databaseName string) (*Storage, error) {
 	storage := new(Storage)
-	clientOptions := options.Client().ApplyURI(connectionString)
+	storage.breaker = breaker.NewFromEnv("mongo")
+	clientOptions := options.Client().ApplyURI(connectionString).SetMonitor(commandMonitor(storage.breaker))
 	// Connect to MongoDB
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -37,9 +68,97 @@ func NewStorage(connectionString string, databaseName string) (*Storage, error)
 	database := client.Database(databaseName)
 	storage.database = database
 	storage.client = client
+	// users have to be unique before migration creates them
+	if err := storage.EnsureUserIndexes(); err != nil {
+		return nil, err
+	}
+	if err := storage.MigrateToUserIDs(); err != nil {
+		return nil, err
+	}
+	if err := storage.MigrateLastFMCredentials(); err != nil {
+		return nil, err
+	}
+	if err := storage.EnsureIndexes(); err != nil {
+		return nil, err
+	}
+	go storage.watch()
 	return storage, nil
 }
 
+// EnsureProfileIndexes - one profile per spotify account and per user
+func (storage *Storage) EnsureProfileIndexes() error {
+	collection := storage.database.Collection(profileCollection)
+	_, err := collection.Indexes().CreateMany(storage.context(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "profile_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+			// profile has no owner until spotify login finishes
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"user_id": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+// dropIndex - drop index of collection by name, indexes which don't exist are skipped
+func (storage *Storage) dropIndex(collectionName string, name string) error {
+	_, err := storage.database.Collection(collectionName).Indexes().DropOne(storage.context(), name)
+	var commandErr mongo.CommandError
+	// IndexNotFound, NamespaceNotFound when the collection doesn't exist yet
+	if errors.As(err, &commandErr) && (commandErr.Code == 27 || commandErr.Code == 26) {
+		return nil
+	}
+	return err
+}
+
+// dropIndexWithout - drop index of collection by name when it was created without option, so
+// it can be created again with it. Creating an index under the name of one with other options fails
+func (storage *Storage) dropIndexWithout(collectionName string, name string, option string) error {
+	cursor, err := storage.database.Collection(collectionName).Indexes().List(storage.context())
+	if err != nil {
+		return err
+	}
+	specs := []bson.M{}
+	if err := cursor.All(storage.context(), &specs); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if _, ok := spec[option]; spec["name"] == name && !ok {
+			return storage.dropIndex(collectionName, name)
+		}
+	}
+	return nil
+}
+
+// EnsureIndexes - create indexes of every collection
+func (storage *Storage) EnsureIndexes() error {
+	if err := storage.EnsureProfileIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureCatalogIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureLibraryIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsurePlaylistIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureExportIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureHistoryIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureAuditIndexes(); err != nil {
+		return err
+	}
+	if err := storage.EnsureLastFMIndexes(); err != nil {
+		return err
+	}
+	return storage.EnsureWebhookIndexes()
+}
+
 //GetDBClient - create instance and Return client instance to work with
 func (storage *Storage) GetDBClient(CONNECTIONSTRING string) (*mongo.Client, error) {
 	//Perform connection creation operation only once.
@@ -61,10 +180,11 @@ func (storage *Storage) GetDBClient(CONNECTIONSTRING string) (*mongo.Client, err
 	return instance, instanceError
 }
 
-func (storage *Storage) GetProfileWithEmail(email string) (*spotify.Profile, error) {
+// GetProfile - spotify profile of user, nil if user hasn't linked spotify
+func (storage *Storage) GetProfile(userID string) (*spotify.Profile, error) {
 	var profile spotify.Profile
-	collection := storage.database.Collection("spotify-profile")
-	findErr := collection.FindOne(context.TODO(), map[string]string{"email": email}).Decode(&profile)
+	collection := storage.database.Collection(profileCollection)
+	findErr := collection.FindOne(storage.context(), map[string]string{"user_id": userID}).Decode(&profile)
 	if findErr != nil {
 		if findErr == mongo.ErrNoDocuments {
 			return nil, nil
@@ -74,17 +194,51 @@ func (storage *Storage) GetProfileWithEmail(email string) (*spotify.Profile, err
 	return &profile, nil
 }
 
+// ListProfileUserIDs - ids of every user with spotify profile
+func (storage *Storage) ListProfileUserIDs() ([]string, error) {
+	collection := storage.database.Collection(profileCollection)
+	userIDs, err := collection.Distinct(storage.context(), "user_id", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID, ok := userID.(string); ok && userID != "" {
+			list = append(list, userID)
+		}
+	}
+	return list, nil
+}
+
+// AttachProfile - set owner of spotify profile
+func (storage *Storage) AttachProfile(profileID string, userID string) error {
+	collection := storage.database.Collection(profileCollection)
+	_, err := collection.UpdateOne(storage.context(),
+		map[string]string{"profile_id": profileID},
+		map[string]interface{}{"$set": map[string]string{"user_id": userID}},
+	)
+	return err
+}
+
+// DeleteProfile - delete profile with its credentials
+func (storage *Storage) DeleteProfile(userID string) error {
+	collection := storage.database.Collection(profileCollection)
+	_, err := collection.DeleteMany(storage.context(), map[string]string{"user_id": userID})
+	return err
+}
+
 // CreateProfile - create profile func
 func (storage *Storage) CreateOrUpdateProfile(profile spotify.Profile) (*spotify.Profile, error) {
 	var profileContainer spotify.Profile
-	collection := storage.database.Collection("spotify-profile")
-	err := collection.FindOne(context.TODO(), map[string]string{"email": profile.Email}).Decode(&profileContainer)
+	collection := storage.database.Collection(profileCollection)
+	err := collection.FindOne(storage.context(), map[string]string{"profile_id": profile.ProfileID}).Decode(&profileContainer)
 	if err != mongo.ErrNoDocuments {
 		profile.Credentials.UpdatedAt = time.Now()
 		profile.Credentials.CreatedAt = profileContainer.Credentials.CreatedAt
 		profile.UpdatedAt = time.Now()
-		err := collection.FindOneAndUpdate(context.TODO(),
-			map[string]string{"email": profile.Email}, map[string]interface{}{"$set": profile},
+		err := collection.FindOneAndUpdate(storage.context(),
+			map[string]string{"profile_id": profile.ProfileID}, map[string]interface{}{"$set": profile},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
 		).Decode(&profileContainer)
 		return &profileContainer, err
 	}
@@ -93,16 +247,16 @@ func (storage *Storage) CreateOrUpdateProfile(profile spotify.Profile) (*spotify
 	profile.Credentials.UpdatedAt = time.Now()
 	profile.UpdatedAt = time.Now()
 	profile.ID = primitive.NewObjectID()
-	_, createError := collection.InsertOne(context.TODO(), profile)
+	_, createError := collection.InsertOne(storage.context(), profile)
 	if createError != nil {
 		return nil, createError
 	}
 	return &profile, createError
 }
 
-func (storage *Storage) UpdateCredentials(email string, credentials *spotify.Credentials) (*spotify.Profile, error) {
+func (storage *Storage) UpdateCredentials(userID string, credentials *spotify.Credentials) (*spotify.Profile, error) {
 	var profileContainer spotify.Profile
-	collection := storage.database.Collection("spotify-profile")
+	collection := storage.database.Collection(profileCollection)
 	updateParams := map[string]interface{}{
 		"updated_at":               time.Now(),
 		"credentials.access_token": credentials.AccessToken,
@@ -114,8 +268,8 @@ func (storage *Storage) UpdateCredentials(email string, credentials *spotify.Cre
 		updateParams["credentials.refresh_token"] = credentials.RefreshToken
 	}
 	err := collection.FindOneAndUpdate(
-		context.TODO(),
-		map[string]string{"email": email},
+		storage.context(),
+		map[string]string{"user_id": userID},
 		map[string]interface{}{"$set": updateParams},
 	).Decode(&profileContainer)
 	return &profileContainer, err
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+	"utilserver/pkg/breaker"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/tracing"
+
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commandMonitor - monitor observing latency of every mongo command and tracing it
+// as child of span in context of the operation, answered commands close breaker of mongo
+func commandMonitor(mongoBreaker *breaker.Breaker) *event.CommandMonitor {
+	// spans of commands in flight by connection and request id
+	var spans sync.Map
+	spanKey := func(connectionID string, requestID int64) string {
+		return connectionID + "/" + strconv.FormatInt(requestID, 10)
+	}
+	finish := func(finished event.CommandFinishedEvent, outcome string, err error) {
+		metrics.MongoOperationDuration.WithLabelValues(finished.CommandName, outcome).
+			Observe(time.Duration(finished.DurationNanos).Seconds())
+		if span, ok := spans.Load(spanKey(finished.ConnectionID, finished.RequestID)); ok {
+			spans.Delete(spanKey(finished.ConnectionID, finished.RequestID))
+			tracing.End(span.(trace.Span), err)
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, started *event.CommandStartedEvent) {
+			_, span := tracing.Start(ctx, "mongo."+started.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", "mongodb"),
+					attribute.String("db.name", started.DatabaseName),
+					attribute.String("db.operation", started.CommandName),
+				),
+			)
+			spans.Store(spanKey(started.ConnectionID, started.RequestID), span)
+		},
+		Succeeded: func(ctx context.Context, succeeded *event.CommandSucceededEvent) {
+			mongoBreaker.Success()
+			finish(succeeded.CommandFinishedEvent, "success", nil)
+		},
+		Failed: func(ctx context.Context, failed *event.CommandFailedEvent) {
+			finish(failed.CommandFinishedEvent, "failure", errors.New(failed.Failure))
+		},
+	}
+}
+
+// tracingHook - redis hook tracing every command as child of span in its context
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.Start(ctx, "redis."+cmd.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis"), attribute.String("db.operation", cmd.Name())),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	tracing.End(trace.SpanFromContext(ctx), redisError(cmd.Err()))
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.Start(ctx, "redis.pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis"), attribute.Int("db.redis.num_cmd", len(cmds))),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = redisError(cmd.Err()); err != nil {
+			break
+		}
+	}
+	tracing.End(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// missing keys aren't failures of a command
+func redisError(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"utilserver/pkg/spotify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const playlistVersionsCollection = "spotify-playlist-versions"
+
+// EnsurePlaylistIndexes - create indexes of playlist versions. Versions of emails without profile
+// are never migrated and have no user, the unique index only covers versions with a user
+func (storage *Storage) EnsurePlaylistIndexes() error {
+	err := storage.dropIndexWithout(playlistVersionsCollection, "user_id_1_playlist_id_1_version_-1", "partialFilterExpression")
+	if err != nil {
+		return err
+	}
+	collection := storage.database.Collection(playlistVersionsCollection)
+	_, err = collection.Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "playlist_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"user_id": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// SavePlaylistVersion - store new version of playlist
+func (storage *Storage) SavePlaylistVersion(version spotify.PlaylistVersion) error {
+	collection := storage.database.Collection(playlistVersionsCollection)
+	_, err := collection.InsertOne(storage.context(), version)
+	return err
+}
+
+// GetLatestPlaylistVersion - most recent version of playlist, nil if it has never been captured
+func (storage *Storage) GetLatestPlaylistVersion(userID string, playlistID string) (*spotify.PlaylistVersion, error) {
+	var version spotify.PlaylistVersion
+	collection := storage.database.Collection(playlistVersionsCollection)
+	err := collection.FindOne(storage.context(),
+		map[string]string{"user_id": userID, "playlist_id": playlistID},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&version)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetPlaylistVersion - version of playlist by its number, nil if it doesn't exist
+func (storage *Storage) GetPlaylistVersion(userID string, playlistID string, version int) (*spotify.PlaylistVersion, error) {
+	var container spotify.PlaylistVersion
+	collection := storage.database.Collection(playlistVersionsCollection)
+	err := collection.FindOne(storage.context(),
+		map[string]interface{}{"user_id": userID, "playlist_id": playlistID, "version": version},
+	).Decode(&container)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// GetPlaylistVersions - every version of playlist oldest first
+func (storage *Storage) GetPlaylistVersions(userID string, playlistID string) ([]spotify.PlaylistVersion, error) {
+	versions := []spotify.PlaylistVersion{}
+	collection := storage.database.Collection(playlistVersionsCollection)
+	cursor, err := collection.Find(storage.context(),
+		map[string]string{"user_id": userID, "playlist_id": playlistID},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &versions)
+	return versions, err
+}
+
+// DeletePlaylistVersions - delete every captured playlist version of user
+func (storage *Storage) DeletePlaylistVersions(userID string) error {
+	collection := storage.database.Collection(playlistVersionsCollection)
+	_, err := collection.DeleteMany(storage.context(), map[string]string{"user_id": userID})
+	return err
+}
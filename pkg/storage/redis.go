@@ -2,13 +2,21 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
+	"utilserver/pkg/breaker"
+	"utilserver/pkg/metrics"
+	"utilserver/pkg/ratelimit"
+	"utilserver/pkg/tracing"
 
 	"github.com/go-redis/redis/v9"
 )
 
 type Cache struct {
 	client *redis.Client
+	ctx    context.Context
 }
 
 func NewCache(connectionString string) (*Cache, error) {
@@ -18,20 +26,245 @@ func NewCache(connectionString string) (*Cache, error) {
 		return nil, err
 	}
 	client := redis.NewClient(opt)
+	client.AddHook(tracingHook{})
+	client.AddHook(breakerHook{breaker: breaker.NewFromEnv("redis")})
 	redisInstance.client = client
 	return redisInstance, nil
 }
 
+// BindContext - cache whose commands are traced as part of ctx, cancelling ctx doesn't cancel them
+func (redisInstance *Cache) BindContext(ctx context.Context) interface{} {
+	bound := *redisInstance
+	bound.ctx = tracing.Detach(ctx)
+	return &bound
+}
+
+// Ping - check that redis answers
+func (redisInstance *Cache) Ping(ctx context.Context) error {
+	return redisInstance.client.Ping(ctx).Err()
+}
+
+func (redisInstance *Cache) context() context.Context {
+	if redisInstance.ctx == nil {
+		return context.TODO()
+	}
+	return redisInstance.ctx
+}
+
 //set value in redis
 func (redisInstance *Cache) Set(key string, value interface{}, expiration time.Duration) error {
-	return redisInstance.client.Set(context.TODO(), key, value, expiration).Err()
+	return redisInstance.client.Set(redisInstance.context(), key, value, expiration).Err()
 }
 
 func (redisInstance *Cache) Get(key string) (interface{}, error) {
-	return redisInstance.client.Get(context.TODO(), key).Result()
+	value, err := redisInstance.client.Get(redisInstance.context(), key).Result()
+	switch {
+	case err == redis.Nil:
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
+	case err != nil:
+		metrics.CacheLookups.WithLabelValues("error").Inc()
+	default:
+		metrics.CacheLookups.WithLabelValues("hit").Inc()
+	}
+	return value, err
+}
+
+// Lookup - value of key as string, found is false when the key doesn't exist. Unlike Get a
+// missing key isn't an error, so callers tell it apart from redis being unreachable
+func (redisInstance *Cache) Lookup(key string) (string, bool, error) {
+	value, err := redisInstance.Get(key)
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	str, _ := value.(string)
+	return str, true, nil
 }
 
 // clear cache with key from parameter
 func (redisInstance *Cache) Clear(key string) error {
-	return redisInstance.client.Del(context.TODO(), key).Err()
+	return redisInstance.client.Del(redisInstance.context(), key).Err()
+}
+
+// clear every key starting with prefix, glob characters of prefix are matched literally
+func (redisInstance *Cache) ClearPrefix(prefix string) error {
+	pattern := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(prefix) + "*"
+	iter := redisInstance.client.Scan(redisInstance.context(), 0, pattern, 100).Iterator()
+	keys := []string{}
+	for iter.Next(redisInstance.context()) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return redisInstance.client.Del(redisInstance.context(), keys...).Err()
+}
+
+// MigrateToUserIDs - move revoked sessions keyed by email to the user id userIDOf finds for the
+// email, a newer revocation of the user id is kept. Other keys of users keyed by email only hold
+// now playing state and are dropped. Ids never contain @, so only legacy keys match. Safe to run
+// repeatedly
+func (redisInstance *Cache) MigrateToUserIDs(userIDOf func(email string) (string, error)) error {
+	ctx := redisInstance.context()
+	iter := redisInstance.client.Scan(ctx, 0, "session:revoked:*@*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		userID, err := userIDOf(strings.TrimPrefix(key, "session:revoked:"))
+		if err != nil {
+			return err
+		}
+		if userID != "" {
+			revokedAt, err := redisInstance.client.Get(ctx, key).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			ttl, err := redisInstance.client.PTTL(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			if revokedAt != "" && ttl > 0 {
+				if err := redisInstance.client.SetNX(ctx, "session:revoked:"+userID, revokedAt, ttl).Err(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := redisInstance.client.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	iter = redisInstance.client.Scan(ctx, 0, "user:*@*:*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := redisInstance.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// set value only if key doesn't exist yet, return true when value has been set
+func (redisInstance *Cache) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	return redisInstance.client.SetNX(redisInstance.context(), key, value, expiration).Result()
+}
+
+// locks are only extended or released by the holder, the value of the lock is compared with
+// the token of the caller in the same script
+var extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ExtendLock - reset expiration of lock taken with SetNX when it's still held with token,
+// returns false when the lock expired or another holder took it
+func (redisInstance *Cache) ExtendLock(key string, token string, expiration time.Duration) (bool, error) {
+	extended, err := extendLockScript.Run(redisInstance.context(), redisInstance.client, []string{key},
+		token, expiration.Milliseconds()).Int()
+	return extended == 1, err
+}
+
+// ReleaseLock - delete lock taken with SetNX when it's still held with token
+func (redisInstance *Cache) ReleaseLock(key string, token string) error {
+	return releaseLockScript.Run(redisInstance.context(), redisInstance.client, []string{key}, token).Err()
+}
+
+// value is only replaced by a greater one, compared and set in the same script so concurrent
+// writers can't lower it
+var setIfGreaterScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]))
+if current and current >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// SetIfGreater - set numeric value of key unless it already holds a greater or equal one,
+// returns true when value has been set
+func (redisInstance *Cache) SetIfGreater(key string, value int64, expiration time.Duration) (bool, error) {
+	set, err := setIfGreaterScript.Run(redisInstance.context(), redisInstance.client, []string{key},
+		value, expiration.Milliseconds()).Int()
+	return set == 1, err
+}
+
+// publish message to redis pub/sub channel
+func (redisInstance *Cache) Publish(channel string, message interface{}) error {
+	return redisInstance.client.Publish(redisInstance.context(), channel, message).Err()
+}
+
+// subscribe to redis pub/sub channel, returned channel is closed after calling close func
+func (redisInstance *Cache) Subscribe(channel string) (<-chan string, func() error) {
+	pubsub := redisInstance.client.Subscribe(context.TODO(), channel)
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		for message := range pubsub.Channel() {
+			messages <- message.Payload
+		}
+	}()
+	return messages, pubsub.Close
+}
+
+// token bucket refilled continuously from time of redis so replicas with drifting clocks
+// agree, tokens are only taken while reserve is left after taking them. Returns whether
+// tokens were taken, tokens left and ms until the asked tokens are there
+var takeTokensScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / 1000
+local asked = tonumber(ARGV[3])
+local reserve = tonumber(ARGV[4])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or capacity
+local at = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - at) * rate)
+local allowed = 0
+local wait = 0
+if tokens - asked >= reserve then
+	tokens = tokens - asked
+	allowed = 1
+else
+	wait = math.ceil((asked + reserve - tokens) / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate) + 1000)
+return {allowed, tostring(tokens), wait}
+`)
+
+// TakeTokens - take tokens from bucket shared by every replica, leaving reserve in it
+func (redisInstance *Cache) TakeTokens(key string, bucket ratelimit.Bucket, tokens float64, reserve float64) (ratelimit.Result, error) {
+	reply, err := takeTokensScript.Run(redisInstance.context(), redisInstance.client, []string{key},
+		bucket.Capacity, bucket.Rate(), tokens, reserve).Slice()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	if len(reply) != 3 {
+		return ratelimit.Result{}, errors.New("unexpected token bucket reply")
+	}
+	allowed, _ := reply[0].(int64)
+	remainingStr, _ := reply[1].(string)
+	remaining, _ := strconv.ParseFloat(remainingStr, 64)
+	wait, _ := reply[2].(int64)
+	return ratelimit.Result{
+		Allowed:    allowed == 1,
+		Remaining:  remaining,
+		RetryAfter: time.Duration(wait) * time.Millisecond,
+		ResetAfter: time.Duration((bucket.Capacity-remaining)/bucket.Rate()*1000) * time.Millisecond,
+	}, nil
 }
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/accounts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usersCollection = "users"
+
+// legacy index over provider and subject of every linked account, multikey compound indexes
+// combine values of different accounts of a user and reject users who don't share an account
+const legacyAccountIndex = "accounts.provider_1_accounts.subject_1"
+
+// EnsureUserIndexes - an account of a provider belongs to one user only. Accounts linked before
+// they had keys get them first
+func (storage *Storage) EnsureUserIndexes() error {
+	collection := storage.database.Collection(usersCollection)
+	_, err := collection.UpdateMany(storage.context(),
+		bson.M{"accounts": bson.M{"$elemMatch": bson.M{"key": bson.M{"$exists": false}}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"accounts": bson.M{"$map": bson.M{
+			"input": "$accounts",
+			"in": bson.M{"$mergeObjects": bson.A{"$$this", bson.M{
+				"key": bson.M{"$concat": bson.A{"$$this.provider", ":", "$$this.subject"}},
+			}}},
+		}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if err := storage.dropIndex(usersCollection, legacyAccountIndex); err != nil {
+		return err
+	}
+	_, err = collection.Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys: bson.D{{Key: "accounts.key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"accounts.key": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// key of linked account in the unique index
+func accountKey(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+func userFilter(id string) (bson.M, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, accounts.ErrUserNotFound
+	}
+	return bson.M{"_id": objectID}, nil
+}
+
+func (storage *Storage) findUser(filter interface{}) (*accounts.User, error) {
+	var user accounts.User
+	collection := storage.database.Collection(usersCollection)
+	err := collection.FindOne(storage.context(), filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser - store new user
+func (storage *Storage) CreateUser(user accounts.User) (*accounts.User, error) {
+	for i, account := range user.Accounts {
+		user.Accounts[i].Key = accountKey(account.Provider, account.Subject)
+	}
+	collection := storage.database.Collection(usersCollection)
+	result, err := collection.InsertOne(storage.context(), user)
+	if err != nil {
+		return nil, err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return &user, nil
+}
+
+// GetUser - user by id, nil if it doesn't exist
+func (storage *Storage) GetUser(id string) (*accounts.User, error) {
+	filter, err := userFilter(id)
+	if err != nil {
+		return nil, nil
+	}
+	return storage.findUser(filter)
+}
+
+// FindUserByAccount - user who linked account of provider, nil if nobody did
+func (storage *Storage) FindUserByAccount(provider string, subject string) (*accounts.User, error) {
+	return storage.findUser(bson.M{"accounts.key": accountKey(provider, subject)})
+}
+
+// LinkAccount - add linked account to user unless user has an account of the provider already
+func (storage *Storage) LinkAccount(userID string, account accounts.LinkedAccount) error {
+	filter, err := userFilter(userID)
+	if err != nil {
+		return err
+	}
+	filter["accounts.provider"] = bson.M{"$ne": account.Provider}
+	account.Key = accountKey(account.Provider, account.Subject)
+	collection := storage.database.Collection(usersCollection)
+	result, err := collection.UpdateOne(storage.context(), filter, bson.M{
+		"$push": bson.M{"accounts": account},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		if isDuplicateKey(err) {
+			return accounts.ErrAccountLinked
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return accounts.ErrProviderLinked
+	}
+	return nil
+}
+
+// UpdateLinkedAccount - replace linked account of user, like after a new login refreshed its credentials
+func (storage *Storage) UpdateLinkedAccount(userID string, account accounts.LinkedAccount) error {
+	filter, err := userFilter(userID)
+	if err != nil {
+		return err
+	}
+	filter["accounts.provider"] = account.Provider
+	account.Key = accountKey(account.Provider, account.Subject)
+	collection := storage.database.Collection(usersCollection)
+	_, err = collection.UpdateOne(storage.context(), filter, bson.M{
+		"$set": bson.M{"accounts.$": account, "updated_at": time.Now()},
+	})
+	return err
+}
+
+// UnlinkAccount - remove linked account of provider from user
+func (storage *Storage) UnlinkAccount(userID string, provider string) error {
+	filter, err := userFilter(userID)
+	if err != nil {
+		return err
+	}
+	collection := storage.database.Collection(usersCollection)
+	_, err = collection.UpdateOne(storage.context(), filter, bson.M{
+		"$pull": bson.M{"accounts": bson.M{"provider": provider}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
+
+// DeleteUser - delete user with linked accounts
+func (storage *Storage) DeleteUser(id string) error {
+	filter, err := userFilter(id)
+	if err != nil {
+		return nil
+	}
+	collection := storage.database.Collection(usersCollection)
+	_, err = collection.DeleteOne(storage.context(), filter)
+	return err
+}
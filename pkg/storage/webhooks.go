@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"time"
+	"utilserver/pkg/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhookEndpointsCollection  = "webhook-endpoints"
+	webhookDeliveriesCollection = "webhook-deliveries"
+)
+
+// delivery log is kept for a month
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
+// EnsureWebhookIndexes - create indexes of webhook endpoints and delivery log
+func (storage *Storage) EnsureWebhookIndexes() error {
+	_, err := storage.database.Collection(webhookEndpointsCollection).Indexes().CreateOne(storage.context(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = storage.database.Collection(webhookDeliveriesCollection).Indexes().CreateMany(storage.context(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "endpoint_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(webhookDeliveryRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// CreateWebhookEndpoint - store new endpoint
+func (storage *Storage) CreateWebhookEndpoint(endpoint webhooks.Endpoint) (*webhooks.Endpoint, error) {
+	collection := storage.database.Collection(webhookEndpointsCollection)
+	result, err := collection.InsertOne(storage.context(), endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.ID = result.InsertedID.(primitive.ObjectID)
+	return &endpoint, nil
+}
+
+// GetWebhookEndpoint - endpoint of user, nil if it doesn't exist
+func (storage *Storage) GetWebhookEndpoint(userID string, id string) (*webhooks.Endpoint, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+	var endpoint webhooks.Endpoint
+	collection := storage.database.Collection(webhookEndpointsCollection)
+	err = collection.FindOne(storage.context(), bson.M{"_id": objectID, "user_id": userID}).Decode(&endpoint)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// ListWebhookEndpoints - endpoints of user oldest first
+func (storage *Storage) ListWebhookEndpoints(userID string) ([]webhooks.Endpoint, error) {
+	endpoints := []webhooks.Endpoint{}
+	collection := storage.database.Collection(webhookEndpointsCollection)
+	cursor, err := collection.Find(storage.context(),
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &endpoints)
+	return endpoints, err
+}
+
+// DeleteWebhookEndpoint - delete endpoint of user with its pending deliveries, false if it doesn't exist
+func (storage *Storage) DeleteWebhookEndpoint(userID string, id string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	result, err := storage.database.Collection(webhookEndpointsCollection).DeleteOne(storage.context(),
+		bson.M{"_id": objectID, "user_id": userID},
+	)
+	if err != nil || result.DeletedCount == 0 {
+		return false, err
+	}
+	_, err = storage.database.Collection(webhookDeliveriesCollection).DeleteMany(storage.context(),
+		bson.M{"endpoint_id": objectID, "status": webhooks.DeliveryPending},
+	)
+	return true, err
+}
+
+// CreateWebhookDeliveries - store new deliveries and return them with their ids
+func (storage *Storage) CreateWebhookDeliveries(deliveries []webhooks.Delivery) ([]webhooks.Delivery, error) {
+	documents := make([]interface{}, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		documents = append(documents, delivery)
+	}
+	collection := storage.database.Collection(webhookDeliveriesCollection)
+	result, err := collection.InsertMany(storage.context(), documents)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range result.InsertedIDs {
+		deliveries[i].ID = id.(primitive.ObjectID)
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery - pending delivery due the longest, its next attempt is moved by lease
+// so other replicas don't claim it while it's attempted. Nil if no delivery is due
+func (storage *Storage) ClaimWebhookDelivery(now time.Time, lease time.Duration) (*webhooks.Delivery, error) {
+	var delivery webhooks.Delivery
+	collection := storage.database.Collection(webhookDeliveriesCollection)
+	err := collection.FindOneAndUpdate(storage.context(),
+		bson.M{"status": webhooks.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// UpdateWebhookDelivery - store status and attempts of delivery
+func (storage *Storage) UpdateWebhookDelivery(delivery webhooks.Delivery) error {
+	collection := storage.database.Collection(webhookDeliveriesCollection)
+	_, err := collection.UpdateOne(storage.context(),
+		bson.M{"_id": delivery.ID},
+		bson.M{"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"delivered_at":    delivery.DeliveredAt,
+		}},
+	)
+	return err
+}
+
+// GetWebhookDelivery - delivery of user, nil if it doesn't exist
+func (storage *Storage) GetWebhookDelivery(userID string, id string) (*webhooks.Delivery, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+	var delivery webhooks.Delivery
+	collection := storage.database.Collection(webhookDeliveriesCollection)
+	err = collection.FindOne(storage.context(), bson.M{"_id": objectID, "user_id": userID}).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries - newest deliveries to endpoint of user
+func (storage *Storage) ListWebhookDeliveries(userID string, endpointID string, limit int) ([]webhooks.Delivery, error) {
+	deliveries := []webhooks.Delivery{}
+	objectID, err := primitive.ObjectIDFromHex(endpointID)
+	if err != nil {
+		return deliveries, nil
+	}
+	collection := storage.database.Collection(webhookDeliveriesCollection)
+	cursor, err := collection.Find(storage.context(),
+		bson.M{"user_id": userID, "endpoint_id": objectID},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(storage.context(), &deliveries)
+	return deliveries, err
+}
+
+// DeleteWebhooks - delete endpoints and delivery log of user
+func (storage *Storage) DeleteWebhooks(userID string) error {
+	if _, err := storage.database.Collection(webhookEndpointsCollection).DeleteMany(storage.context(), bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := storage.database.Collection(webhookDeliveriesCollection).DeleteMany(storage.context(), bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "utilserver"
+	defaultServiceName  = "utilserver"
+)
+
+// exporters selected by OTEL_TRACES_EXPORTER
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Config - tracing setup, OTLP endpoint, headers and tls are read by the exporter from
+// the standard OTEL_EXPORTER_OTLP_* variables
+type Config struct {
+	Exporter    string
+	ServiceName string
+	// SampleRatio - share of traces started here that are recorded, 0 to 1
+	SampleRatio float64
+}
+
+// ConfigFromEnv - config of OTEL_TRACES_EXPORTER, OTEL_SERVICE_NAME and TRACE_SAMPLE_RATIO
+func ConfigFromEnv() Config {
+	config := Config{
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+		SampleRatio: 1,
+	}
+	if ratio, err := strconv.ParseFloat(os.Getenv("TRACE_SAMPLE_RATIO"), 64); err == nil {
+		config.SampleRatio = ratio
+	}
+	return config
+}
+
+// Setup - install global tracer provider and W3C propagation, returned func flushes and
+// stops the exporter. Spans aren't exported with exporter none
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.Exporter == "" || config.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := newProvider(sdktrace.NewBatchSpanProcessor(exporter), config)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func newProvider(processor sdktrace.SpanProcessor, config Config) *sdktrace.TracerProvider {
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+}
+
+// Start - span of operation as child of span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End - end span recording err when there is one
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject - add W3C trace headers of span in ctx to carrier
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Extract - context continuing trace of W3C headers in carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Detach - context carrying span of ctx without its deadline and cancellation
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// TraceID - id of trace in ctx, empty when there is none
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// UserID - attribute of user a span is done for
+func UserID(userID string) attribute.KeyValue {
+	return attribute.String("enduser.id", userID)
+}
+
+// ContextBinder - dependency whose calls can be bound to context of a request,
+// BindContext returns copy of dependency of the same type
+type ContextBinder interface {
+	BindContext(ctx context.Context) interface{}
+}
+
+// Bind - dependency bound to ctx when it supports it, the dependency itself otherwise
+func Bind(dependency interface{}, ctx context.Context) interface{} {
+	if binder, ok := dependency.(ContextBinder); ok {
+		return binder.BindContext(ctx)
+	}
+	return dependency
+}
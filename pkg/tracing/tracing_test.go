@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newInMemory - install global tracer provider keeping every span in memory
+func newInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(newProvider(sdktrace.NewSimpleSpanProcessor(exporter), Config{SampleRatio: 1}))
+	return exporter
+}
+
+func TestSpanContinuesPropagatedTrace(t *testing.T) {
+	exporter := newInMemory()
+	ctx, parent := Start(context.Background(), "request")
+	headers := http.Header{}
+	Inject(ctx, propagation.HeaderCarrier(headers))
+	End(parent, nil)
+
+	cancelled, cancel := context.WithCancel(Extract(context.Background(), propagation.HeaderCarrier(headers)))
+	cancel()
+	_, child := Start(Detach(cancelled), "job")
+	End(child, errors.New("failed"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	recorded := spans[1]
+	if recorded.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("child span isn't parented by the propagated span")
+	}
+	if recorded.SpanContext.TraceID().String() != TraceID(ctx) {
+		t.Errorf("child span left trace %s", TraceID(ctx))
+	}
+	if recorded.Status.Code != codes.Error || len(recorded.Events) != 1 {
+		t.Errorf("error of child span not recorded")
+	}
+}
@@ -0,0 +1,79 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"utilserver/pkg/clients"
+)
+
+// ErrForbiddenAddress - endpoint resolves to an address of this network, like loopback, private
+// ranges or the metadata service of the cloud, which users must not reach through deliveries
+var ErrForbiddenAddress = errors.New("webhook endpoint must resolve to a public address")
+
+// private and reserved ranges which aren't covered by the checks of net.IP
+var forbiddenNetworks = func() []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // this network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier grade nat
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"240.0.0.0/4",    // reserved
+		"64:ff9b::/96",   // nat64 of ipv4 addresses
+		"fc00::/7",       // unique local, private of ipv6
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// publicAddress - whether deliveries may be sent to ip
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost - resolve host of endpoint and fail with ErrForbiddenAddress unless every address
+// of it is public
+func checkHost(ctx context.Context, host string) error {
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if !publicAddress(address.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// allowPublic - guard of deliveries, ErrForbiddenAddress for addresses which aren't public
+func allowPublic(ip net.IP) error {
+	if !publicAddress(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// newDeliveryClient - client of deliveries sharing tls and pool settings of outbound http, it
+// only reaches public addresses and doesn't follow redirects, a redirect is recorded as the
+// status of the attempt
+func newDeliveryClient(config clients.Config) (*http.Client, error) {
+	config.Timeout = deliveryTimeout
+	return clients.NewGuarded(config, allowPublic)
+}
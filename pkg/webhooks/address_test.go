@@ -0,0 +1,50 @@
+package webhooks
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:10.1.2.3", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a01:203", false},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			ip := net.ParseIP(test.ip)
+			if ip == nil {
+				t.Fatalf("invalid address %s", test.ip)
+			}
+			if public := publicAddress(ip); public != test.public {
+				t.Errorf("expected public %v, got %v", test.public, public)
+			}
+			err := allowPublic(ip)
+			if test.public && err != nil {
+				t.Errorf("expected address to be allowed, got %v", err)
+			}
+			if !test.public && err != ErrForbiddenAddress {
+				t.Errorf("expected ErrForbiddenAddress, got %v", err)
+			}
+		})
+	}
+}
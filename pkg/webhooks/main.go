@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// events endpoints subscribe to
+const (
+	EventUserLoggedIn    = "user.logged_in"
+	EventReauthRequired  = "user.reauth_required"
+	EventTrackPlayed     = "track.played"
+	EventPlaylistChanged = "playlist.changed"
+	EventTopItemsChanged = "top_items.changed"
+)
+
+// statuses of delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// headers of delivery request
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// retries back off exponentially from first delay, attempts in flight are leased
+// so a delivery isn't sent twice by replicas at the same time. Responses are only read
+// up to a limit so connections can be reused
+const (
+	maxDeliveryAttempts = 10
+	firstRetryDelay     = 30 * time.Second
+	maxRetryDelay       = 6 * time.Hour
+	deliveryTimeout     = 10 * time.Second
+	deliveryLease       = time.Minute
+	deliveryWorkers     = 8
+	maxDrainedResponse  = 4096
+)
+
+// Events - every event endpoints can subscribe to
+var Events = []string{
+	EventUserLoggedIn,
+	EventReauthRequired,
+	EventTrackPlayed,
+	EventPlaylistChanged,
+	EventTopItemsChanged,
+}
+
+var (
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// ArgumentError - invalid endpoint registration
+type ArgumentError struct {
+	Message string
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Message
+}
+
+// Endpoint - url of a tenant receiving events it subscribed to, tenant is the user who
+// registered it and gets events of its own account only. No events means every event
+type Endpoint struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    string             `bson:"user_id" json:"-"`
+	URL       string             `bson:"url" json:"url"`
+	Events    []string           `bson:"events" json:"events"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Subscribed - whether endpoint receives event
+func (endpoint Endpoint) Subscribed(event string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range endpoint.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Event - body posted to endpoints
+type Event struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	UserID     string      `json:"user_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Attempt - one try of delivering an event
+type Attempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMS int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// Delivery - event on its way to an endpoint with log of every attempt
+type Delivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EndpointID    primitive.ObjectID `bson:"endpoint_id" json:"endpoint_id"`
+	UserID        string             `bson:"user_id" json:"-"`
+	EventID       string             `bson:"event_id" json:"event_id"`
+	Event         string             `bson:"event" json:"event"`
+	Payload       json.RawMessage    `bson:"payload" json:"payload"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      []Attempt          `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	DeliveredAt   time.Time          `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	// RedeliveryOf - delivery this one repeats
+	RedeliveryOf *primitive.ObjectID `bson:"redelivery_of,omitempty" json:"redelivery_of,omitempty"`
+}
+
+type Storage interface {
+	CreateWebhookEndpoint(endpoint Endpoint) (*Endpoint, error)
+	GetWebhookEndpoint(userID string, id string) (*Endpoint, error)
+	ListWebhookEndpoints(userID string) ([]Endpoint, error)
+	DeleteWebhookEndpoint(userID string, id string) (bool, error)
+	CreateWebhookDeliveries(deliveries []Delivery) ([]Delivery, error)
+	// ClaimWebhookDelivery - due pending delivery with its next attempt moved by lease, nil if there is none
+	ClaimWebhookDelivery(now time.Time, lease time.Duration) (*Delivery, error)
+	UpdateWebhookDelivery(delivery Delivery) error
+	GetWebhookDelivery(userID string, id string) (*Delivery, error)
+	ListWebhookDeliveries(userID string, endpointID string, limit int) ([]Delivery, error)
+	DeleteWebhooks(userID string) error
+}
@@ -0,0 +1,322 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+	"utilserver/pkg/clients"
+	"utilserver/pkg/logging"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// Service - registration of endpoints and signed delivery of events to them
+type Service struct {
+	storage Storage
+	client  *http.Client
+	logger  *logging.Logger
+}
+
+// NewService - service delivering with outbound http settings of config, fails when they are invalid
+func NewService(storage Storage, config clients.Config, logger *logging.Logger) (*Service, error) {
+	client, err := newDeliveryClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{storage: storage, client: client, logger: logger}, nil
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// RegisterEndpoint - register url receiving events of user, secret signing deliveries
+// is only returned here. Hosts resolving to addresses of this network are refused
+func (service *Service) RegisterEndpoint(userID string, endpointURL string, events []string) (*Endpoint, error) {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, &ArgumentError{Message: "url must be an absolute http or https url"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if err := checkHost(ctx, parsed.Hostname()); err != nil {
+		return nil, &ArgumentError{Message: "url can't be used: " + err.Error()}
+	}
+	for _, event := range events {
+		known := false
+		for _, name := range Events {
+			known = known || name == event
+		}
+		if !known {
+			return nil, &ArgumentError{Message: "unknown event " + event}
+		}
+	}
+	if events == nil {
+		events = []string{}
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	return service.storage.CreateWebhookEndpoint(Endpoint{
+		UserID:    userID,
+		URL:       endpointURL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+}
+
+// ListEndpoints - endpoints registered by user without their secrets
+func (service *Service) ListEndpoints(userID string) ([]Endpoint, error) {
+	endpoints, err := service.storage.ListWebhookEndpoints(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpoint - stop sending events to endpoint, pending deliveries to it are dropped
+func (service *Service) DeleteEndpoint(userID string, id string) error {
+	deleted, err := service.storage.DeleteWebhookEndpoint(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrEndpointNotFound
+	}
+	return nil
+}
+
+// DeleteUserData - remove endpoints and delivery log of user
+func (service *Service) DeleteUserData(userID string) error {
+	return service.storage.DeleteWebhooks(userID)
+}
+
+// Publish - send event of user to every endpoint subscribed to it. Deliveries are stored
+// before the first attempt so failures are retried, publishing never fails the caller
+func (service *Service) Publish(userID string, event string, data interface{}) {
+	if err := service.publish(userID, event, data); err != nil {
+		service.logger.Error("webhook event not published", "event", event, "user_id", userID, "error", err)
+	}
+}
+
+func (service *Service) publish(userID string, event string, data interface{}) error {
+	endpoints, err := service.storage.ListWebhookEndpoints(userID)
+	if err != nil {
+		return err
+	}
+	subscribed := []Endpoint{}
+	for _, endpoint := range endpoints {
+		if endpoint.Subscribed(event) {
+			subscribed = append(subscribed, endpoint)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+	eventID := shortuuid.New()
+	payload, err := json.Marshal(Event{
+		ID:         eventID,
+		Type:       event,
+		UserID:     userID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	deliveries := make([]Delivery, 0, len(subscribed))
+	for _, endpoint := range subscribed {
+		deliveries = append(deliveries, Delivery{
+			EndpointID: endpoint.ID,
+			UserID:     userID,
+			EventID:    eventID,
+			Event:      event,
+			Payload:    payload,
+			Status:     DeliveryPending,
+			Attempts:   []Attempt{},
+			// first attempt is made right away, the lease keeps retry job off it meanwhile
+			NextAttemptAt: now.Add(deliveryLease),
+			CreatedAt:     now,
+		})
+	}
+	deliveries, err = service.storage.CreateWebhookDeliveries(deliveries)
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		go service.attempt(deliveries[i], &subscribed[i])
+	}
+	return nil
+}
+
+// retry delay after given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// attempt - post delivery to its endpoint and record the outcome, endpoint is looked up when nil
+func (service *Service) attempt(delivery Delivery, endpoint *Endpoint) {
+	if endpoint == nil {
+		var err error
+		endpoint, err = service.storage.GetWebhookEndpoint(delivery.UserID, delivery.EndpointID.Hex())
+		if err != nil {
+			service.logger.Warn("webhook delivery failed", "delivery_id", delivery.ID.Hex(), "error", err)
+			return
+		}
+	}
+	if endpoint == nil {
+		// endpoint was deleted since
+		delivery.Status = DeliveryFailed
+		delivery.Attempts = append(delivery.Attempts, Attempt{At: time.Now(), Error: ErrEndpointNotFound.Error()})
+		service.save(delivery)
+		return
+	}
+	result := service.post(*endpoint, delivery)
+	delivery.Attempts = append(delivery.Attempts, result)
+	switch {
+	case result.Error == "" && result.StatusCode < http.StatusMultipleChoices:
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = result.At
+	case len(delivery.Attempts) >= maxDeliveryAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		delivery.NextAttemptAt = time.Now().Add(retryDelay(len(delivery.Attempts)))
+	}
+	service.save(delivery)
+}
+
+func (service *Service) save(delivery Delivery) {
+	if err := service.storage.UpdateWebhookDelivery(delivery); err != nil {
+		service.logger.Error("webhook delivery not saved", "delivery_id", delivery.ID.Hex(), "error", err)
+	}
+}
+
+func (service *Service) post(endpoint Endpoint, delivery Delivery) Attempt {
+	started := time.Now()
+	result := Attempt{At: started}
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.Event)
+	request.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(TimestampHeader, strconv.FormatInt(started.Unix(), 10))
+	request.Header.Set(SignatureHeader, Sign(endpoint.Secret, started, delivery.Payload))
+	resp, err := service.client.Do(request)
+	result.DurationMS = time.Since(started).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	// only the status is kept, bodies would let users read whatever the endpoint answers
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainedResponse))
+	result.StatusCode = resp.StatusCode
+	return result
+}
+
+// DeliverDue - retry deliveries whose next attempt is due, run by scheduler
+func (service *Service) DeliverDue() error {
+	claimed := make(chan Delivery)
+	var wait sync.WaitGroup
+	for i := 0; i < deliveryWorkers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for delivery := range claimed {
+				service.attempt(delivery, nil)
+			}
+		}()
+	}
+	var err error
+	for {
+		var delivery *Delivery
+		delivery, err = service.storage.ClaimWebhookDelivery(time.Now(), deliveryLease)
+		if err != nil || delivery == nil {
+			break
+		}
+		claimed <- *delivery
+	}
+	close(claimed)
+	wait.Wait()
+	return err
+}
+
+// ListDeliveries - newest deliveries to endpoint of user with their attempts
+func (service *Service) ListDeliveries(userID string, endpointID string, limit int) ([]Delivery, error) {
+	endpoint, err := service.storage.GetWebhookEndpoint(userID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		return nil, ErrEndpointNotFound
+	}
+	if limit < 1 || limit > 100 {
+		return nil, &ArgumentError{Message: "limit must be between 1 and 100"}
+	}
+	return service.storage.ListWebhookDeliveries(userID, endpointID, limit)
+}
+
+// Redeliver - send event of delivery again as new delivery, the original stays in the log
+func (service *Service) Redeliver(userID string, deliveryID string) (*Delivery, error) {
+	original, err := service.storage.GetWebhookDelivery(userID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, ErrDeliveryNotFound
+	}
+	endpoint, err := service.storage.GetWebhookEndpoint(userID, original.EndpointID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		return nil, ErrEndpointNotFound
+	}
+	now := time.Now()
+	deliveries, err := service.storage.CreateWebhookDeliveries([]Delivery{{
+		EndpointID:    original.EndpointID,
+		UserID:        userID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        DeliveryPending,
+		Attempts:      []Attempt{},
+		NextAttemptAt: now.Add(deliveryLease),
+		CreatedAt:     now,
+		RedeliveryOf:  &original.ID,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	go service.attempt(deliveries[0], endpoint)
+	return &deliveries[0], nil
+}
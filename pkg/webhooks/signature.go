@@ -0,0 +1,31 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Sign - signature of delivery body, hmac sha256 of timestamp and body joined by a dot.
+// Receivers compute it with the secret of their endpoint and compare it to signature header
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify - whether signature matches body and timestamp isn't older than tolerance
+func Verify(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	signedAt := time.Unix(unix, 0)
+	if time.Since(signedAt) > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, signedAt, body)), []byte(signature))
+}
@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"top_items.changed"}`)
+	signature := Sign("secret", at, body)
+	if signature != Sign("secret", at, body) {
+		t.Fatal("expected signature to be deterministic")
+	}
+	tests := []struct {
+		name   string
+		secret string
+		at     time.Time
+		body   []byte
+	}{
+		{"other secret", "other", at, body},
+		{"other timestamp", "secret", at.Add(time.Second), body},
+		{"other body", "secret", at, []byte(`{"event":"playlist.changed"}`)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if Sign(test.secret, test.at, test.body) == signature {
+				t.Error("expected signature to change")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"event":"top_items.changed"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign("secret", now, body)
+	old := now.Add(-10 * time.Minute)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+		valid     bool
+	}{
+		{"valid", "secret", timestamp, body, signature, true},
+		{"wrong secret", "other", timestamp, body, signature, false},
+		{"tampered body", "secret", timestamp, []byte(`{"event":"other"}`), signature, false},
+		{"tampered timestamp", "secret", strconv.FormatInt(now.Unix()+1, 10), body, signature, false},
+		{"malformed timestamp", "secret", "yesterday", body, signature, false},
+		{"missing signature", "secret", timestamp, body, "", false},
+		{"older than tolerance", "secret", strconv.FormatInt(old.Unix(), 10), body, Sign("secret", old, body), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := Verify(test.secret, test.timestamp, test.body, test.signature, 5*time.Minute); valid != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, valid)
+			}
+		})
+	}
+}